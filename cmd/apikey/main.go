@@ -0,0 +1,178 @@
+// Command apikey manages API keys from the command line, e.g. to create the
+// first admin key:
+//
+//	apikey create -name ops -owner acme -role admin
+//	apikey list
+//	apikey rotate -id 3 -grace 1h
+//	apikey revoke -id 3
+//
+// It also assigns notes created before tenants existed to a tenant:
+//
+//	apikey assign-notes -owner acme
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.WarnLevel)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	db := database.Connect(cfg.Database)
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "create":
+		err = create(ctx, db, os.Args[2:])
+	case "list":
+		err = list(ctx, db)
+	case "rotate":
+		err = rotate(ctx, db, os.Args[2:])
+	case "revoke":
+		err = revoke(ctx, db, os.Args[2:])
+	case "assign-notes":
+		err = assignNotes(ctx, db, os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: apikey create -name NAME -owner OWNER [-role viewer|editor|admin] [-expires 720h]")
+	fmt.Fprintln(os.Stderr, "       apikey list")
+	fmt.Fprintln(os.Stderr, "       apikey rotate -id ID [-grace 24h]")
+	fmt.Fprintln(os.Stderr, "       apikey revoke -id ID")
+	fmt.Fprintln(os.Stderr, "       apikey assign-notes -owner OWNER")
+	os.Exit(2)
+}
+
+func create(ctx context.Context, db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "key name, e.g. the client using it")
+	owner := fs.String("owner", "", "tenant the key belongs to")
+	roleName := fs.String("role", string(auth.DefaultRole), "viewer, editor or admin")
+	expires := fs.Duration("expires", 0, "expire the key after this duration (0 = never)")
+	fs.Parse(args)
+
+	if *name == "" || *owner == "" {
+		return fmt.Errorf("-name and -owner are required")
+	}
+
+	role, err := auth.ParseRole(*roleName)
+	if err != nil {
+		return err
+	}
+
+	var expiresAt *time.Time
+	if *expires > 0 {
+		t := time.Now().Add(*expires)
+		expiresAt = &t
+	}
+
+	key, stored, err := auth.IssueAPIKey(ctx, db, *name, *owner, role, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created key %d (%s) for %s. Store it now, it is not shown again:\n\n%s\n", stored.ID, stored.Name, stored.Owner, key)
+	return nil
+}
+
+func list(ctx context.Context, db *database.DB) error {
+	keys, err := db.ListAPIKeys(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tOWNER\tPREFIX\tROLE\tLAST USED\tREVOKED")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Owner, k.Prefix, k.Role,
+			formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+	}
+	return w.Flush()
+}
+
+func rotate(ctx context.Context, db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	id := fs.Int("id", 0, "key to rotate")
+	grace := fs.Duration("grace", 24*time.Hour, "how long the old key keeps working")
+	fs.Parse(args)
+
+	key, stored, err := auth.RotateAPIKey(ctx, db, *id, "", *grace)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rotated key %d into key %d; the old key stops working in %s. New key:\n\n%s\n", *id, stored.ID, *grace, key)
+	return nil
+}
+
+func revoke(ctx context.Context, db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	id := fs.Int("id", 0, "key to revoke")
+	fs.Parse(args)
+
+	revoked, err := db.RevokeAPIKey(ctx, *id, "", time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return fmt.Errorf("key %d not found or already revoked", *id)
+	}
+
+	fmt.Printf("Revoked key %d\n", *id)
+	return nil
+}
+
+func assignNotes(ctx context.Context, db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("assign-notes", flag.ExitOnError)
+	owner := fs.String("owner", "", "tenant to give the unowned notes to")
+	fs.Parse(args)
+
+	if *owner == "" {
+		return fmt.Errorf("-owner is required")
+	}
+
+	n, err := db.AssignUnownedNotes(ctx, *owner)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Assigned %d unowned notes to %s\n", n, *owner)
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
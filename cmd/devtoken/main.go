@@ -0,0 +1,181 @@
+// Command devtoken creates a local signing key and JWKS and mints bearer tokens
+// with it, to try AUTH_MODE=jwt without an identity provider:
+//
+//	devtoken keygen -dir ./dev-jwks
+//	devtoken sign -key ./dev-jwks/signing-key.pem -sub alice -tenant acme -role editor -ttl 1h
+//
+// Point the API at the generated key set with JWT_JWKS_FILE=./dev-jwks/jwks.json.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"notes-memory-core-rag/internal/auth"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "keygen":
+		err = keygen(os.Args[2:])
+	case "sign":
+		err = sign(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: devtoken keygen [-dir .]")
+	fmt.Fprintln(os.Stderr, "       devtoken sign -key signing-key.pem -sub USER [-tenant TENANT] [-role ROLE] [-ttl 1h] [-iss ISSUER] [-aud AUDIENCE]")
+	os.Exit(2)
+}
+
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	dir := fs.String("dir", ".", "directory for jwks.json and signing-key.pem")
+	fs.Parse(args)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	kid, err := keyID(key.Public())
+	if err != nil {
+		return err
+	}
+
+	jwk, err := auth.PublicJWK(kid, key.Public())
+	if err != nil {
+		return err
+	}
+
+	jwks, err := json.MarshalIndent(auth.JWKSet{Keys: []auth.JWK{jwk}}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	jwksPath := filepath.Join(*dir, "jwks.json")
+	keyPath := filepath.Join(*dir, "signing-key.pem")
+
+	if err := os.WriteFile(jwksPath, append(jwks, '\n'), 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s and %s (kid %s). Start the API with:\n\n  AUTH_MODE=jwt JWT_JWKS_FILE=%s\n", jwksPath, keyPath, kid, jwksPath)
+	return nil
+}
+
+func sign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyPath := fs.String("key", "signing-key.pem", "private key written by keygen")
+	sub := fs.String("sub", "", "user the token is issued to")
+	tenant := fs.String("tenant", "", "tenant claim (tenant_id)")
+	role := fs.String("role", "", "role claim: viewer, editor or admin")
+	ttl := fs.Duration("ttl", time.Hour, "token lifetime")
+	iss := fs.String("iss", "", "issuer claim")
+	aud := fs.String("aud", "", "audience claim")
+	fs.Parse(args)
+
+	if *sub == "" {
+		return fmt.Errorf("-sub is required")
+	}
+
+	raw, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return errors.New("signing key is not PEM encoded")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("unsupported signing key type %T", parsed)
+	}
+
+	kid, err := keyID(signer.Public())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"sub": *sub,
+		"iat": now.Unix(),
+		"exp": now.Add(*ttl).Unix(),
+	}
+	if *tenant != "" {
+		claims["tenant_id"] = *tenant
+	}
+	if *role != "" {
+		claims["role"] = *role
+	}
+	if *iss != "" {
+		claims["iss"] = *iss
+	}
+	if *aud != "" {
+		claims["aud"] = *aud
+	}
+
+	token, err := auth.SignJWT(signer, kid, claims)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// keyID derives a stable key ID from the public key, so sign finds the key keygen published
+func keyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+)
+
+const auditPruneBatchSize = 1000
+
+// auditJob records the data a job accessed or changed. The job ID stands in for
+// the request ID, linking the entry to the API's "create job" entry.
+func (w *worker) auditJob(ctx context.Context, job database.QueuedJob, action string, resource string, targetIDs []string) {
+	entry := database.AuditEntry{
+		Actor:      "worker:" + workerID,
+		AuthMethod: "worker",
+		Action:     action,
+		Resource:   resource,
+		TargetIDs:  targetIDs,
+		RequestID:  &job.ID,
+	}
+	if job.Owner != "" {
+		entry.Owner = &job.Owner
+	}
+
+	if err := w.audit.RecordAudit(ctx, entry, map[string]string{"job_id": job.ID}); err != nil {
+		zlog.Error().Err(err).Str("job_id", job.ID).Msg("Failed to write audit log")
+	}
+}
+
+// pruneAuditTask deletes audit entries older than AUDIT_RETENTION every
+// AUDIT_PRUNE_INTERVAL. Only started when a retention is configured.
+func (w *worker) pruneAuditTask(ctx context.Context, cfg config.Worker) {
+	ticker := time.NewTicker(cfg.AuditPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.pruneAuditLog(ctx, cfg.AuditRetention)
+
+		case <-ctx.Done():
+			zlog.Info().Msg("Stopping audit prune task")
+			return
+		}
+	}
+}
+
+func (w *worker) pruneAuditLog(ctx context.Context, maxAge time.Duration) {
+	before := time.Now().Add(-maxAge)
+
+	deleted := 0
+	for {
+		n, err := w.db.PruneAuditLog(ctx, before, auditPruneBatchSize)
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to prune audit log")
+			break
+		}
+
+		deleted += n
+		if n < auditPruneBatchSize {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		zlog.Info().
+			Int("deleted", deleted).
+			Dur("max_age", maxAge).
+			Str("worker_id", workerID).
+			Msg("🧹 Pruned old audit log entries")
+	}
+}
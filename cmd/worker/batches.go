@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/handlers"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// finishBatchItem updates the batch a finished job belongs to. Listeners are told
+// about the progress, and once every item is final the batch is completed and
+// its callback notified.
+func (w *worker) finishBatchItem(ctx context.Context, job database.QueuedJob) {
+	batchID, batch, err := w.jobs.FinishBatchItem(ctx, job.ID)
+	if err != nil {
+		zlog.Error().Err(err).Str("job_id", job.ID).Msg("Failed to update batch job")
+		return
+	}
+
+	if batchID == "" {
+		return
+	}
+
+	if batch == nil {
+		// Still running: wake long-polling and SSE listeners with the new progress
+		w.publishStatus(ctx, batchID, "processing", 0, nil)
+		return
+	}
+
+	var batchErr error
+	if batch.Error != nil {
+		batchErr = errors.New(*batch.Error)
+	}
+
+	event := database.EventCompleted
+	if batch.Status == "failed" {
+		event = database.EventFailed
+	}
+	w.recordEvent(ctx, batch.ID, event, 0, batchErr, nil)
+
+	zlog.Info().
+		Str("job_id", batch.ID).
+		Str("status", batch.Status).
+		Msg("📦 Batch job finished")
+
+	w.publishStatus(ctx, batch.ID, batch.Status, 0, batchErr)
+
+	// The batch request shares callback_url with single query requests
+	var req handlers.QueryRequest
+	if err := json.Unmarshal(batch.Input, &req); err != nil {
+		zlog.Warn().Err(err).Str("job_id", batch.ID).Msg("Failed to read batch input for callback")
+		return
+	}
+	w.notifyCallback(batch.ID, req)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"notes-memory-core-rag/internal/handlers"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// callbackTimeout bounds all delivery attempts for a single job
+const callbackTimeout = 2 * time.Minute
+
+// notifyCallback sends the final job payload to the client's callback URL.
+// Delivery runs in the background so retries never block the job loop.
+func (w *worker) notifyCallback(jobID string, req handlers.QueryRequest) {
+	if req.CallbackURL == nil || *req.CallbackURL == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), callbackTimeout)
+		defer cancel()
+
+		job, err := w.jobs.GetJobByID(ctx, jobID)
+		if err != nil {
+			zlog.Error().Err(err).Str("job_id", jobID).Msg("Failed to load job for callback")
+			return
+		}
+
+		if err := w.webhooks.Deliver(ctx, jobID, *req.CallbackURL, job); err != nil {
+			zlog.Error().Err(err).Str("job_id", jobID).Msg("❌ Callback delivery failed")
+			return
+		}
+
+		zlog.Info().Str("job_id", jobID).Msg("📨 Callback delivered")
+	}()
+}
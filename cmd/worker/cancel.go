@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// errJobCancelled is the context cause used when a job is cancelled via the API
+var errJobCancelled = errors.New("job cancelled")
+
+// runningJobs tracks the cancel functions of jobs currently being processed
+// by this worker so cancellation signals can abort them.
+type runningJobs struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+var running = &runningJobs{cancels: make(map[string]context.CancelCauseFunc)}
+
+func (r *runningJobs) register(jobID string, cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancels[jobID] = cancel
+}
+
+func (r *runningJobs) unregister(jobID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, jobID)
+}
+
+// cancel aborts the job's context if it is running on this worker
+func (r *runningJobs) cancel(jobID string, reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancel, ok := r.cancels[jobID]
+	if !ok {
+		return false
+	}
+
+	cancel(fmt.Errorf("%w: %s", errJobCancelled, reason))
+	return true
+}
+
+// isCancelled reports whether the job was cancelled, either through its context
+// or by polling the job's status in case a pub/sub message was missed.
+func (w *worker) isCancelled(ctx context.Context, jobID string) bool {
+	if errors.Is(context.Cause(ctx), errJobCancelled) {
+		return true
+	}
+
+	status, err := w.jobs.GetJobStatus(context.Background(), jobID)
+	return err == nil && status == "cancelled"
+}
+
+// listenForCancellations subscribes to cancellation messages published by the API
+func (w *worker) listenForCancellations(ctx context.Context) {
+	cancellations, unsubscribe, err := w.queue.SubscribeJobCancellations(ctx)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to subscribe to job cancellations")
+		return
+	}
+	defer unsubscribe()
+
+	for {
+		select {
+		case cancellation, ok := <-cancellations:
+			if !ok {
+				return
+			}
+
+			if running.cancel(cancellation.JobID, cancellation.Reason) {
+				zlog.Info().
+					Str("job_id", cancellation.JobID).
+					Str("worker_id", workerID).
+					Str("reason", cancellation.Reason).
+					Msg("🛑 Cancelling running job")
+			}
+
+		case <-ctx.Done():
+			zlog.Info().Msg("Stopping cancellation listener")
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"notes-memory-core-rag/internal/database"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// publishStatus broadcasts a job status transition to SSE and long-polling listeners
+func (w *worker) publishStatus(ctx context.Context, jobID string, status string, attempt int, jobErr error) {
+	event := database.JobEvent{
+		JobID:    jobID,
+		Status:   status,
+		Attempt:  attempt,
+		WorkerID: workerID,
+	}
+	if jobErr != nil {
+		event.Error = jobErr.Error()
+	}
+
+	if err := w.queue.PublishJobEvent(ctx, event); err != nil {
+		zlog.Warn().Err(err).Str("job_id", jobID).Msg("Failed to publish job event")
+	}
+}
+
+// recordEvent appends an entry to the job's history in job_events
+func (w *worker) recordEvent(ctx context.Context, jobID string, event string, attempt int, jobErr error, details interface{}) {
+	errMsg := ""
+	if jobErr != nil {
+		errMsg = jobErr.Error()
+	}
+
+	if err := w.jobs.RecordJobEvent(ctx, jobID, event, workerID, attempt, errMsg, details); err != nil {
+		zlog.Warn().Err(err).Str("job_id", jobID).Str("event", event).Msg("Failed to record job event")
+	}
+}
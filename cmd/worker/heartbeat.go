@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"notes-memory-core-rag/internal/database"
+	"os"
+	"sync"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+// heartbeatInterval must stay well below database.WorkerDeadAfter
+const heartbeatInterval = 10 * time.Second
+
+// workerStats are the counters and current job reported in each heartbeat
+type workerStats struct {
+	mu           sync.Mutex
+	currentJobID string
+	processed    int64
+	failed       int64
+	retried      int64
+	conflicts    int64
+}
+
+var stats = &workerStats{}
+
+func (s *workerStats) startJob(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentJobID = jobID
+}
+
+func (s *workerStats) finishJob() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentJobID = ""
+}
+
+// jobCompleted, jobFailed and jobRetried count finished attempts by outcome
+func (s *workerStats) jobCompleted() { s.count(nil) }
+func (s *workerStats) jobFailed()    { s.count(&s.failed) }
+func (s *workerStats) jobRetried()   { s.count(&s.retried) }
+
+// leaseConflict counts an attempt whose outcome was discarded because the lease was stale
+func (s *workerStats) leaseConflict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts++
+}
+
+func (s *workerStats) count(outcome *int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+	if outcome != nil {
+		*outcome++
+	}
+}
+
+// snapshot returns the heartbeat row for this worker
+func (s *workerStats) snapshot(hostname string, queues []string, startedAt time.Time) database.WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := database.WorkerStatus{
+		ID:             workerID,
+		Hostname:       hostname,
+		Version:        version,
+		Queues:         queues,
+		StartedAt:      startedAt,
+		JobsProcessed:  s.processed,
+		JobsFailed:     s.failed,
+		JobsRetried:    s.retried,
+		LeaseConflicts: s.conflicts,
+	}
+	if s.currentJobID != "" {
+		jobID := s.currentJobID
+		status.CurrentJobID = &jobID
+	}
+
+	return status
+}
+
+// heartbeatTask registers the worker and refreshes its heartbeat so the API
+// can report which workers are alive and what they are doing. Workers dead for
+// long are removed every database.WorkerDeadAfter.
+func (w *worker) heartbeatTask(ctx context.Context, queues []queueWeight) {
+	hostname, _ := os.Hostname()
+	startedAt := time.Now().UTC()
+
+	names := make([]string, 0, len(queues))
+	for _, q := range queues {
+		names = append(names, q.Name)
+	}
+
+	beat := func() {
+		if err := w.db.RecordWorkerHeartbeat(ctx, stats.snapshot(hostname, names, startedAt)); err != nil {
+			zlog.Warn().Err(err).Str("worker_id", workerID).Msg("Failed to record heartbeat")
+		}
+	}
+
+	forget := func() {
+		if err := w.db.ForgetDeadWorkers(ctx); err != nil {
+			zlog.Warn().Err(err).Msg("Failed to remove dead workers")
+		}
+	}
+
+	beat()
+	forget()
+	lastForgot := time.Now()
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			beat()
+
+			if time.Since(lastForgot) >= database.WorkerDeadAfter {
+				forget()
+				lastForgot = time.Now()
+			}
+
+		case <-ctx.Done():
+			zlog.Info().Msg("Stopping heartbeat task")
+			return
+		}
+	}
+}
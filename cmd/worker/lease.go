@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"notes-memory-core-rag/internal/database"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// errLeaseLost is the context cause used when this worker no longer owns a job
+var errLeaseLost = errors.New("job lease lost")
+
+// leaseRenewInterval renews well before the visibility timeout expires
+const leaseRenewInterval = visibilityTimeoutMinutes * time.Minute / 3
+
+// renewLease extends the job's visibility timeout while it runs, so a long LLM call
+// can't be reclaimed and processed twice. If the job is taken over, or the lease
+// expires because renewals keep failing, the job context is cancelled.
+// Returns when ctx is done.
+func (w *worker) renewLease(ctx context.Context, lease database.JobLease, attempt int, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+
+	expiresAt := time.Now().Add(visibilityTimeoutMinutes * time.Minute)
+
+	for {
+		select {
+		case <-ticker.C:
+			err := w.jobs.ExtendVisibilityTimeout(ctx, lease, visibilityTimeoutMinutes)
+			if err == nil {
+				expiresAt = time.Now().Add(visibilityTimeoutMinutes * time.Minute)
+				w.recordEvent(ctx, lease.JobID, database.EventVisibilityExtended, attempt, nil, map[string]interface{}{
+					"visibility_timeout": expiresAt,
+					"lease_token":        lease.Token,
+				})
+				continue
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if errors.Is(err, database.ErrJobNotOwned) || time.Now().After(expiresAt) {
+				zlog.Warn().
+					Err(err).
+					Str("job_id", lease.JobID).
+					Str("worker_id", workerID).
+					Int64("lease_token", lease.Token).
+					Msg("⚠️ Lost job lease, aborting")
+				cancel(errLeaseLost)
+				return
+			}
+
+			zlog.Warn().Err(err).Str("job_id", lease.JobID).Msg("Failed to extend visibility timeout, will retry")
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// leaseLost reports whether the job context was cancelled because ownership was lost
+func leaseLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errLeaseLost)
+}
+
+// logLeaseConflict records that a job's outcome was discarded because this
+// worker's lease is stale, and counts it in the heartbeat stats
+func logLeaseConflict(lease database.JobLease, attempt int, err error) {
+	stats.leaseConflict()
+
+	zlog.Warn().
+		Err(err).
+		Str("job_id", lease.JobID).
+		Str("worker_id", workerID).
+		Int64("lease_token", lease.Token).
+		Int("attempt", attempt).
+		Msg("⚠️ Job no longer owned by this worker, discarding outcome")
+}
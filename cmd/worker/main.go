@@ -3,9 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/config"
 	"notes-memory-core-rag/internal/database"
 	"notes-memory-core-rag/internal/handlers"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/retry"
+	"notes-memory-core-rag/internal/store"
+	"notes-memory-core-rag/internal/webhooks"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,15 +22,9 @@ import (
 	zlog "github.com/rs/zerolog/log"
 )
 
-type JobPayload struct {
-	ID    string          `json:"id"`
-	Type  string          `json:"type"`
-	Input json.RawMessage `json:"input"`
-}
-
 const (
-	maxRetries               = 3
 	visibilityTimeoutMinutes = 3
+	queuePollTimeout         = 5 * time.Second
 )
 
 var workerID string
@@ -30,135 +33,352 @@ func init() {
 	workerID = uuid.New().String()[:8]
 }
 
+// worker processes jobs from the queue. Job processing only depends on
+// the store interfaces, so tests can run it against the memory package;
+// maintenance tasks (scheduler, heartbeat, prune dry runs) use db directly.
+type worker struct {
+	jobs     store.JobStore
+	queue    store.Queue
+	audit    store.AuditLog
+	rag      *handlers.RAGPipeline
+	webhooks *webhooks.Sender
+	db       *database.DB
+
+	// retryPolicies holds per-job-type retry settings from JOB_RETRY_POLICIES
+	retryPolicies retry.Policies
+}
+
 func main() {
 	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath, config.RequireAI)
+	if err != nil {
+		zlog.Fatal().Msg("❌ " + err.Error())
+	}
+
 	ctx := context.Background()
 
 	// Load DB + Redis
-	database.Connect()
-	database.InitRedis()
+	db := database.Connect(cfg.Database)
+	redis := database.InitRedis(cfg.Redis)
+	if redis == nil {
+		zlog.Fatal().Msg("❌ The worker requires Redis (REDIS_ADDR)")
+	}
+
+	policies, err := retry.ParsePolicies(cfg.Worker.RetryPolicies)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("❌ Invalid retry policy config")
+	}
+
+	queues, err := parseQueueConfig(cfg.Worker.Queues)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("❌ Invalid queue config")
+	}
 
-	zlog.Info().Str("worker_id", workerID).Msg("⚙️ Worker Started - listening for jobs...")
+	w := &worker{
+		jobs:          db,
+		queue:         redis,
+		audit:         db,
+		rag:           handlers.NewRAGPipeline(db, ai.NewEmbedder(cfg.AI), ai.NewResponder(cfg.AI), quota.New(cfg.Quota, db)),
+		webhooks:      webhooks.NewSender(cfg.Webhooks, db),
+		db:            db,
+		retryPolicies: policies,
+	}
+
+	// Move payloads left in the old single-list queue onto the default queue
+	if moved, err := redis.MigrateLegacyQueue(ctx); err != nil {
+		zlog.Error().Err(err).Msg("Failed to migrate legacy job queue")
+	} else if moved > 0 {
+		zlog.Info().Int("jobs", moved).Msg("🔄 Migrated legacy queued jobs")
+	}
+
+	zlog.Info().
+		Str("worker_id", workerID).
+		Str("version", version).
+		Interface("queues", queues).
+		Msg("⚙️ Worker Started - listening for jobs...")
+
+	// Register the worker and keep its heartbeat fresh
+	go w.heartbeatTask(ctx, queues)
 
 	// Start background task to reclaim timed-out jobs
-	go reclaimJobsTask(ctx)
+	go w.reclaimJobsTask(ctx)
 
-	for {
-		// BLPOP blocks until a job arrives
-		result, err := database.RedisClient.BLPop(ctx, 0*time.Second, "jobs:queue").Result()
-		if err != nil {
-			zlog.Error().Err(err).Msg("BLPOP failed")
-			continue
-		}
+	// Start background task to prune old jobs (disabled unless JOB_RETENTION is set)
+	retention, err := loadRetentionConfig(cfg.Worker)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("❌ Invalid job retention config")
+	}
+	if retention != nil {
+		zlog.Info().
+			Int("rules", len(retention.Rules)).
+			Dur("interval", retention.Interval).
+			Bool("dry_run", retention.DryRun).
+			Msg("🧹 Job retention enabled")
+		go w.pruneJobsTask(ctx, retention)
+	}
 
-		// result[0] = key
-		// result[1] = JSON value
-		raw := result[1]
+	// Start background task to prune the audit log (disabled unless AUDIT_RETENTION is set)
+	if cfg.Worker.AuditRetention > 0 {
+		zlog.Info().
+			Dur("max_age", cfg.Worker.AuditRetention).
+			Dur("interval", cfg.Worker.AuditPruneInterval).
+			Msg("🧹 Audit log retention enabled")
+		go w.pruneAuditTask(ctx, cfg.Worker)
+	}
+
+	// Start background scheduler for delayed and recurring jobs
+	go w.schedulerTask(ctx)
+
+	// Listen for cancellation signals from the API
+	go w.listenForCancellations(ctx)
+
+	w.run(ctx, queues)
+}
 
-		var payload JobPayload
-		if err := json.Unmarshal([]byte(raw), &payload); err != nil {
-			zlog.Error().Err(err).Msg("❌ Failed to unmarshal job payload")
+// run processes jobs until ctx is done
+func (w *worker) run(ctx context.Context, queues []queueWeight) {
+	for ctx.Err() == nil {
+		// Blocks until a job arrives, taking the highest priority payload
+		// from the first non-empty queue in weighted order
+		job, err := w.queue.DequeueJob(ctx, queueNamesByWeight(queues), queuePollTimeout)
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to dequeue job")
+			time.Sleep(time.Second)
 			continue
 		}
+		if job == nil {
+			continue // timed out, re-roll the queue order
+		}
 
-		zlog.Info().Str("job_id", payload.ID).Str("worker_id", workerID).Msg("📥 Job received")
+		zlog.Info().
+			Str("job_id", job.ID).
+			Str("queue", job.Queue).
+			Str("worker_id", workerID).
+			Msg("📥 Job received")
 
 		// Process the job based on type
-		switch payload.Type {
+		switch job.Type {
 		case "query":
-			processQueryJob(ctx, payload)
+			w.processJob(ctx, *job, w.runQueryJob)
+		case "reembed":
+			w.processJob(ctx, *job, w.runReembedJob)
+		case "cleanup":
+			w.processJob(ctx, *job, w.runCleanupJob)
 		default:
-			zlog.Warn().Str("type", payload.Type).Msg("⚠️ Unknown job type")
+			zlog.Warn().Str("type", job.Type).Msg("⚠️ Unknown job type")
 		}
 	}
 }
 
-func processQueryJob(ctx context.Context, job JobPayload) {
-	zlog.Info().Str("job_id", job.ID).Str("worker_id", workerID).Msg("🤖 Processing query job")
+// jobRunner runs one attempt of a job and returns its result. Errors are retried
+// per the job type's retry policy unless marked with retry.Permanent.
+type jobRunner func(ctx context.Context, job database.QueuedJob) (interface{}, error)
+
+// processJob claims a job, runs it under a renewed lease and stores the outcome:
+// the result, a scheduled retry or the final error
+func (w *worker) processJob(ctx context.Context, job database.QueuedJob, run jobRunner) {
+	zlog.Info().
+		Str("job_id", job.ID).
+		Str("type", job.Type).
+		Str("worker_id", workerID).
+		Msg("🤖 Processing job")
 
 	// Atomically claim the job with visibility timeout (prevents double processing)
-	success, err := database.ClaimJobForProcessing(ctx, job.ID, workerID, visibilityTimeoutMinutes)
+	lease, retryCount, err := w.jobs.ClaimJobForProcessing(ctx, job.ID, workerID, visibilityTimeoutMinutes)
 	if err != nil {
 		zlog.Error().Err(err).Str("job_id", job.ID).Msg("Failed to claim job")
 		return
 	}
 
-	if !success {
+	if lease == nil {
 		zlog.Info().Str("job_id", job.ID).Msg("Job already being processed by another worker or timed out")
 		return
 	}
 
-	// Convert raw input JSON into handler request struct
+	stats.startJob(job.ID)
+	defer stats.finishJob()
+
+	attempt := retryCount + 1
+	w.recordEvent(ctx, job.ID, database.EventClaimed, attempt, nil, map[string]interface{}{
+		"lease_token": lease.Token,
+	})
+	w.publishStatus(ctx, job.ID, "processing", attempt, nil)
+
+	// Job context is cancelled if the job is cancelled through the API
+	// or its lease is lost to another worker
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	running.register(job.ID, cancel)
+	defer func() {
+		running.unregister(job.ID)
+		cancel(nil)
+	}()
+
+	go w.renewLease(jobCtx, *lease, attempt, cancel)
+
+	// Every job type shares callback_url with query requests
 	var req handlers.QueryRequest
 	if err := json.Unmarshal(job.Input, &req); err != nil {
-		database.UpdateJobError(ctx, job.ID, "invalid job input")
+		w.failJob(ctx, job, *lease, req, attempt, retry.Permanent(errors.New("invalid job input")))
+		return
+	}
+
+	if w.isCancelled(jobCtx, job.ID) {
+		logJobCancelled(jobCtx, job.ID)
 		return
 	}
 
-	// Run the SAME logic /query handler uses with Retries
-	var lastErr error
+	result, err := run(jobCtx, job)
+	if leaseLost(jobCtx) {
+		logLeaseConflict(*lease, attempt, context.Cause(jobCtx))
+		return
+	}
 
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// For long-running jobs, extend the visibility timeout
-		if attempt > 1 {
-			if err := database.ExtendVisibilityTimeout(ctx, job.ID, workerID, visibilityTimeoutMinutes); err != nil {
-				zlog.Warn().Err(err).Str("job_id", job.ID).Msg("Failed to extend visibility timeout")
+	if err == nil {
+		if updateErr := w.jobs.UpdateJobResult(ctx, *lease, result); updateErr != nil {
+			if errors.Is(updateErr, database.ErrJobNotOwned) {
+				logLeaseConflict(*lease, attempt, updateErr)
+				return
 			}
+			zlog.Error().Err(updateErr).Str("job_id", job.ID).Msg("Failed to store job result")
+			return
 		}
+		zlog.Info().
+			Str("job_id", job.ID).
+			Str("worker_id", workerID).
+			Int("attempt", attempt).
+			Msg("✅ Job completed successfully")
+		stats.jobCompleted()
+		w.recordEvent(ctx, job.ID, database.EventCompleted, attempt, nil, nil)
+		w.publishStatus(ctx, job.ID, "completed", attempt, nil)
+		w.notifyCallback(job.ID, req)
+		w.finishBatchItem(ctx, job)
+		return
+	}
+
+	if w.isCancelled(jobCtx, job.ID) {
+		logJobCancelled(jobCtx, job.ID)
+		return
+	}
+
+	policy := w.retryPolicies.For(job.Type)
+	retryable, retryAfter := retry.Classify(err)
 
-		ragResult, err := handlers.RunRAGPipeline(ctx, req.Query)
-		if err == nil {
-			database.UpdateJobResult(ctx, job.ID, ragResult)
-			zlog.Info().
-				Str("job_id", job.ID).
-				Str("worker_id", workerID).
-				Msg("✅ Job completed successfully")
+	if !retryable || attempt >= policy.MaxAttempts {
+		w.failJob(ctx, job, *lease, req, attempt, err)
+		return
+	}
+
+	// Reschedule through the queue instead of sleeping, so this worker stays free
+	delay := policy.Backoff(attempt)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	retryAt := time.Now().Add(delay)
+
+	if rescheduleErr := w.jobs.RescheduleJobRetry(ctx, *lease, retryAt, err.Error()); rescheduleErr != nil {
+		if errors.Is(rescheduleErr, database.ErrJobNotOwned) {
+			logLeaseConflict(*lease, attempt, rescheduleErr)
 			return
 		}
+		zlog.Error().Err(rescheduleErr).Str("job_id", job.ID).Msg("Failed to reschedule job retry")
+		return
+	}
 
-		lastErr = err
+	w.recordEvent(ctx, job.ID, database.EventAttemptFailed, attempt, err, map[string]interface{}{
+		"retryable": true,
+		"retry_at":  retryAt,
+	})
 
-		// Increment retry count for monitoring/debugging
-		if incrementErr := database.IncrementRetryCount(ctx, job.ID); incrementErr != nil {
-			zlog.Warn().Err(incrementErr).Str("job_id", job.ID).Msg("Failed to increment retry count")
-		}
+	zlog.Warn().
+		Int("attempt", attempt).
+		Int("max_attempts", policy.MaxAttempts).
+		Dur("retry_in", delay).
+		Err(err).
+		Str("job_id", job.ID).
+		Str("worker_id", workerID).
+		Msg("job execution failed, retry scheduled")
 
-		if attempt < maxRetries {
-			zlog.Warn().
-				Int("attempt", attempt).
-				Err(err).
-				Str("worker_id", workerID).
-				Msg("job execution failed, retrying")
-
-			// Exponential backoff: immediate , 2s, 4s
-			time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
-		} else {
-			zlog.Warn().
-				Int("attempt", attempt).
-				Err(err).
-				Str("worker_id", workerID).
-				Msg("job execution failed, max retries reached")
-		}
+	stats.jobRetried()
+	w.publishStatus(ctx, job.ID, "retrying", attempt, err)
+}
+
+// runQueryJob answers a query job with the same RAG pipeline POST /query uses
+func (w *worker) runQueryJob(ctx context.Context, job database.QueuedJob) (interface{}, error) {
+	var req handlers.QueryRequest
+	if err := json.Unmarshal(job.Input, &req); err != nil {
+		return nil, retry.Permanent(errors.New("invalid job input"))
 	}
 
-	database.UpdateJobError(ctx, job.ID, lastErr.Error())
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, retry.Permanent(errors.New("query text is required"))
+	}
 
+	result, err := w.rag.Run(ctx, job.Owner, req.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	// The notes were read even if the lease is lost before the result is stored
+	w.auditJob(context.WithoutCancel(ctx), job, database.AuditQuery, "note", result.NoteIDs())
+	return result, nil
+}
+
+// failJob marks a job as permanently failed and notifies listeners
+// if this worker still holds the job's lease
+func (w *worker) failJob(ctx context.Context, job database.QueuedJob, lease database.JobLease, req handlers.QueryRequest, attempt int, jobErr error) {
+	if err := w.jobs.UpdateJobError(ctx, lease, jobErr.Error()); err != nil {
+		if errors.Is(err, database.ErrJobNotOwned) {
+			logLeaseConflict(lease, attempt, err)
+			return
+		}
+		zlog.Error().Err(err).Str("job_id", job.ID).Msg("Failed to store job error")
+		return
+	}
+
+	retryable, _ := retry.Classify(jobErr)
+	w.recordEvent(ctx, job.ID, database.EventFailed, attempt, jobErr, map[string]interface{}{
+		"retryable": retryable,
+	})
 	zlog.Error().
+		Err(jobErr).
+		Int("attempt", attempt).
+		Bool("retryable", retryable).
 		Str("job_id", job.ID).
 		Str("worker_id", workerID).
-		Msg("❌ Job failed after retries")
+		Msg("❌ Job failed")
+
+	stats.jobFailed()
+	w.publishStatus(ctx, job.ID, "failed", attempt, jobErr)
+	w.notifyCallback(job.ID, req)
+	w.finishBatchItem(ctx, job)
+}
+
+// logJobCancelled records that a job stopped because it was cancelled
+func logJobCancelled(ctx context.Context, jobID string) {
+	event := zlog.Info().
+		Str("job_id", jobID).
+		Str("worker_id", workerID)
+
+	if cause := context.Cause(ctx); cause != nil {
+		event = event.Str("reason", cause.Error())
+	}
+
+	event.Msg("🛑 Job cancelled, skipping result")
 }
 
 // reclaimJobsTask runs in background to reclaim jobs that have timed out
-func reclaimJobsTask(ctx context.Context) {
+func (w *worker) reclaimJobsTask(ctx context.Context) {
 	ticker := time.NewTicker(30 * time.Second) // Check every 30 seconds
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			count, err := database.ReclaimTimedOutJobs(ctx)
+			count, err := w.jobs.ReclaimTimedOutJobs(ctx)
 			if err != nil {
 				zlog.Error().Err(err).Msg("Failed to reclaim timed-out jobs")
 				continue
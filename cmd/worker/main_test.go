@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/handlers"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/store/memory"
+)
+
+// newTestWorker returns a worker processing jobs from memory stores
+func newTestWorker(s *memory.Store) *worker {
+	return &worker{
+		jobs:  s,
+		queue: memory.NewQueue(),
+		audit: s,
+		rag:   handlers.NewRAGPipeline(s, ai.MockEmbedder{}, ai.MockResponder{}, quota.New(config.Quota{}, s)),
+	}
+}
+
+// createQueryJob stores a queued query job of owner and returns its queue payload
+func createQueryJob(t *testing.T, s *memory.Store, owner string, query string) database.QueuedJob {
+	t.Helper()
+	return createJob(t, s, owner, "query", handlers.QueryRequest{Query: query})
+}
+
+// createJob stores a queued job of owner and returns its queue payload
+func createJob(t *testing.T, s *memory.Store, owner string, jobType string, input interface{}) database.QueuedJob {
+	t.Helper()
+
+	inputJSON, _ := json.Marshal(input)
+	id, err := s.CreateJob(context.Background(), jobType, input, "test:"+jobType+":"+string(inputJSON), database.JobOptions{
+		Queue: database.DefaultQueue,
+		Owner: owner,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return database.QueuedJob{ID: id, Type: jobType, Input: inputJSON, Queue: database.DefaultQueue, Owner: owner}
+}
+
+// historyEvents returns the event kinds of a job's history, oldest first
+func historyEvents(t *testing.T, s *memory.Store, jobID string) []string {
+	t.Helper()
+
+	history, err := s.GetJobHistory(context.Background(), jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make([]string, 0, len(history))
+	for _, e := range history {
+		events = append(events, e.Event)
+	}
+	return events
+}
+
+func TestProcessQueryJob(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	acme, err := s.CreateNote(ctx, "acme", "Offsite", "The offsite is in Lisbon", ai.GenerateMockEmbedding("The offsite is in Lisbon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateNote(ctx, "globex", "Secret", "Globex merger closes in May", ai.GenerateMockEmbedding("merger")); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := createQueryJob(t, s, "acme", "where is the offsite?")
+	w.processJob(ctx, queued, w.runQueryJob)
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "completed" || job.Result == nil {
+		t.Fatalf("job = %+v, want completed with a result", job)
+	}
+
+	var result handlers.RAGResult
+	if err := json.Unmarshal(*job.Result, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Results) != 1 || result.Results[0].ID != acme.ID || !strings.Contains(result.Response, "Lisbon") {
+		t.Fatalf("result = %+v, want an answer from acme's note %d only", result, acme.ID)
+	}
+
+	if events := historyEvents(t, s, queued.ID); strings.Join(events, ",") != "claimed,completed" {
+		t.Fatalf("history = %v, want claimed then completed", events)
+	}
+
+	// The job's note access is audited as the worker, for the job's tenant
+	entries, err := s.ListAuditEntries(ctx, database.AuditFilter{Owner: "acme", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Actor != "worker:"+workerID || entries[0].RequestID == nil || *entries[0].RequestID != queued.ID {
+		t.Fatalf("audit entries = %+v, want one query entry by the worker", entries)
+	}
+
+	// A job is only processed once
+	w.processJob(ctx, queued, w.runQueryJob)
+	if events := historyEvents(t, s, queued.ID); len(events) != 2 {
+		t.Fatalf("history = %v, want the completed job left alone", events)
+	}
+}
+
+func TestProcessQueryJobInvalidInput(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	queued := createQueryJob(t, s, "acme", "  ")
+	w.processJob(ctx, queued, w.runQueryJob)
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "failed" || job.Error == nil || *job.Error != "query text is required" {
+		t.Fatalf("job = %+v, want it failed without retries", job)
+	}
+
+	if events := historyEvents(t, s, queued.ID); strings.Join(events, ",") != "claimed,failed" {
+		t.Fatalf("history = %v, want claimed then failed", events)
+	}
+}
+
+func TestProcessReembedJob(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	// Notes embedded by an older model no longer match their own content
+	stale := ai.GenerateMockEmbedding("old model")
+	acme, err := s.CreateNote(ctx, "acme", "Offsite", "The offsite is in Lisbon", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := s.CreateNote(ctx, "globex", "Secret", "Globex merger closes in May", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := createJob(t, s, "acme", "reembed", struct{}{})
+	w.processJob(ctx, queued, w.runReembedJob)
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "completed" || job.Result == nil || string(*job.Result) != `{"reembedded":1}` {
+		t.Fatalf("job = %+v, want completed with one note re-embedded", job)
+	}
+
+	matches, err := s.SearchNotes(ctx, "acme", ai.GenerateMockEmbedding(acme.Content), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Distance != 0 {
+		t.Fatalf("matches = %+v, want acme's note embedded from its content", matches)
+	}
+
+	// Other tenants' notes are left alone
+	matches, err = s.SearchNotes(ctx, "globex", stale, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].ID != globex.ID || matches[0].Distance != 0 {
+		t.Fatalf("matches = %+v, want globex's note unchanged", matches)
+	}
+
+	entries, err := s.ListAuditEntries(ctx, database.AuditFilter{Owner: "acme", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != database.AuditUpdate || strings.Join(entries[0].TargetIDs, ",") != strconv.Itoa(acme.ID) {
+		t.Fatalf("audit entries = %+v, want one update of acme's note", entries)
+	}
+}
+
+func TestProcessCleanupJob(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	oldDone := createQueryJob(t, s, "acme", "old")
+	oldOther := createQueryJob(t, s, "globex", "old")
+	oldQueued := createQueryJob(t, s, "acme", "still queued")
+	for _, id := range []string{oldDone.ID, oldOther.ID} {
+		if _, err := s.CancelJob(ctx, id, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	recentDone := createQueryJob(t, s, "acme", "recent")
+	if _, err := s.CancelJob(ctx, recentDone.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := createJob(t, s, "acme", "cleanup", handlers.CleanupRequest{OlderThan: "25ms"})
+	w.processJob(ctx, queued, w.runCleanupJob)
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "completed" || job.Result == nil || string(*job.Result) != `{"deleted":1}` {
+		t.Fatalf("job = %+v, want completed with one job deleted", job)
+	}
+
+	if _, err := s.GetJobByID(ctx, oldDone.ID); err == nil {
+		t.Fatal("acme's old finished job was kept")
+	}
+
+	// Unfinished jobs, recent jobs and other tenants' jobs are kept
+	for _, id := range []string{oldOther.ID, oldQueued.ID, recentDone.ID} {
+		if _, err := s.GetJobByID(ctx, id); err != nil {
+			t.Fatalf("job %s: %v, want it kept", id, err)
+		}
+	}
+}
+
+func TestProcessCleanupJobInvalidInput(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	queued := createJob(t, s, "acme", "cleanup", handlers.CleanupRequest{OlderThan: "30d", Statuses: []string{"queued"}})
+	w.processJob(ctx, queued, w.runCleanupJob)
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "failed" || job.Error == nil || !strings.Contains(*job.Error, "only completed, failed and cancelled") {
+		t.Fatalf("job = %+v, want it failed without retries", job)
+	}
+}
+
+// leaseConflicts returns the job_lease_conflicts count of this process
+func leaseConflicts() int64 {
+	return stats.snapshot("", nil, time.Now()).LeaseConflicts
+}
+
+func TestStaleLeaseLosesToReclaim(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	queued := createQueryJob(t, s, "acme", "where is the offsite?")
+
+	stale, _, err := s.ClaimJobForProcessing(ctx, queued.ID, "worker-a", visibilityTimeoutMinutes)
+	if err != nil || stale == nil {
+		t.Fatalf("claim: %v, %v", stale, err)
+	}
+
+	// worker-a stops renewing, so worker-b re-claims the job
+	s.ExpireJobLease(queued.ID)
+	current, _, err := s.ClaimJobForProcessing(ctx, queued.ID, "worker-b", visibilityTimeoutMinutes)
+	if err != nil || current == nil {
+		t.Fatalf("re-claim: %v, %v", current, err)
+	}
+
+	if err := s.UpdateJobResult(ctx, *stale, "stale"); !errors.Is(err, database.ErrJobNotOwned) {
+		t.Fatalf("stale UpdateJobResult: %v, want ErrJobNotOwned", err)
+	}
+	if err := s.UpdateJobError(ctx, *stale, "stale"); !errors.Is(err, database.ErrJobNotOwned) {
+		t.Fatalf("stale UpdateJobError: %v, want ErrJobNotOwned", err)
+	}
+	if err := s.ExtendVisibilityTimeout(ctx, *stale, visibilityTimeoutMinutes); !errors.Is(err, database.ErrJobNotOwned) {
+		t.Fatalf("stale ExtendVisibilityTimeout: %v, want ErrJobNotOwned", err)
+	}
+
+	if stale.Token == current.Token {
+		t.Fatalf("leases %+v and %+v, want a new token for the re-claim", stale, current)
+	}
+
+	if err := s.UpdateJobResult(ctx, *current, "current"); err != nil {
+		t.Fatalf("current UpdateJobResult: %v", err)
+	}
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "completed" || job.Result == nil || string(*job.Result) != `"current"` {
+		t.Fatalf("job = %+v, want worker-b's result", job)
+	}
+}
+
+func TestCancelledJobRejectsResult(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	queued := createQueryJob(t, s, "acme", "where is the offsite?")
+
+	lease, _, err := s.ClaimJobForProcessing(ctx, queued.ID, workerID, visibilityTimeoutMinutes)
+	if err != nil || lease == nil {
+		t.Fatalf("claim: %v, %v", lease, err)
+	}
+
+	if _, err := s.CancelJob(ctx, queued.ID, "user request"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.UpdateJobResult(ctx, *lease, "too late"); !errors.Is(err, database.ErrJobNotOwned) {
+		t.Fatalf("UpdateJobResult: %v, want ErrJobNotOwned", err)
+	}
+	if err := s.RescheduleJobRetry(ctx, *lease, time.Now(), "too late"); !errors.Is(err, database.ErrJobNotOwned) {
+		t.Fatalf("RescheduleJobRetry: %v, want ErrJobNotOwned", err)
+	}
+
+	job, err := s.GetJobByID(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "cancelled" || job.Result != nil {
+		t.Fatalf("job = %+v, want it left cancelled without a result", job)
+	}
+}
+
+func TestLeaseConflictsAreCounted(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	tests := []struct {
+		name       string
+		interrupt  func(jobID string)
+		wantStatus string
+	}{
+		{"re-claimed", func(jobID string) {
+			s.ExpireJobLease(jobID)
+			if lease, _, err := s.ClaimJobForProcessing(ctx, jobID, "other-worker", visibilityTimeoutMinutes); err != nil || lease == nil {
+				t.Errorf("re-claim: %v, %v", lease, err)
+			}
+		}, "processing"},
+		{"cancelled", func(jobID string) {
+			if _, err := s.CancelJob(ctx, jobID, "user request"); err != nil {
+				t.Error(err)
+			}
+		}, "cancelled"},
+	}
+
+	for _, tt := range tests {
+		queued := createQueryJob(t, s, "acme", tt.name)
+		before := leaseConflicts()
+
+		// The job is taken away while it runs, so its result is discarded
+		w.processJob(ctx, queued, func(ctx context.Context, job database.QueuedJob) (interface{}, error) {
+			tt.interrupt(job.ID)
+			return "discarded", nil
+		})
+
+		if got := leaseConflicts() - before; got != 1 {
+			t.Errorf("%s: job_lease_conflicts went up by %d, want 1", tt.name, got)
+		}
+
+		job, err := s.GetJobByID(ctx, queued.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != tt.wantStatus || job.Result != nil {
+			t.Errorf("%s: job = %+v, want it %s without a result", tt.name, job, tt.wantStatus)
+		}
+		if events := historyEvents(t, s, queued.ID); strings.Join(events, ",") != "claimed" {
+			t.Errorf("%s: history = %v, want only the claim", tt.name, events)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/handlers"
+	"notes-memory-core-rag/internal/retry"
+)
+
+// runReembedJob embeds every note of the job's tenant again
+func (w *worker) runReembedJob(ctx context.Context, job database.QueuedJob) (interface{}, error) {
+	result, err := w.rag.Reembed(ctx, job.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	w.auditJob(context.WithoutCancel(ctx), job, database.AuditUpdate, "note", result.NoteIDs())
+	return result, nil
+}
+
+// runCleanupJob deletes the finished jobs of the job's tenant that are older
+// than its input allows. Other tenants' jobs are never matched.
+func (w *worker) runCleanupJob(ctx context.Context, job database.QueuedJob) (interface{}, error) {
+	var req handlers.CleanupRequest
+	if err := json.Unmarshal(job.Input, &req); err != nil {
+		return nil, retry.Permanent(errors.New("invalid job input"))
+	}
+
+	rules, err := req.RetentionRules(job.Owner)
+	if err != nil {
+		return nil, retry.Permanent(err)
+	}
+
+	// Collects the IDs of everything deleted, batch items included, for the audit log
+	var deletedIDs []string
+	collect := func(jobs []database.Job) error {
+		for _, j := range jobs {
+			deletedIDs = append(deletedIDs, j.ID)
+		}
+		return nil
+	}
+
+	result := &handlers.CleanupResult{}
+	defer func() {
+		if len(deletedIDs) > 0 {
+			w.auditJob(context.WithoutCancel(ctx), job, database.AuditDelete, "job", deletedIDs)
+		}
+	}()
+
+	for _, rule := range rules {
+		for {
+			n, err := w.jobs.PruneJobs(ctx, rule, pruneBatchSize, collect)
+			if err != nil {
+				return nil, err
+			}
+
+			result.Deleted += n
+			if n < pruneBatchSize {
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
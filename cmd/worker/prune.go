@@ -0,0 +1,265 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+const pruneBatchSize = 500
+
+// retentionConfig controls the periodic pruning of finished jobs.
+//
+//	JOB_RETENTION       comma-separated [type:]status=age rules, e.g.
+//	                    "completed=7d,failed=30d,cancelled=3d,query:completed=24h"
+//	JOB_PRUNE_INTERVAL  how often to prune (default 1h)
+//	JOB_ARCHIVE_DIR     if set, pruned rows are written there as gzipped NDJSON
+//	JOB_PRUNE_DRY_RUN   "true" only logs what would be deleted
+type retentionConfig struct {
+	Rules      []database.RetentionRule
+	Interval   time.Duration
+	ArchiveDir string
+	DryRun     bool
+}
+
+// loadRetentionConfig builds the retention policy from the worker settings.
+// Returns nil when JOB_RETENTION is unset, which disables pruning.
+func loadRetentionConfig(cfg config.Worker) (*retentionConfig, error) {
+	raw := strings.TrimSpace(cfg.JobRetention)
+	if raw == "" {
+		return nil, nil
+	}
+
+	rules, err := parseRetentionRules(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return &retentionConfig{
+		Rules:      rules,
+		Interval:   cfg.JobPruneInterval,
+		ArchiveDir: cfg.JobArchiveDir,
+		DryRun:     cfg.JobPruneDryRun,
+	}, nil
+}
+
+// parseRetentionRules parses "[type:]status=age" rules. A rule with a type takes
+// precedence over the generic rule for the same status.
+func parseRetentionRules(raw string) ([]database.RetentionRule, error) {
+	var rules []database.RetentionRule
+	typedByStatus := map[string][]string{}
+
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key, age, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid retention rule %q, expected [type:]status=age", part)
+		}
+
+		rule := database.RetentionRule{Status: strings.TrimSpace(key)}
+		if jobType, status, hasType := strings.Cut(rule.Status, ":"); hasType {
+			rule.Type = jobType
+			rule.Status = status
+		}
+
+		if !database.IsTerminalStatus(rule.Status) {
+			return nil, fmt.Errorf("invalid retention rule %q: only completed, failed and cancelled jobs can be pruned", part)
+		}
+
+		maxAge, err := database.ParseRetentionAge(strings.TrimSpace(age))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention rule %q: %w", part, err)
+		}
+		rule.MaxAge = maxAge
+
+		if rule.Type != "" {
+			typedByStatus[rule.Status] = append(typedByStatus[rule.Status], rule.Type)
+		}
+		rules = append(rules, rule)
+	}
+
+	for i := range rules {
+		if rules[i].Type == "" {
+			rules[i].ExcludeTypes = typedByStatus[rules[i].Status]
+		}
+	}
+
+	return rules, nil
+}
+
+// pruneJobsTask runs in background alongside reclaimJobsTask and enforces the retention policy
+func (w *worker) pruneJobsTask(ctx context.Context, cfg *retentionConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.pruneJobs(ctx, cfg)
+
+		case <-ctx.Done():
+			zlog.Info().Msg("Stopping job prune task")
+			return
+		}
+	}
+}
+
+// pruneJobs applies every retention rule once. When archiving is enabled the
+// archive is opened before anything is deleted, and each batch of rows is only
+// deleted once it is flushed to the archive; pruning stops if it can't be written.
+func (w *worker) pruneJobs(ctx context.Context, cfg *retentionConfig) {
+	var archive *jobArchive
+	if cfg.ArchiveDir != "" && !cfg.DryRun {
+		var err error
+		archive, err = newJobArchive(cfg.ArchiveDir)
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to create job archive, skipping prune")
+			return
+		}
+		defer archive.close()
+	}
+
+	for _, rule := range cfg.Rules {
+		if cfg.DryRun {
+			count, err := w.db.CountPrunableJobs(ctx, rule)
+			if err != nil {
+				zlog.Error().Err(err).Str("status", rule.Status).Msg("Failed to count prunable jobs")
+				continue
+			}
+
+			zlog.Info().
+				Str("status", rule.Status).
+				Str("type", rule.Type).
+				Dur("max_age", rule.MaxAge).
+				Int("would_delete", count).
+				Msg("🧹 Job prune dry run")
+			continue
+		}
+
+		var archiveRows func([]database.Job) error
+		if archive != nil {
+			archiveRows = archive.write
+		}
+
+		deleted := 0
+		for {
+			n, err := w.jobs.PruneJobs(ctx, rule, pruneBatchSize, archiveRows)
+			if err != nil {
+				if archive != nil && archive.failed {
+					zlog.Error().Err(err).Msg("Failed to archive pruned jobs, stopping prune")
+					return
+				}
+				zlog.Error().Err(err).Str("status", rule.Status).Msg("Failed to prune jobs")
+				break
+			}
+
+			deleted += n
+			if n < pruneBatchSize {
+				break
+			}
+		}
+
+		if deleted > 0 {
+			zlog.Info().
+				Str("status", rule.Status).
+				Str("type", rule.Type).
+				Int("deleted", deleted).
+				Str("worker_id", workerID).
+				Msg("🧹 Pruned old jobs")
+		}
+	}
+}
+
+// jobArchive writes pruned jobs as gzip-compressed NDJSON
+type jobArchive struct {
+	path  string
+	file  *os.File
+	gz    *gzip.Writer
+	enc   *json.Encoder
+	count int
+
+	// failed is set once a write fails; the archive can't be trusted afterwards
+	failed bool
+}
+
+func newJobArchive(dir string) (*jobArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("jobs-%s-%s.ndjson.gz", time.Now().UTC().Format("20060102T150405Z"), workerID)
+	path := filepath.Join(dir, name)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	return &jobArchive{path: path, file: file, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// write appends jobs and flushes them to disk, so they are archived before
+// PruneJobs deletes them
+func (a *jobArchive) write(jobs []database.Job) error {
+	if a.failed {
+		return errors.New("job archive is unusable after a failed write")
+	}
+
+	err := a.encode(jobs)
+	if err != nil {
+		a.failed = true
+	}
+	return err
+}
+
+func (a *jobArchive) encode(jobs []database.Job) error {
+	for _, job := range jobs {
+		if err := a.enc.Encode(job); err != nil {
+			return err
+		}
+		a.count++
+	}
+
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// close finalizes the archive, removing it if nothing was pruned
+func (a *jobArchive) close() {
+	err := a.gz.Close()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if a.count == 0 {
+		os.Remove(a.path)
+		return
+	}
+
+	if err != nil {
+		zlog.Error().Err(err).Str("path", a.path).Msg("Failed to close job archive")
+		return
+	}
+
+	zlog.Info().
+		Str("path", a.path).
+		Int("jobs", a.count).
+		Msg("📦 Archived pruned jobs")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/handlers"
+	"notes-memory-core-rag/internal/store/memory"
+)
+
+// createPrunableJobs stores a cancelled batch job with two items and a cancelled
+// query job, and returns every job ID sorted
+func createPrunableJobs(t *testing.T, s *memory.Store) []string {
+	t.Helper()
+	ctx := context.Background()
+
+	batchID, itemIDs, err := s.CreateBatchJob(ctx, handlers.BatchQueryRequest{}, []string{"a", "b"}, "test:batch", database.JobOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CancelJob(ctx, batchID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CancelBatchItems(ctx, batchID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	single := createQueryJob(t, s, "", "single")
+	if _, err := s.CancelJob(ctx, single.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := append([]string{batchID, single.ID}, itemIDs...)
+	sort.Strings(ids)
+	return ids
+}
+
+// readArchive returns the sorted job IDs of every archive in dir
+func readArchive(t *testing.T, dir string) []string {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "jobs-*.ndjson.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dec := json.NewDecoder(gz)
+		for dec.More() {
+			var job database.Job
+			if err := dec.Decode(&job); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, job.ID)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPruneJobsArchivesBeforeDeleting(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+	w := newTestWorker(s)
+
+	ids := createPrunableJobs(t, s)
+	time.Sleep(10 * time.Millisecond)
+
+	rules, err := parseRetentionRules("cancelled=5ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	w.pruneJobs(ctx, &retentionConfig{Rules: rules, ArchiveDir: dir})
+
+	// Batch items are archived with their batch job
+	if archived := readArchive(t, dir); strings.Join(archived, ",") != strings.Join(ids, ",") {
+		t.Fatalf("archived %v, want %v", archived, ids)
+	}
+
+	for _, id := range ids {
+		if _, err := s.GetJobByID(ctx, id); err == nil {
+			t.Fatalf("job %s was archived but not deleted", id)
+		}
+	}
+}
+
+func TestPruneJobsKeepsJobsWhenArchiveFails(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	ids := createPrunableJobs(t, s)
+	time.Sleep(10 * time.Millisecond)
+
+	rules, err := parseRetentionRules("cancelled=5ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := newJobArchive(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The disk goes away before the first batch is written
+	archive.file.Close()
+
+	if _, err := s.PruneJobs(ctx, rules[0], pruneBatchSize, archive.write); err == nil {
+		t.Fatal("PruneJobs succeeded without an archive")
+	}
+	if !archive.failed {
+		t.Fatal("archive not marked as failed")
+	}
+
+	for _, id := range ids {
+		if _, err := s.GetJobByID(ctx, id); err != nil {
+			t.Fatalf("job %s: %v, want it kept when archiving fails", id, err)
+		}
+	}
+
+	// An unusable archive directory stops pruning before anything is deleted
+	dir := filepath.Join(t.TempDir(), "archive")
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	newTestWorker(s).pruneJobs(ctx, &retentionConfig{Rules: rules, ArchiveDir: dir})
+
+	for _, id := range ids {
+		if _, err := s.GetJobByID(ctx, id); err != nil {
+			t.Fatalf("job %s: %v, want it kept when the archive can't be created", id, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"notes-memory-core-rag/internal/database"
+	"strconv"
+	"strings"
+)
+
+// queueWeight is a queue this worker consumes and its relative share of pops
+type queueWeight struct {
+	Name   string
+	Weight int
+}
+
+// parseQueueConfig parses WORKER_QUEUES, e.g. "interactive:5,default:3,bulk:1".
+// A queue without a weight gets weight 1. Defaults to the default queue only.
+func parseQueueConfig(raw string) ([]queueWeight, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return []queueWeight{{Name: database.DefaultQueue, Weight: 1}}, nil
+	}
+
+	var queues []queueWeight
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, weightStr, hasWeight := strings.Cut(part, ":")
+		q := queueWeight{Name: strings.TrimSpace(name), Weight: 1}
+		if q.Name == "" {
+			return nil, fmt.Errorf("invalid WORKER_QUEUES entry %q", part)
+		}
+
+		if hasWeight {
+			w, err := strconv.Atoi(strings.TrimSpace(weightStr))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight in WORKER_QUEUES entry %q", part)
+			}
+			q.Weight = w
+		}
+
+		queues = append(queues, q)
+	}
+
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("WORKER_QUEUES does not name any queue")
+	}
+
+	return queues, nil
+}
+
+// queueNamesByWeight returns all queues in a weighted random order. DequeueJob
+// pops from the first non-empty queue, so each queue is checked first with
+// probability proportional to its weight and no queue is ever starved while others are empty.
+func queueNamesByWeight(queues []queueWeight) []string {
+	remaining := make([]queueWeight, len(queues))
+	copy(remaining, queues)
+
+	names := make([]string, 0, len(queues))
+	for len(remaining) > 0 {
+		total := 0
+		for _, q := range remaining {
+			total += q.Weight
+		}
+
+		pick := rand.Intn(total)
+		for i, q := range remaining {
+			if pick < q.Weight {
+				names = append(names, q.Name)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+			pick -= q.Weight
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/schedule"
+	"time"
+
+	zlog "github.com/rs/zerolog/log"
+)
+
+// schedulerInterval is how often the leader checks for due jobs and schedules.
+// It also bounds how precisely retry backoffs are honored.
+const schedulerInterval = 5 * time.Second
+
+// schedulerTask runs in background on every worker. Each tick, only the worker
+// holding the scheduler advisory lock promotes delayed jobs and fires cron schedules.
+func (w *worker) schedulerTask(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			release, ok, err := w.db.TryAdvisoryLock(ctx, database.SchedulerLockKey)
+			if err != nil {
+				zlog.Error().Err(err).Msg("Failed to acquire scheduler lock")
+				continue
+			}
+			if !ok {
+				continue // another worker is the scheduler leader
+			}
+
+			// Schedules first, so jobs deferred by a failed push are retried in the same tick
+			w.runDueSchedules(ctx)
+			w.promoteScheduledJobs(ctx)
+			release()
+
+		case <-ctx.Done():
+			zlog.Info().Msg("Stopping scheduler task")
+			return
+		}
+	}
+}
+
+// promoteScheduledJobs queues delayed jobs whose run_at has passed
+func (w *worker) promoteScheduledJobs(ctx context.Context) {
+	// Jobs are only marked queued once pushed; failed ones stay scheduled for the next tick
+	jobs, err := w.db.PromoteDueScheduledJobs(ctx, func(job database.Job) error {
+		owner := ""
+		if job.Owner != nil {
+			owner = *job.Owner
+		}
+
+		err := w.queue.EnqueueJob(ctx, job.ID, job.Type, job.Input, job.Queue, job.Priority, owner)
+		if err != nil {
+			zlog.Error().Err(err).Str("job_id", job.ID).Msg("Failed to enqueue scheduled job, will retry")
+		}
+		return err
+	})
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to promote scheduled jobs")
+	}
+
+	for _, job := range jobs {
+		w.recordEvent(ctx, job.ID, database.EventEnqueued, 0, nil, map[string]interface{}{
+			"queue": job.Queue,
+			"from":  "scheduled",
+		})
+		w.publishStatus(ctx, job.ID, "queued", 0, nil)
+	}
+
+	if len(jobs) > 0 {
+		zlog.Info().
+			Int("jobs", len(jobs)).
+			Str("worker_id", workerID).
+			Msg("⏰ Queued scheduled jobs")
+	}
+}
+
+// runDueSchedules creates a job for every recurring schedule that is due.
+// Missed runs (e.g. while no worker was up) are collapsed into a single run.
+func (w *worker) runDueSchedules(ctx context.Context) {
+	schedules, err := w.db.DueSchedules(ctx)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to load due schedules")
+		return
+	}
+
+	for _, s := range schedules {
+		now := time.Now().UTC()
+
+		cron, err := schedule.Parse(s.Cron)
+		if err != nil {
+			zlog.Error().Err(err).Str("schedule", s.Name).Msg("Invalid cron expression")
+			continue
+		}
+
+		next := cron.Next(now)
+		if next.IsZero() {
+			zlog.Error().Str("schedule", s.Name).Msg("Cron expression never fires again")
+			continue
+		}
+
+		// One hash per scheduled run: a unique index keeps re-runs of the same tick
+		// from creating a second job
+		hash := fmt.Sprintf("schedule:%d:%d", s.ID, s.NextRunAt.Unix())
+
+		// Scheduled jobs run as the tenant that created the schedule
+		owner := ""
+		if s.Owner != nil {
+			owner = *s.Owner
+		}
+
+		jobID, created, err := w.db.CreateScheduleRunJob(ctx, s.Type, s.Input, hash, database.JobOptions{
+			Queue:    s.Queue,
+			Priority: s.Priority,
+			Owner:    owner,
+		})
+		if err != nil {
+			zlog.Error().Err(err).Str("schedule", s.Name).Msg("Failed to create scheduled job")
+			continue
+		}
+
+		if !created {
+			// An earlier tick created this run's job but didn't advance the schedule
+			zlog.Warn().Str("schedule", s.Name).Msg("Scheduled run already has a job, skipping")
+			if err := w.db.MarkScheduleRun(ctx, s.ID, now, next); err != nil {
+				zlog.Error().Err(err).Str("schedule", s.Name).Msg("Failed to update schedule")
+			}
+			continue
+		}
+
+		if err := w.queue.EnqueueJob(ctx, jobID, s.Type, s.Input, s.Queue, s.Priority, owner); err != nil {
+			zlog.Error().Err(err).Str("job_id", jobID).Msg("Failed to enqueue scheduled job, will retry")
+
+			// Hand the job to promoteScheduledJobs rather than leaving it queued without a payload
+			if err := w.db.DeferQueuedJob(ctx, jobID); err != nil {
+				zlog.Error().Err(err).Str("job_id", jobID).Msg("Failed to defer scheduled job")
+			}
+		} else {
+			w.recordEvent(ctx, jobID, database.EventEnqueued, 0, nil, map[string]interface{}{
+				"queue":    s.Queue,
+				"schedule": s.Name,
+			})
+		}
+
+		if err := w.db.MarkScheduleRun(ctx, s.ID, now, next); err != nil {
+			zlog.Error().Err(err).Str("schedule", s.Name).Msg("Failed to update schedule")
+			continue
+		}
+
+		zlog.Info().
+			Str("schedule", s.Name).
+			Str("job_id", jobID).
+			Time("next_run_at", next).
+			Msg("⏰ Fired scheduled job")
+	}
+}
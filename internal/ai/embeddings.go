@@ -6,17 +6,40 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/rand"
-	"os"
-	"strings"
 	"time"
 
 	openai "github.com/sashabaranov/go-openai"
+
+	"notes-memory-core-rag/internal/config"
 )
 
+// Embedder turns text into a 1536-dim embedding. Tokens spent are added to the
+// context's Usage (see TrackUsage).
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// NewEmbedder returns the mock embedder if USE_MOCK_EMBEDDINGS=true, else OpenAI's.
+func NewEmbedder(cfg config.AI) Embedder {
+	if cfg.MockEmbeddings {
+		return MockEmbedder{}
+	}
+	return OpenAIEmbedder{APIKey: cfg.OpenAIAPIKey}
+}
+
 // ---------------------------
 //  MOCK EMBEDDINGS
 // ---------------------------
 
+// MockEmbedder embeds text without an API key (see GenerateMockEmbedding).
+type MockEmbedder struct{}
+
+// Embed returns the mock embedding of text and counts its estimated tokens.
+func (MockEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	addEmbeddingTokens(ctx, EstimateTokens(text))
+	return GenerateMockEmbedding(text), nil
+}
+
 // GenerateMockEmbedding creates a deterministic embedding (1536-dim)
 // based on hashing the input text.
 func GenerateMockEmbedding(text string) []float32 {
@@ -37,65 +60,37 @@ func GenerateMockEmbedding(text string) []float32 {
 //  REAL OPENAI EMBEDDINGS
 // ---------------------------
 
-// GenerateEmbedding calls OpenAI's embeddings API.
-func GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("missing OPENAI_API_KEY")
+// OpenAIEmbedder calls OpenAI's embeddings API.
+type OpenAIEmbedder struct {
+	APIKey string
+}
+
+// Embed returns the embedding of text from OpenAI.
+func (e OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if e.APIKey == "" {
+		return nil, ErrMissingAPIKey
 	}
 
 	// Create context with timeout for OpenAI API call
 	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	client := openai.NewClient(apiKey)
+	client := newOpenAIClient(e.APIKey)
+	timeoutCtx, retryAfter := withRetryAfter(timeoutCtx)
 
 	resp, err := client.CreateEmbeddings(timeoutCtx, openai.EmbeddingRequest{
 		Model: openai.SmallEmbedding3,
 		Input: []string{text},
 	})
 	if err != nil {
-		return nil, err
+		return nil, wrapRetryAfter(err, retryAfter)
 	}
 
+	addEmbeddingTokens(ctx, int64(resp.Usage.TotalTokens))
+
 	if len(resp.Data) == 0 {
 		return nil, fmt.Errorf("no embeddings returned")
 	}
 
 	return resp.Data[0].Embedding, nil
 }
-
-// ---------------------------
-//  SELECTION + VECTOR FORMATTER
-// ---------------------------
-
-// GetEmbeddingAsVectorLiteral returns a PGVector literal string.
-func GetEmbeddingAsVectorLiteral(ctx context.Context, text string) (string, error) {
-	useMock := os.Getenv("USE_MOCK_EMBEDDINGS") == "true"
-
-	// Select mock or real embeddings
-	var vec []float32
-	var err error
-
-	if useMock {
-		vec = GenerateMockEmbedding(text)
-	} else {
-		vec, err = GenerateEmbedding(ctx, text)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	// Convert slice → "[0.1,0.2,0.3]"
-	builder := strings.Builder{}
-	builder.WriteString("[")
-	for i, v := range vec {
-		if i > 0 {
-			builder.WriteString(",")
-		}
-		builder.WriteString(fmt.Sprintf("%f", v))
-	}
-	builder.WriteString("]")
-
-	return builder.String(), nil
-}
@@ -0,0 +1,102 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ErrMissingAPIKey is returned when a real OpenAI call is attempted without OPENAI_API_KEY.
+var ErrMissingAPIKey = errors.New("missing OPENAI_API_KEY")
+
+// RetryAfterError wraps an OpenAI error whose response carried a Retry-After header.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string { return e.Err.Error() }
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+// RetryAfter returns how long the API asked us to wait before retrying.
+func (e *RetryAfterError) RetryAfter() time.Duration { return e.After }
+
+// ---------------------------
+//  OPENAI CLIENT
+// ---------------------------
+
+type retryAfterKey struct{}
+
+// retryAfterHolder receives the Retry-After value of a failed response
+type retryAfterHolder struct {
+	after time.Duration
+}
+
+// retryAfterTransport records Retry-After on 429/5xx responses, since the
+// go-openai error types don't expose response headers.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if holder, ok := req.Context().Value(retryAfterKey{}).(*retryAfterHolder); ok {
+			holder.after = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+	}
+
+	return resp, nil
+}
+
+// parseRetryAfter accepts delay-seconds or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+var openAIHTTPClient = &http.Client{
+	Transport: retryAfterTransport{base: http.DefaultTransport},
+}
+
+// newOpenAIClient builds a client whose errors can carry Retry-After.
+func newOpenAIClient(apiKey string) *openai.Client {
+	config := openai.DefaultConfig(apiKey)
+	config.HTTPClient = openAIHTTPClient
+	return openai.NewClientWithConfig(config)
+}
+
+// withRetryAfter returns a context that collects the Retry-After of a failed call.
+func withRetryAfter(ctx context.Context) (context.Context, *retryAfterHolder) {
+	holder := &retryAfterHolder{}
+	return context.WithValue(ctx, retryAfterKey{}, holder), holder
+}
+
+// wrapRetryAfter attaches a recorded Retry-After to err, if any.
+func wrapRetryAfter(err error, holder *retryAfterHolder) error {
+	if err == nil || holder.after <= 0 {
+		return err
+	}
+	return &RetryAfterError{Err: err, After: holder.after}
+}
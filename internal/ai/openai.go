@@ -3,26 +3,31 @@ package ai
 import (
 	"context"
 	"fmt"
-	"os"
 	"time"
 
 	openai "github.com/sashabaranov/go-openai"
 )
 
-// GenerateRealAIResponse sends the query + context notes to OpenAI
+// OpenAIResponder answers with GPT-4o Mini.
+type OpenAIResponder struct {
+	APIKey string
+}
+
+// Respond sends the query + context notes to OpenAI
 // and returns a natural-language answer.
-func GenerateRealAIResponse(ctx context.Context, query string, notes []string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("missing OPENAI_API_KEY")
+func (r OpenAIResponder) Respond(ctx context.Context, query string, notes []string) (string, error) {
+	if r.APIKey == "" {
+		return "", ErrMissingAPIKey
 	}
 
-	client := openai.NewClient(apiKey)
+	client := newOpenAIClient(r.APIKey)
 
 	// Create context with timeout for OpenAI API call
 	timeoutCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
+	timeoutCtx, retryAfter := withRetryAfter(timeoutCtx)
+
 	// Build RAG-style context block
 	contextBlock := "Relevant notes:\n"
 	for _, n := range notes {
@@ -52,9 +57,11 @@ Your Answer:
 		MaxTokens: 300,
 	})
 	if err != nil {
-		return "", err
+		return "", wrapRetryAfter(err, retryAfter)
 	}
 
+	addLLMTokens(ctx, int64(resp.Usage.TotalTokens))
+
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("empty response from OpenAI")
 	}
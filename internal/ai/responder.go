@@ -2,27 +2,40 @@ package ai
 
 import (
 	"context"
-	"os"
 	"strings"
+
+	"notes-memory-core-rag/internal/config"
 )
 
-// GenerateAIResponse decides whether to use mock or real AI responses.
-// Controlled by USE_MOCK_LLM=true/false.
-func GenerateAIResponse(ctx context.Context, query string, notes []string) (string, error) {
-	useMock := os.Getenv("USE_MOCK_LLM") == "true"
+// Responder answers a query from the content of the notes found for it. Tokens
+// spent are added to the context's Usage (see TrackUsage).
+type Responder interface {
+	Respond(ctx context.Context, query string, notes []string) (string, error)
+}
 
-	if useMock {
-		return GenerateMockResponse(query, notes), nil
+// NewResponder returns the mock responder if USE_MOCK_LLM=true, else OpenAI's
+// (defined in openai.go).
+func NewResponder(cfg config.AI) Responder {
+	if cfg.MockLLM {
+		return MockResponder{}
 	}
-
-	// Otherwise call real OpenAI (defined in openai.go)
-	return GenerateRealAIResponse(ctx, query, notes)
+	return OpenAIResponder{APIKey: cfg.OpenAIAPIKey}
 }
 
 // ------------------------------
 // MOCK LLM RESPONSE
 // ------------------------------
 
+// MockResponder answers without an API key (see GenerateMockResponse).
+type MockResponder struct{}
+
+// Respond returns the mock answer and counts its estimated tokens.
+func (MockResponder) Respond(ctx context.Context, query string, notes []string) (string, error) {
+	response := GenerateMockResponse(query, notes)
+	addLLMTokens(ctx, EstimateTokens(query+strings.Join(notes, "\n")+response))
+	return response, nil
+}
+
 // GenerateMockResponse produces a simple deterministic answer
 // based ONLY on the provided notes. It allows the RAG system to run
 // without an OpenAI API key.
@@ -0,0 +1,52 @@
+package ai
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage counts the tokens spent by AI calls made with a tracked context
+type Usage struct {
+	mu              sync.Mutex
+	llmTokens       int64
+	embeddingTokens int64
+}
+
+type usageKey struct{}
+
+// TrackUsage returns a context whose embedding and LLM calls add their token
+// usage to the returned Usage. Mock calls count estimated tokens.
+func TrackUsage(ctx context.Context) (context.Context, *Usage) {
+	usage := &Usage{}
+	return context.WithValue(ctx, usageKey{}, usage), usage
+}
+
+// Tokens returns the LLM and embedding tokens counted so far
+func (u *Usage) Tokens() (llm int64, embedding int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.llmTokens, u.embeddingTokens
+}
+
+// EstimateTokens approximates the token count of text (about 4 characters per token)
+func EstimateTokens(text string) int64 {
+	return int64(len(text)+3) / 4
+}
+
+// addLLMTokens records a completion's tokens on the context's Usage, if any
+func addLLMTokens(ctx context.Context, tokens int64) {
+	if usage, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		usage.mu.Lock()
+		usage.llmTokens += tokens
+		usage.mu.Unlock()
+	}
+}
+
+// addEmbeddingTokens records an embedding's tokens on the context's Usage, if any
+func addEmbeddingTokens(ctx context.Context, tokens int64) {
+	if usage, ok := ctx.Value(usageKey{}).(*Usage); ok {
+		usage.mu.Lock()
+		usage.embeddingTokens += tokens
+		usage.mu.Unlock()
+	}
+}
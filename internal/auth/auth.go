@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Subject identifies the caller, e.g. the API key name or the token's user
+	Subject string `json:"subject"`
+
+	// Owner is the tenant whose data the caller works with
+	Owner string `json:"owner"`
+
+	// Method is how the caller authenticated: "api_key", "jwt" or "none"
+	Method string `json:"method"`
+
+	// KeyID is the API key used, if any
+	KeyID int `json:"key_id,omitempty"`
+
+	// Role determines which routes the caller may use
+	Role Role `json:"role"`
+}
+
+// Can reports whether the caller's role grants a permission
+func (p *Principal) Can(perm Permission) bool {
+	return p != nil && p.Role.Can(perm)
+}
+
+// SetPrincipal stores the caller on the request. The owner is also exposed as
+// c.Locals("owner") so jobs and idempotency keys are scoped to the tenant.
+func SetPrincipal(c *fiber.Ctx, p *Principal) {
+	c.Locals("principal", p)
+	c.Locals("owner", p.Owner)
+}
+
+// FromContext returns the authenticated caller, or nil if authentication is disabled
+func FromContext(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals("principal").(*Principal)
+	return p
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// defaultJWKSCacheTTL is how long fetched keys are used before refetching
+	defaultJWKSCacheTTL = time.Hour
+
+	// minJWKSRefreshInterval limits refetches triggered by tokens with unknown key IDs
+	minJWKSRefreshInterval = time.Minute
+
+	// maxJWKSBytes bounds the size of a key set document
+	maxJWKSBytes = 1 << 20
+)
+
+// JWK is a single JSON Web Key (RFC 7517). Only public signing keys are used.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set document
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// verificationKey is a parsed public key from a key set
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKSCache loads a key set from a URL or a local file and caches the parsed keys.
+// Keys are refetched once the cache TTL passes, or early when a token names a key
+// ID that isn't cached, e.g. right after the identity provider rotated its keys.
+// Concurrent refreshes share one fetch, made without holding the cache's lock.
+type JWKSCache struct {
+	url  string
+	file string
+	ttl  time.Duration
+
+	client *http.Client
+	fetch  singleflight.Group
+
+	mu          sync.Mutex
+	keys        []verificationKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+// NewJWKSCache returns a cache for the key set at url, or in file if url is empty
+func NewJWKSCache(url string, file string, ttl time.Duration) *JWKSCache {
+	if ttl <= 0 {
+		ttl = defaultJWKSCacheTTL
+	}
+
+	return &JWKSCache{
+		url:    url,
+		file:   file,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Keys returns the cached keys matching kid (every key if kid is empty),
+// refreshing the key set when it is stale or kid is unknown.
+func (c *JWKSCache) Keys(ctx context.Context, kid string) ([]verificationKey, error) {
+	c.mu.Lock()
+	stale := time.Since(c.fetchedAt) > c.ttl
+	matches := c.match(kid)
+	unknown := len(matches) == 0 && time.Since(c.attemptedAt) > minJWKSRefreshInterval
+	c.mu.Unlock()
+
+	if !stale && !unknown {
+		return matches, nil
+	}
+
+	// The fetch is shared, so one caller giving up must not fail the others
+	result := c.fetch.DoChan("jwks", func() (interface{}, error) {
+		return nil, c.refresh(context.WithoutCancel(ctx))
+	})
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			c.mu.Lock()
+			cached := len(c.keys)
+			c.mu.Unlock()
+
+			// Keep serving the previous keys while the provider is unreachable
+			if cached == 0 {
+				return nil, res.Err
+			}
+			if !res.Shared {
+				log.Warn().Err(res.Err).Msg("failed to refresh JWKS, using cached keys")
+			}
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.match(kid), nil
+}
+
+// match returns the cached keys with the given key ID. Callers hold c.mu.
+func (c *JWKSCache) match(kid string) []verificationKey {
+	if kid == "" {
+		return c.keys
+	}
+
+	var matches []verificationKey
+	for _, k := range c.keys {
+		if k.kid == kid {
+			matches = append(matches, k)
+		}
+	}
+	return matches
+}
+
+// refresh reloads the key set, and swaps it in if it is usable. Callers don't hold
+// c.mu, which is only taken to update the cache.
+func (c *JWKSCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	c.attemptedAt = time.Now()
+	c.mu.Unlock()
+
+	raw, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set JWKSet
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make([]verificationKey, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", jwk.Kid).Msg("skipping unsupported JWKS key")
+			continue
+		}
+
+		keys = append(keys, verificationKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	if len(keys) == 0 {
+		return errors.New("JWKS contains no usable signing keys")
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// load reads the raw key set document
+func (c *JWKSCache) load(ctx context.Context) ([]byte, error) {
+	if c.url == "" {
+		return os.ReadFile(c.file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes))
+}
+
+// PublicKey parses the key material of a JWK
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// PublicJWK returns the JWK representation of a public key, e.g. to publish a
+// locally generated key for development
+func PublicJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: ecdsaAlg(pub.Curve),
+			Crv: pub.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: kid,
+			Use: "sig",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	}
+
+	return JWK{}, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// ecdsaAlg returns the JWS algorithm matching an EC curve
+func ecdsaAlg(curve elliptic.Curve) string {
+	switch curve.Params().BitSize {
+	case 384:
+		return "ES384"
+	case 521:
+		return "ES512"
+	default:
+		return "ES256"
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a key set with kid "k1", optionally holding requests until released
+type jwksServer struct {
+	*httptest.Server
+	hits    atomic.Int32
+	arrived chan struct{}
+
+	mu      sync.Mutex
+	release chan struct{}
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := PublicJWK("k1", key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(JWKSet{Keys: []JWK{jwk}})
+
+	s := &jwksServer{arrived: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.hits.Add(1)
+		s.arrived <- struct{}{}
+
+		s.mu.Lock()
+		release := s.release
+		s.mu.Unlock()
+		if release != nil {
+			<-release
+		}
+
+		w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// hold makes requests wait until the returned function is called
+func (s *jwksServer) hold() (release func()) {
+	ch := make(chan struct{})
+	s.mu.Lock()
+	s.release = ch
+	s.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(ch) }) }
+}
+
+func (s *jwksServer) waitForRequest(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-s.arrived:
+	case <-time.After(5 * time.Second):
+		t.Fatal("JWKS was not fetched")
+	}
+}
+
+func TestJWKSCacheSharesRefresh(t *testing.T) {
+	server := newJWKSServer(t)
+	cache := NewJWKSCache(server.URL, "", time.Hour)
+	release := server.hold()
+	defer release()
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys, err := cache.Keys(context.Background(), "k1")
+			if err == nil && len(keys) != 1 {
+				err = errors.New("k1 not found")
+			}
+			errs <- err
+		}()
+	}
+
+	server.waitForRequest(t)
+	release()
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits := server.hits.Load(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times, want once", hits)
+	}
+}
+
+func TestJWKSCacheServesCachedKeysDuringRefresh(t *testing.T) {
+	server := newJWKSServer(t)
+	cache := NewJWKSCache(server.URL, "", time.Hour)
+	ctx := context.Background()
+
+	if _, err := cache.Keys(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	server.waitForRequest(t)
+
+	// A token with an unknown kid triggers a refresh that hangs
+	release := server.hold()
+	defer release()
+
+	cache.mu.Lock()
+	cache.attemptedAt = time.Time{}
+	cache.mu.Unlock()
+
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		cache.Keys(ctx, "k2")
+	}()
+	server.waitForRequest(t)
+
+	// Cached keys are still served while it runs
+	done := make(chan error, 1)
+	go func() {
+		keys, err := cache.Keys(ctx, "k1")
+		if err == nil && len(keys) != 1 {
+			err = errors.New("k1 not found")
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached key lookup blocked by the refresh")
+	}
+
+	// A caller giving up doesn't cancel the shared fetch
+	cancelled, cancel := context.WithCancel(ctx)
+	cache.mu.Lock()
+	cache.fetchedAt = time.Time{}
+	cache.mu.Unlock()
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := cache.Keys(cancelled, "k1")
+		waiting <- err
+	}()
+	cancel()
+	if err := <-waiting; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Keys with a cancelled context: %v, want context.Canceled", err)
+	}
+
+	release()
+	<-refreshed
+
+	cache.mu.Lock()
+	fetched := !cache.fetchedAt.IsZero()
+	cache.mu.Unlock()
+	if !fetched {
+		t.Fatal("the shared refresh did not update the cache")
+	}
+}
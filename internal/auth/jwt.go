@@ -0,0 +1,407 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"notes-memory-core-rag/internal/config"
+)
+
+// jwtClockSkew tolerates small clock differences with the identity provider
+const jwtClockSkew = time.Minute
+
+// ErrInvalidToken is returned for malformed, unsigned, expired or otherwise
+// unacceptable bearer tokens. The wrapped message says why.
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTConfig configures bearer token validation
+type JWTConfig struct {
+	// JWKSURL or JWKSFile locates the identity provider's public keys
+	JWKSURL  string
+	JWKSFile string
+
+	// CacheTTL is how long fetched keys are reused
+	CacheTTL time.Duration
+
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+
+	// UserClaim and TenantClaim name the claims mapped to the principal's subject
+	// and owner. Nested claims use dots, e.g. "org.id".
+	UserClaim   string
+	TenantClaim string
+
+	// RoleClaim names the claim holding the user's role, a string or an array of
+	// strings (the most privileged known role wins). Tokens without a known role
+	// get DefaultRole.
+	RoleClaim   string
+	DefaultRole Role
+}
+
+// NewJWTConfig builds the verifier's configuration from the JWT_* settings
+func NewJWTConfig(cfg config.JWT) (JWTConfig, error) {
+	if cfg.JWKSURL == "" && cfg.JWKSFile == "" {
+		return JWTConfig{}, errors.New("JWT_JWKS_URL or JWT_JWKS_FILE is required")
+	}
+
+	role, err := ParseRole(cfg.DefaultRole)
+	if err != nil {
+		return JWTConfig{}, fmt.Errorf("invalid JWT_DEFAULT_ROLE: %w", err)
+	}
+
+	return JWTConfig{
+		JWKSURL:     cfg.JWKSURL,
+		JWKSFile:    cfg.JWKSFile,
+		CacheTTL:    cfg.JWKSCacheTTL,
+		Issuer:      cfg.Issuer,
+		Audience:    cfg.Audience,
+		UserClaim:   cfg.UserClaim,
+		TenantClaim: cfg.TenantClaim,
+		RoleClaim:   cfg.RoleClaim,
+		DefaultRole: role,
+	}, nil
+}
+
+// JWTVerifier validates bearer tokens signed by the configured identity provider
+type JWTVerifier struct {
+	cfg  JWTConfig
+	keys *JWKSCache
+}
+
+// NewJWTVerifier returns a verifier with an empty key cache; keys are loaded on first use
+func NewJWTVerifier(cfg JWTConfig) *JWTVerifier {
+	return &JWTVerifier{
+		cfg:  cfg,
+		keys: NewJWKSCache(cfg.JWKSURL, cfg.JWKSFile, cfg.CacheTTL),
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Verify checks a token's signature and registered claims and maps it to a principal.
+// The owner is the tenant claim, or "user:<subject>" for tokens without one, so every
+// user without a tenant gets a private one. Returns an error wrapping ErrInvalidToken
+// for tokens that must be rejected; other errors mean the key set could not be loaded.
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	headerPart, payloadPart, sigPart, ok := splitJWT(token)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(headerPart, &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	hash, ok := jwtHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	keys, err := v.keys.Keys(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(headerPart + "." + payloadPart)
+	verified := false
+	for _, k := range keys {
+		if k.alg != "" && k.alg != header.Alg {
+			continue
+		}
+		if verifySignature(header.Alg, hash, k.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(payloadPart, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	subject := claimString(claims, v.cfg.UserClaim)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, v.cfg.UserClaim)
+	}
+
+	owner := claimString(claims, v.cfg.TenantClaim)
+	if owner == "" {
+		owner = "user:" + subject
+	}
+
+	role := HighestRole(claimStrings(claims, v.cfg.RoleClaim))
+	if role == "" {
+		role = v.cfg.DefaultRole
+	}
+
+	return &Principal{
+		Subject: subject,
+		Owner:   owner,
+		Method:  "jwt",
+		Role:    role,
+	}, nil
+}
+
+// checkClaims validates exp, nbf, iss and aud
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		return errors.New("missing exp claim")
+	}
+	if now.After(exp.Add(jwtClockSkew)) {
+		return errors.New("token is expired")
+	}
+
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(jwtClockSkew).Before(nbf) {
+		return errors.New("token is not valid yet")
+	}
+
+	if v.cfg.Issuer != "" && claimString(claims, "iss") != v.cfg.Issuer {
+		return errors.New("unexpected issuer")
+	}
+
+	if v.cfg.Audience != "" && !hasAudience(claims["aud"], v.cfg.Audience) {
+		return errors.New("unexpected audience")
+	}
+
+	return nil
+}
+
+// jwtHashes lists the accepted signing algorithms. Symmetric algorithms and
+// "none" are deliberately absent: tokens must be signed by the identity provider.
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+// verifySignature checks a JWS signature with a key of the type the algorithm requires
+func verifySignature(alg string, hash crypto.Hash, key crypto.PublicKey, signed []byte, signature []byte) bool {
+	if alg == "EdDSA" {
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, signed, signature)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+		}) == nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecdsaAlg(pub.Curve) != alg {
+			return false
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+
+	return false
+}
+
+// SignJWT issues a token signed with an RSA (RS256), ECDSA or Ed25519 private key.
+// The API only verifies tokens; this is used to mint tokens against a locally
+// generated JWKS during development.
+func SignJWT(key crypto.Signer, kid string, claims map[string]interface{}) (string, error) {
+	var alg string
+	var hash crypto.Hash
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		alg, hash = "RS256", crypto.SHA256
+	case *ecdsa.PublicKey:
+		alg = ecdsaAlg(pub.Curve)
+		hash = jwtHashes[alg]
+	case ed25519.PublicKey:
+		alg = "EdDSA"
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", pub)
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: alg, Kid: kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := []byte(signed)
+	if hash != 0 {
+		h := hash.New()
+		h.Write(digest)
+		digest = h.Sum(nil)
+	}
+
+	var signature []byte
+	if priv, ok := key.(*ecdsa.PrivateKey); ok {
+		// JWS uses fixed-size r||s for ECDSA instead of ASN.1
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest)
+		if err != nil {
+			return "", err
+		}
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	} else {
+		signature, err = key.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// LooksLikeJWT reports whether a bearer credential has the shape of a JWT
+func LooksLikeJWT(token string) bool {
+	_, _, _, ok := splitJWT(token)
+	return ok
+}
+
+func splitJWT(token string) (header string, payload string, signature string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// claimValue resolves a claim name, following dots into nested objects
+func claimValue(claims map[string]interface{}, name string) interface{} {
+	if v, ok := claims[name]; ok {
+		return v
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = obj[part]
+	}
+	return current
+}
+
+// claimString returns a string or numeric claim as a string, "" if absent
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claimValue(claims, name).(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// claimStrings returns a string claim, or the strings of an array claim
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claimValue(claims, name).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// claimTime returns a NumericDate claim
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(seconds), 0), true
+}
+
+// hasAudience reports whether aud (a string or an array of strings) contains audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "notes-api"
+)
+
+// testKeys are signing keys published in a generated JWKS
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+	ed  ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKeys{rsa: rsaKey, ec: ecKey, ed: edKey}
+}
+
+// writeJWKS publishes the keys' public halves as kids "rsa", "ec" and "ed".
+// Without algs, keys don't declare the algorithm they may be used with.
+func (k testKeys) writeJWKS(t *testing.T, withAlgs bool) string {
+	t.Helper()
+
+	var set JWKSet
+	for kid, signer := range map[string]crypto.Signer{"rsa": k.rsa, "ec": k.ec, "ed": k.ed} {
+		jwk, err := PublicJWK(kid, signer.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !withAlgs {
+			jwk.Alg = ""
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestVerifier(jwksFile string) *JWTVerifier {
+	return NewJWTVerifier(JWTConfig{
+		JWKSFile:    jwksFile,
+		Issuer:      testIssuer,
+		Audience:    testAudience,
+		UserClaim:   "sub",
+		TenantClaim: "tenant_id",
+		RoleClaim:   "role",
+		DefaultRole: RoleViewer,
+	})
+}
+
+// validClaims returns claims every test verifier accepts
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":       "alice",
+		"tenant_id": "acme",
+		"iss":       testIssuer,
+		"aud":       testAudience,
+		"exp":       time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func sign(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	token, err := SignJWT(key, kid, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// rawToken assembles a token from a header, claims and signature without signing it
+func rawToken(t *testing.T, header map[string]interface{}, claims map[string]interface{}, signature []byte) string {
+	t.Helper()
+
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	if signature == nil {
+		mac := hmac.New(sha256.New, []byte("shared-secret"))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func with(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	if value == nil {
+		delete(claims, name)
+	} else {
+		claims[name] = value
+	}
+	return claims
+}
+
+func TestVerifySignedTokens(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(keys.writeJWKS(t, true))
+
+	tests := []struct {
+		kid string
+		key crypto.Signer
+	}{
+		{"rsa", keys.rsa},
+		{"ec", keys.ec},
+		{"ed", keys.ed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kid, func(t *testing.T) {
+			principal, err := verifier.Verify(context.Background(), sign(t, tt.key, tt.kid, validClaims()))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+
+			want := Principal{Subject: "alice", Owner: "acme", Method: "jwt", Role: RoleViewer}
+			if *principal != want {
+				t.Fatalf("principal = %+v, want %+v", *principal, want)
+			}
+		})
+	}
+
+	// Tokens without a kid are checked against every key
+	if _, err := verifier.Verify(context.Background(), sign(t, keys.ec, "", validClaims())); err != nil {
+		t.Fatalf("Verify without kid: %v", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(keys.writeJWKS(t, true))
+	now := time.Now()
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := sign(t, keys.rsa, "rsa", validClaims())
+	parts := strings.Split(tampered, ".")
+	forged, _ := json.Marshal(with(validClaims(), "tenant_id", "globex"))
+	tampered = parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"malformed", "not-a-token"},
+		{"empty signature", parts[0] + "." + parts[1] + "."},
+		{"garbled signature", parts[0] + "." + parts[1] + ".!!!"},
+		{"tampered claims", tampered},
+		{"unknown signer", sign(t, other, "rsa", validClaims())},
+		{"wrong kid", sign(t, keys.rsa, "ec", validClaims())},
+		{"unknown kid", sign(t, keys.rsa, "rotated-away", validClaims())},
+
+		{"expired", sign(t, keys.rsa, "rsa", with(validClaims(), "exp", now.Add(-2*jwtClockSkew).Unix()))},
+		{"missing exp", sign(t, keys.rsa, "rsa", with(validClaims(), "exp", nil))},
+		{"not valid yet", sign(t, keys.rsa, "rsa", with(validClaims(), "nbf", now.Add(time.Hour).Unix()))},
+		{"wrong issuer", sign(t, keys.rsa, "rsa", with(validClaims(), "iss", "https://evil.example.com"))},
+		{"missing issuer", sign(t, keys.rsa, "rsa", with(validClaims(), "iss", nil))},
+		{"wrong audience", sign(t, keys.rsa, "rsa", with(validClaims(), "aud", "other-api"))},
+		{"wrong audiences", sign(t, keys.rsa, "rsa", with(validClaims(), "aud", []string{"a", "b"}))},
+		{"missing subject", sign(t, keys.rsa, "rsa", with(validClaims(), "sub", nil))},
+
+		{"alg none", rawToken(t, map[string]interface{}{"alg": "none", "kid": "rsa"}, validClaims(), []byte("x"))},
+		{"alg HS256", rawToken(t, map[string]interface{}{"alg": "HS256", "kid": "rsa"}, validClaims(), nil)},
+		{"alg missing", rawToken(t, map[string]interface{}{"kid": "rsa"}, validClaims(), nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := verifier.Verify(context.Background(), tt.token)
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify = %+v, %v; want ErrInvalidToken", principal, err)
+			}
+		})
+	}
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	keys := newTestKeys(t)
+	verifier := newTestVerifier(keys.writeJWKS(t, true))
+	now := time.Now()
+
+	tokens := map[string]string{
+		"just expired":  sign(t, keys.rsa, "rsa", with(validClaims(), "exp", now.Add(-jwtClockSkew/2).Unix())),
+		"nbf just soon": sign(t, keys.rsa, "rsa", with(validClaims(), "nbf", now.Add(jwtClockSkew/2).Unix())),
+	}
+
+	for name, token := range tokens {
+		if _, err := verifier.Verify(context.Background(), token); err != nil {
+			t.Errorf("%s: Verify: %v, want it within the clock skew", name, err)
+		}
+	}
+}
+
+func TestVerifyAlgorithmKeyTypeMismatch(t *testing.T) {
+	keys := newTestKeys(t)
+
+	// Keys without alg accept any algorithm name, so the key type must match it
+	verifier := newTestVerifier(keys.writeJWKS(t, false))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"RS token against EC key", sign(t, keys.rsa, "ec", validClaims())},
+		{"ES token against RSA key", sign(t, keys.ec, "rsa", validClaims())},
+		{"EdDSA token against EC key", sign(t, keys.ed, "ec", validClaims())},
+		{"ES384 token against P-256 key", func() string {
+			p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sign(t, p384, "ec", validClaims())
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := verifier.Verify(context.Background(), tt.token); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Verify: %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	// The matching key still verifies
+	if _, err := verifier.Verify(context.Background(), sign(t, keys.ec, "ec", validClaims())); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+}
+
+func TestVerifyClaimMapping(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks := keys.writeJWKS(t, true)
+
+	tests := []struct {
+		name        string
+		tenantClaim string
+		claims      map[string]interface{}
+		wantOwner   string
+		wantRole    Role
+	}{
+		{"tenant claim", "tenant_id", validClaims(), "acme", RoleViewer},
+		{"no tenant is a private tenant", "tenant_id", with(validClaims(), "tenant_id", nil), "user:alice", RoleViewer},
+		{"numeric tenant", "tenant_id", with(validClaims(), "tenant_id", 42), "42", RoleViewer},
+		{"nested tenant claim", "org.id", with(validClaims(), "org", map[string]interface{}{"id": "initech"}), "initech", RoleViewer},
+		{"role", "tenant_id", with(validClaims(), "role", "admin"), "acme", RoleAdmin},
+		{"role is case-insensitive", "tenant_id", with(validClaims(), "role", "Editor"), "acme", RoleEditor},
+		{"highest of several roles", "tenant_id", with(validClaims(), "role", []string{"viewer", "editor", "owner"}), "acme", RoleEditor},
+		{"unknown role gets the default", "tenant_id", with(validClaims(), "role", "superuser"), "acme", RoleViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := newTestVerifier(jwks)
+			verifier.cfg.TenantClaim = tt.tenantClaim
+
+			principal, err := verifier.Verify(context.Background(), sign(t, keys.rsa, "rsa", tt.claims))
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if principal.Subject != "alice" || principal.Owner != tt.wantOwner || principal.Role != tt.wantRole {
+				t.Fatalf("principal = %+v, want owner %q and role %q", *principal, tt.wantOwner, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutKeySet(t *testing.T) {
+	verifier := newTestVerifier(filepath.Join(t.TempDir(), "missing.json"))
+	keys := newTestKeys(t)
+
+	// An unavailable key set is an error of the server, not of the token
+	_, err := verifier.Verify(context.Background(), sign(t, keys.rsa, "rsa", validClaims()))
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify: %v, want a key set error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role is a named set of permissions. Each role includes everything the
+// roles below it may do: viewer < editor < admin.
+type Role string
+
+const (
+	// RoleViewer reads notes, searches and runs queries (including query jobs)
+	RoleViewer Role = "viewer"
+
+	// RoleEditor also creates notes, cancels jobs and manages recurring schedules
+	RoleEditor Role = "editor"
+
+	// RoleAdmin also administers jobs and workers, reads metrics and the audit log,
+	// and manages API keys
+	RoleAdmin Role = "admin"
+)
+
+// DefaultRole is given to API keys created without an explicit role
+const DefaultRole = RoleEditor
+
+// Permission is an operation a route requires
+type Permission string
+
+const (
+	PermReadNotes      Permission = "notes:read"
+	PermWriteNotes     Permission = "notes:write"
+	PermQuery          Permission = "query"
+	PermRunJobs        Permission = "jobs:run"
+	PermCancelJobs     Permission = "jobs:cancel"
+	PermReadJobs       Permission = "jobs:read"
+	PermReadUsage      Permission = "usage:read"
+	PermWriteSchedules Permission = "schedules:write"
+	PermAdminJobs      Permission = "jobs:admin"
+	PermReadMetrics    Permission = "metrics:read"
+	PermManageAPIKeys  Permission = "api_keys:manage"
+	PermReadAudit      Permission = "audit:read"
+)
+
+// rolePermissions lists what each role adds to the role below it
+var rolePermissions = map[Role][]Permission{
+	RoleViewer: {PermReadNotes, PermQuery, PermRunJobs, PermReadJobs, PermReadUsage},
+	RoleEditor: {PermWriteNotes, PermCancelJobs, PermWriteSchedules},
+	RoleAdmin:  {PermAdminJobs, PermReadMetrics, PermManageAPIKeys, PermReadAudit},
+}
+
+// roleOrder ranks roles from least to most privileged
+var roleOrder = []Role{RoleViewer, RoleEditor, RoleAdmin}
+
+// ParseRole validates a role name
+func ParseRole(name string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(name)))
+	if role.rank() < 0 {
+		return "", fmt.Errorf("unknown role %q, expected viewer, editor or admin", name)
+	}
+	return role, nil
+}
+
+// rank returns the role's position in roleOrder, -1 for unknown roles
+func (r Role) rank() int {
+	for i, role := range roleOrder {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Can reports whether the role grants a permission
+func (r Role) Can(perm Permission) bool {
+	rank := r.rank()
+	for i := 0; i <= rank; i++ {
+		for _, p := range rolePermissions[roleOrder[i]] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HighestRole returns the most privileged known role among names, or "" if none is known
+func HighestRole(names []string) Role {
+	var best Role
+	for _, name := range names {
+		role, err := ParseRole(name)
+		if err == nil && role.rank() > best.rank() {
+			best = role
+		}
+	}
+	return best
+}
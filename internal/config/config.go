@@ -0,0 +1,387 @@
+// Package config loads the settings of the API, the worker and the CLI tools.
+//
+// Every setting has an environment variable (e.g. RATE_LIMIT_BUDGET) and a key
+// in an optional YAML or TOML file (e.g. rate_limit.budget). Values are taken,
+// in order of precedence, from:
+//
+//  1. the environment, including a .env file in the working directory
+//  2. NAME_FILE, naming a file that holds the value (for Docker/Kubernetes secrets)
+//  3. the config file named by CONFIG_FILE, where key_file works like NAME_FILE
+//  4. the defaults, some of which depend on ENV
+//
+// Load validates everything at once and reports every invalid setting.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+)
+
+// Environments selected by ENV
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// Config holds every setting
+type Config struct {
+	// Env is Development or Production; production tightens CORS, body limits and
+	// callbacks, and sends HSTS
+	Env string
+
+	Server      Server
+	Database    Database
+	Redis       Redis
+	AI          AI
+	Auth        Auth
+	RateLimit   RateLimit
+	Idempotency Idempotency
+	Quota       Quota
+	Webhooks    Webhooks
+	Worker      Worker
+}
+
+// IsProduction reports whether production defaults apply
+func (c *Config) IsProduction() bool {
+	return c.Env == Production
+}
+
+// Server configures the HTTP API
+type Server struct {
+	Port string
+
+	// BodyLimit is the maximum request body size in bytes
+	BodyLimit int
+
+	// HSTSMaxAge is sent in Strict-Transport-Security; zero disables the header
+	HSTSMaxAge time.Duration
+
+	CORS CORS
+}
+
+// CORS configures cross-origin access. No AllowOrigins disables CORS.
+type CORS struct {
+	AllowOrigins     []string
+	AllowCredentials bool
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+
+	// MaxAge is how long browsers may cache preflight results
+	MaxAge time.Duration
+}
+
+// Database locates Postgres
+type Database struct {
+	URL string
+}
+
+// Redis locates Redis. Without Addr, async jobs are disabled.
+type Redis struct {
+	Addr string
+}
+
+// AI selects mock or OpenAI embeddings and completions
+type AI struct {
+	OpenAIAPIKey   string
+	MockEmbeddings bool
+	MockLLM        bool
+}
+
+// Auth selects how callers authenticate: "apikey", "jwt" or "none"
+type Auth struct {
+	Mode string
+	JWT  JWT
+}
+
+// JWT configures bearer token validation (Auth.Mode "jwt")
+type JWT struct {
+	JWKSURL      string
+	JWKSFile     string
+	JWKSCacheTTL time.Duration
+	Issuer       string
+	Audience     string
+	UserClaim    string
+	TenantClaim  string
+	RoleClaim    string
+	DefaultRole  string
+}
+
+// RateLimit is the cost budget every caller may spend per window
+type RateLimit struct {
+	Budget int
+	Window time.Duration
+
+	// IPBudget is how many requests one client IP may send per Window before
+	// authentication, which bounds key lookups and token checks
+	IPBudget int
+}
+
+// Idempotency configures replays of retried requests
+type Idempotency struct {
+	// KeyTTL is how long responses stored under an Idempotency-Key are replayed
+	KeyTTL time.Duration
+
+	// Window is how far back job enqueues look for duplicates; zero disables the check
+	Window time.Duration
+}
+
+// Quota holds the default per-tenant quotas. Zero means unlimited.
+type Quota struct {
+	LLMTokens       int64
+	EmbeddingTokens int64
+	Notes           int64
+}
+
+// Webhooks configures job completion callbacks
+type Webhooks struct {
+	// Secret signs callbacks; they are sent unsigned without it
+	Secret string
+
+	// RequireSignature refuses callbacks while Secret is empty (production)
+	RequireSignature bool
+}
+
+// Worker configures the background worker. Queues, RetryPolicies and
+// JobRetention use their own formats and are parsed by the worker.
+type Worker struct {
+	Queues             string
+	RetryPolicies      string
+	JobRetention       string
+	JobPruneInterval   time.Duration
+	JobArchiveDir      string
+	JobPruneDryRun     bool
+	AuditRetention     time.Duration
+	AuditPruneInterval time.Duration
+}
+
+// defaults returns the settings used when nothing else sets them
+func defaults(env string) *Config {
+	cfg := &Config{
+		Env: env,
+		Server: Server{
+			Port:      "8080",
+			BodyLimit: 4 << 20,
+			CORS: CORS{
+				AllowOrigins: []string{"*"},
+				AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+				AllowHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "Idempotency-Key"},
+				ExposeHeaders: []string{
+					"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+					"Retry-After", "Idempotent-Replayed", "X-Request-ID",
+				},
+				MaxAge: 10 * time.Minute,
+			},
+		},
+		Auth: Auth{
+			Mode: "apikey",
+			JWT: JWT{
+				JWKSCacheTTL: time.Hour,
+				UserClaim:    "sub",
+				TenantClaim:  "tenant_id",
+				RoleClaim:    "role",
+				DefaultRole:  "viewer",
+			},
+		},
+		RateLimit: RateLimit{
+			Budget:   120,
+			Window:   time.Minute,
+			IPBudget: 1200,
+		},
+		Idempotency: Idempotency{
+			KeyTTL: 24 * time.Hour,
+			Window: 5 * time.Minute,
+		},
+		Worker: Worker{
+			JobPruneInterval:   time.Hour,
+			AuditPruneInterval: time.Hour,
+		},
+	}
+
+	// Production only allows configured origins, takes smaller bodies, pins HTTPS
+	// and only sends signed callbacks
+	if env == Production {
+		cfg.Server.BodyLimit = 1 << 20
+		cfg.Server.HSTSMaxAge = 365 * 24 * time.Hour
+		cfg.Server.CORS.AllowOrigins = nil
+		cfg.Webhooks.RequireSignature = true
+	}
+
+	return cfg
+}
+
+// settings lists every setting with its environment variable and file key
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"PORT", "server.port", port(&cfg.Server.Port)},
+		{"BODY_LIMIT", "server.body_limit", byteSize(&cfg.Server.BodyLimit)},
+		{"HSTS_MAX_AGE", "server.hsts_max_age", duration(&cfg.Server.HSTSMaxAge, 0)},
+		{"CORS_ALLOW_ORIGINS", "server.cors.allow_origins", list(&cfg.Server.CORS.AllowOrigins)},
+		{"CORS_ALLOW_CREDENTIALS", "server.cors.allow_credentials", boolean(&cfg.Server.CORS.AllowCredentials)},
+		{"CORS_ALLOW_METHODS", "server.cors.allow_methods", list(&cfg.Server.CORS.AllowMethods)},
+		{"CORS_ALLOW_HEADERS", "server.cors.allow_headers", list(&cfg.Server.CORS.AllowHeaders)},
+		{"CORS_EXPOSE_HEADERS", "server.cors.expose_headers", list(&cfg.Server.CORS.ExposeHeaders)},
+		{"CORS_MAX_AGE", "server.cors.max_age", duration(&cfg.Server.CORS.MaxAge, 0)},
+
+		{"DATABASE_URL", "database.url", str(&cfg.Database.URL)},
+		{"REDIS_ADDR", "redis.addr", str(&cfg.Redis.Addr)},
+
+		{"OPENAI_API_KEY", "ai.openai_api_key", str(&cfg.AI.OpenAIAPIKey)},
+		{"USE_MOCK_EMBEDDINGS", "ai.mock_embeddings", boolean(&cfg.AI.MockEmbeddings)},
+		{"USE_MOCK_LLM", "ai.mock_llm", boolean(&cfg.AI.MockLLM)},
+
+		{"AUTH_MODE", "auth.mode", oneOf(&cfg.Auth.Mode, "apikey", "jwt", "none")},
+		{"JWT_JWKS_URL", "auth.jwt.jwks_url", str(&cfg.Auth.JWT.JWKSURL)},
+		{"JWT_JWKS_FILE", "auth.jwt.jwks_file", str(&cfg.Auth.JWT.JWKSFile)},
+		{"JWT_JWKS_CACHE_TTL", "auth.jwt.jwks_cache_ttl", duration(&cfg.Auth.JWT.JWKSCacheTTL, time.Second)},
+		{"JWT_ISSUER", "auth.jwt.issuer", str(&cfg.Auth.JWT.Issuer)},
+		{"JWT_AUDIENCE", "auth.jwt.audience", str(&cfg.Auth.JWT.Audience)},
+		{"JWT_USER_CLAIM", "auth.jwt.user_claim", str(&cfg.Auth.JWT.UserClaim)},
+		{"JWT_TENANT_CLAIM", "auth.jwt.tenant_claim", str(&cfg.Auth.JWT.TenantClaim)},
+		{"JWT_ROLE_CLAIM", "auth.jwt.role_claim", str(&cfg.Auth.JWT.RoleClaim)},
+		{"JWT_DEFAULT_ROLE", "auth.jwt.default_role", oneOf(&cfg.Auth.JWT.DefaultRole, "viewer", "editor", "admin")},
+
+		{"RATE_LIMIT_BUDGET", "rate_limit.budget", integer(&cfg.RateLimit.Budget, 1)},
+		{"RATE_LIMIT_WINDOW", "rate_limit.window", duration(&cfg.RateLimit.Window, time.Second)},
+		{"RATE_LIMIT_IP_BUDGET", "rate_limit.ip_budget", integer(&cfg.RateLimit.IPBudget, 1)},
+
+		{"IDEMPOTENCY_KEY_TTL", "idempotency.key_ttl", duration(&cfg.Idempotency.KeyTTL, time.Second)},
+		{"IDEMPOTENCY_WINDOW", "idempotency.window", duration(&cfg.Idempotency.Window, 0)},
+
+		{"QUOTA_LLM_TOKENS", "quota.llm_tokens", integer64(&cfg.Quota.LLMTokens)},
+		{"QUOTA_EMBEDDING_TOKENS", "quota.embedding_tokens", integer64(&cfg.Quota.EmbeddingTokens)},
+		{"QUOTA_NOTES", "quota.notes", integer64(&cfg.Quota.Notes)},
+
+		{"WEBHOOK_SECRET", "webhooks.secret", str(&cfg.Webhooks.Secret)},
+
+		{"WORKER_QUEUES", "worker.queues", str(&cfg.Worker.Queues)},
+		{"JOB_RETRY_POLICIES", "worker.retry_policies", str(&cfg.Worker.RetryPolicies)},
+		{"JOB_RETENTION", "worker.job_retention", str(&cfg.Worker.JobRetention)},
+		{"JOB_PRUNE_INTERVAL", "worker.job_prune_interval", duration(&cfg.Worker.JobPruneInterval, time.Second)},
+		{"JOB_ARCHIVE_DIR", "worker.job_archive_dir", str(&cfg.Worker.JobArchiveDir)},
+		{"JOB_PRUNE_DRY_RUN", "worker.job_prune_dry_run", boolean(&cfg.Worker.JobPruneDryRun)},
+		{"AUDIT_RETENTION", "worker.audit_retention", duration(&cfg.Worker.AuditRetention, 0)},
+		{"AUDIT_PRUNE_INTERVAL", "worker.audit_prune_interval", duration(&cfg.Worker.AuditPruneInterval, time.Second)},
+	}
+}
+
+// Check validates settings only some processes need, e.g. RequireAI
+type Check func(cfg *Config) []error
+
+// RequireAI checks that OpenAI can be called: OPENAI_API_KEY is set unless both
+// embeddings and the LLM are mocked. The API and the worker need it; the apikey
+// CLI doesn't.
+func RequireAI(cfg *Config) []error {
+	if (!cfg.AI.MockEmbeddings || !cfg.AI.MockLLM) && cfg.AI.OpenAIAPIKey == "" {
+		return []error{errors.New("OPENAI_API_KEY (ai.openai_api_key) is required unless USE_MOCK_EMBEDDINGS and USE_MOCK_LLM are true")}
+	}
+	return nil
+}
+
+// Load reads the configuration. path names a YAML (.yaml, .yml) or TOML (.toml)
+// file; if empty, CONFIG_FILE is used, and without either only the environment
+// is read. checks add validation for the calling process. The returned error
+// lists every invalid setting.
+func Load(path string, checks ...Check) (*Config, error) {
+	if err := godotenv.Load(); err != nil {
+		log.Info().Msg("No .env file found, using environment variables")
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	src := &sources{}
+	if path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		src.file = path
+		src.values = values
+	}
+
+	var errs []error
+
+	env := Development
+	if raw, from, ok, err := src.lookup("ENV", "env"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		switch strings.ToLower(strings.TrimSpace(raw)) {
+		case "production", "prod":
+			env = Production
+		case "development", "dev", "local", "test":
+			env = Development
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown environment %q, expected development or production", from, raw))
+		}
+	}
+
+	cfg := defaults(env)
+
+	for _, s := range cfg.settings() {
+		raw, from, ok, err := src.lookup(s.env, s.key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if err := s.parse(raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", from, err))
+		}
+	}
+
+	errs = append(errs, src.unknownKeys(cfg.settings())...)
+	errs = append(errs, cfg.validate()...)
+	for _, check := range checks {
+		errs = append(errs, check(cfg)...)
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+
+	return cfg, nil
+}
+
+// validate checks settings that depend on each other
+func (cfg *Config) validate() []error {
+	var errs []error
+
+	if cfg.Database.URL == "" {
+		errs = append(errs, errors.New("DATABASE_URL (database.url) is required"))
+	}
+
+	// Without authentication every caller is an admin without a tenant
+	if cfg.Auth.Mode == "none" && cfg.IsProduction() {
+		errs = append(errs, errors.New("AUTH_MODE=none (auth.mode) is not allowed with ENV=production"))
+	}
+
+	if cfg.Auth.Mode == "jwt" && cfg.Auth.JWT.JWKSURL == "" && cfg.Auth.JWT.JWKSFile == "" {
+		errs = append(errs, errors.New("AUTH_MODE=jwt requires JWT_JWKS_URL (auth.jwt.jwks_url) or JWT_JWKS_FILE (auth.jwt.jwks_file)"))
+	}
+
+	if cfg.Idempotency.Window > 24*time.Hour {
+		errs = append(errs, errors.New("IDEMPOTENCY_WINDOW (idempotency.window) must be at most 24h"))
+	}
+
+	if cfg.Server.CORS.AllowCredentials {
+		for _, origin := range cfg.Server.CORS.AllowOrigins {
+			if origin == "*" {
+				errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS=true requires explicit CORS_ALLOW_ORIGINS, not *"))
+				break
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAuthMode(t *testing.T) {
+	tests := []struct {
+		env     string
+		mode    string
+		wantErr bool
+	}{
+		{Development, "none", false},
+		{Production, "none", true},
+		{Production, "apikey", false},
+	}
+
+	for _, tt := range tests {
+		cfg := defaults(tt.env)
+		cfg.Database.URL = "postgres://localhost/notes"
+		cfg.Auth.Mode = tt.mode
+
+		var authErr bool
+		for _, err := range cfg.validate() {
+			authErr = authErr || strings.Contains(err.Error(), "AUTH_MODE")
+		}
+		if authErr != tt.wantErr {
+			t.Errorf("ENV=%s AUTH_MODE=%s: errors %v, want an AUTH_MODE error: %v", tt.env, tt.mode, cfg.validate(), tt.wantErr)
+		}
+	}
+}
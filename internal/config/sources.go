@@ -0,0 +1,282 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// setting maps an environment variable and a file key onto a Config field
+type setting struct {
+	env   string
+	key   string
+	parse func(raw string) error
+}
+
+// sources looks up raw values in the environment and the config file
+type sources struct {
+	file string
+
+	// values holds the file's settings by dotted key, e.g. "rate_limit.budget"
+	values map[string]string
+}
+
+// lookup returns a setting's raw value and where it came from. Empty
+// environment variables count as unset.
+func (s *sources) lookup(env string, key string) (raw string, from string, ok bool, err error) {
+	value, fromFile := os.Getenv(env), os.Getenv(env+"_FILE")
+	switch {
+	case value != "" && fromFile != "":
+		return "", "", false, fmt.Errorf("set either %s or %s_FILE, not both", env, env)
+	case value != "":
+		return value, env, true, nil
+	case fromFile != "":
+		raw, err := readSecret(fromFile)
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s_FILE: %w", env, err)
+		}
+		return raw, env + "_FILE", true, nil
+	}
+
+	value, hasValue := s.values[key]
+	fromFile, hasFile := s.values[key+"_file"]
+	switch {
+	case hasValue && hasFile:
+		return "", "", false, fmt.Errorf("%s: set either %s or %s_file, not both", s.file, key, key)
+	case hasValue:
+		return value, fmt.Sprintf("%s in %s", key, s.file), true, nil
+	case hasFile:
+		raw, err := readSecret(fromFile)
+		if err != nil {
+			return "", "", false, fmt.Errorf("%s_file in %s: %w", key, s.file, err)
+		}
+		return raw, fmt.Sprintf("%s_file in %s", key, s.file), true, nil
+	}
+
+	return "", "", false, nil
+}
+
+// unknownKeys reports file keys that match no setting, which are usually typos
+func (s *sources) unknownKeys(settings []setting) []error {
+	known := map[string]bool{"env": true, "env_file": true}
+	for _, setting := range settings {
+		known[setting.key] = true
+		known[setting.key+"_file"] = true
+	}
+
+	var keys []string
+	for key := range s.values {
+		if !known[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, fmt.Errorf("%s: unknown setting %q", s.file, key))
+	}
+	return errs
+}
+
+// readSecret reads a value from a file, dropping the trailing newline most secret files have
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readFile parses a YAML or TOML config file into dotted keys
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config file: %w", err)
+	}
+
+	tree := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten("", tree, values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// flatten turns nested sections into dotted keys. Lists become comma-separated values.
+func flatten(prefix string, tree map[string]interface{}, values map[string]string) error {
+	for name, value := range tree {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if err := flatten(key, v, values); err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			// An empty value leaves the default in place
+		case string, bool, int, int64, uint64, float64:
+			values[key] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("%s: unsupported value %v", key, v)
+		}
+	}
+	return nil
+}
+
+func str(target *string) func(string) error {
+	return func(raw string) error {
+		*target = strings.TrimSpace(raw)
+		return nil
+	}
+}
+
+func boolean(target *bool) func(string) error {
+	return func(raw string) error {
+		v, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", raw)
+		}
+		*target = v
+		return nil
+	}
+}
+
+func integer(target *int, min int) func(string) error {
+	return func(raw string) error {
+		v, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || v < min {
+			return fmt.Errorf("expected an integer of at least %d, got %q", min, raw)
+		}
+		*target = v
+		return nil
+	}
+}
+
+// integer64 parses a non-negative count, such as a quota
+func integer64(target *int64) func(string) error {
+	return func(raw string) error {
+		v, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("expected a non-negative integer, got %q", raw)
+		}
+		*target = v
+		return nil
+	}
+}
+
+// duration parses Go durations ("90s", "1h30m") plus days ("7d")
+func duration(target *time.Duration, min time.Duration) func(string) error {
+	return func(raw string) error {
+		raw = strings.TrimSpace(raw)
+
+		var v time.Duration
+		var err error
+		if days, ok := strings.CutSuffix(raw, "d"); ok {
+			var n int
+			n, err = strconv.Atoi(days)
+			v = time.Duration(n) * 24 * time.Hour
+		} else {
+			v, err = time.ParseDuration(raw)
+		}
+
+		if err != nil || v < min {
+			return fmt.Errorf("expected a duration of at least %s like \"10m\" or \"7d\", got %q", min, raw)
+		}
+		*target = v
+		return nil
+	}
+}
+
+// list parses a comma-separated list, dropping empty entries
+func list(target *[]string) func(string) error {
+	return func(raw string) error {
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*target = items
+		return nil
+	}
+}
+
+// byteSize parses sizes like "1048576", "512KB" or "2MB" (binary units)
+func byteSize(target *int) func(string) error {
+	return func(raw string) error {
+		s := strings.ToUpper(strings.TrimSpace(raw))
+
+		multiplier := 1
+		for _, unit := range []struct {
+			suffix string
+			size   int
+		}{{"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+			if strings.HasSuffix(s, unit.suffix) {
+				multiplier = unit.size
+				s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+				break
+			}
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("expected a size like \"512KB\" or \"2MB\", got %q", raw)
+		}
+		*target = n * multiplier
+		return nil
+	}
+}
+
+func port(target *string) func(string) error {
+	return func(raw string) error {
+		raw = strings.TrimSpace(raw)
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("expected a port between 1 and 65535, got %q", raw)
+		}
+		*target = raw
+		return nil
+	}
+}
+
+func oneOf(target *string, allowed ...string) func(string) error {
+	return func(raw string) error {
+		v := strings.ToLower(strings.TrimSpace(raw))
+		for _, a := range allowed {
+			if v == a {
+				*target = v
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of %s, got %q", strings.Join(allowed, ", "), raw)
+	}
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// apiKeyTouchInterval limits how often last_used_at is written for a busy key
+const apiKeyTouchInterval = time.Minute
+
+// APIKey is a stored API key. Only the SHA-256 hash of the secret is kept.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner"`
+	Prefix     string     `json:"prefix"`
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// apiKeyColumns is the column list scanned by scanAPIKey
+const apiKeyColumns = `id, name, owner, prefix, role, created_at, last_used_at, expires_at, revoked_at`
+
+// scanAPIKey scans a row selected with apiKeyColumns
+func scanAPIKey(row pgx.Row) (*APIKey, error) {
+	var k APIKey
+	if err := row.Scan(&k.ID, &k.Name, &k.Owner, &k.Prefix, &k.Role,
+		&k.CreatedAt, &k.LastUsedAt, &k.ExpiresAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateAPIKey stores a new key by its hash
+func (db *DB) CreateAPIKey(ctx context.Context, key APIKey, keyHash string) (*APIKey, error) {
+	return scanAPIKey(db.pool.QueryRow(ctx, `
+		INSERT INTO api_keys (name, owner, prefix, key_hash, role, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+apiKeyColumns,
+		key.Name, key.Owner, key.Prefix, keyHash, key.Role, key.ExpiresAt))
+}
+
+// GetActiveAPIKey returns the unexpired key with the given hash that isn't revoked yet
+// (rotated keys stay valid until their revoked_at), or pgx.ErrNoRows if there is none
+func (db *DB) GetActiveAPIKey(ctx context.Context, keyHash string) (*APIKey, error) {
+	return scanAPIKey(db.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE key_hash = $1
+		AND (revoked_at IS NULL OR revoked_at > NOW())
+		AND (expires_at IS NULL OR expires_at > NOW())
+	`, keyHash))
+}
+
+// GetAPIKey returns a key of the tenant (owner) by ID, or pgx.ErrNoRows.
+// An empty owner matches every tenant's keys; only the apikey CLI passes one.
+func (db *DB) GetAPIKey(ctx context.Context, id int, owner string) (*APIKey, error) {
+	return scanAPIKey(db.pool.QueryRow(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE id = $1
+		AND ($2 = '' OR owner = $2)
+	`, id, owner))
+}
+
+// TouchAPIKey records that a key was used, at most once per apiKeyTouchInterval
+func (db *DB) TouchAPIKey(ctx context.Context, id int) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET last_used_at = NOW()
+		WHERE id = $1
+		AND (last_used_at IS NULL OR last_used_at < $2)
+	`, id, time.Now().Add(-apiKeyTouchInterval))
+	return err
+}
+
+// ListAPIKeys returns the keys of a tenant (owner), newest first.
+// An empty owner lists every tenant's keys; only the apikey CLI passes one.
+func (db *DB) ListAPIKeys(ctx context.Context, owner string) ([]APIKey, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE ($1 = '' OR owner = $1)
+		ORDER BY id DESC
+	`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key of the tenant (owner), optionally only once revokeAt
+// passes (for rotation). Returns false if the tenant has no such key or it is
+// already revoked. An empty owner matches every tenant's keys (apikey CLI only).
+func (db *DB) RevokeAPIKey(ctx context.Context, id int, owner string, revokeAt time.Time) (bool, error) {
+	tag, err := db.pool.Exec(ctx, `
+		UPDATE api_keys
+		SET revoked_at = $2
+		WHERE id = $1
+		AND ($3 = '' OR owner = $3)
+		AND (revoked_at IS NULL OR revoked_at > $2)
+	`, id, revokeAt, owner)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
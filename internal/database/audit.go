@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audited actions recorded in audit_log
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+	AuditSearch = "search"
+	AuditQuery  = "query"
+	AuditExport = "export"
+)
+
+// AuditEntry is one row of the append-only audit log: who did what to which records
+type AuditEntry struct {
+	ID int64 `json:"id"`
+
+	// Owner is the tenant the action was performed in; nil without authentication
+	Owner *string `json:"owner,omitempty"`
+
+	// Actor is the principal's subject (API key name, token user, or "worker:<id>")
+	Actor      string  `json:"actor"`
+	AuthMethod string  `json:"auth_method"`
+	Role       *string `json:"role,omitempty"`
+
+	Action    string   `json:"action"`
+	Resource  string   `json:"resource"`
+	TargetIDs []string `json:"target_ids"`
+
+	// RequestID is the X-Request-ID of the API request, or the job ID for worker actions
+	RequestID *string `json:"request_id,omitempty"`
+
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RecordAudit appends an entry to the audit log. Empty owner, role and request
+// ID are stored as NULL.
+func (db *DB) RecordAudit(ctx context.Context, entry AuditEntry, details interface{}) error {
+	var detailsBytes []byte
+	if details != nil {
+		var err error
+		if detailsBytes, err = json.Marshal(details); err != nil {
+			return err
+		}
+	}
+
+	targetIDs := entry.TargetIDs
+	if targetIDs == nil {
+		targetIDs = []string{}
+	}
+
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO audit_log (owner, actor, auth_method, role, action, resource, target_ids, request_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, entry.Owner, entry.Actor, entry.AuthMethod, entry.Role, entry.Action, entry.Resource,
+		targetIDs, entry.RequestID, detailsBytes)
+	return err
+}
+
+// AuditFilter selects audit log entries for ListAuditEntries. Zero values are ignored.
+type AuditFilter struct {
+	Owner     string
+	Actor     string
+	Actions   []string
+	Resource  string
+	TargetID  string
+	RequestID string
+	After     *time.Time
+	Before    *time.Time
+
+	// BeforeID continues a listing after the last entry of the previous page
+	BeforeID int64
+	Limit    int
+}
+
+// ListAuditEntries returns matching entries, newest first
+func (db *DB) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var conds []string
+	var args []interface{}
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if filter.Owner != "" {
+		add("owner = $%d", filter.Owner)
+	}
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if len(filter.Actions) > 0 {
+		add("action = ANY($%d)", filter.Actions)
+	}
+	if filter.Resource != "" {
+		add("resource = $%d", filter.Resource)
+	}
+	if filter.TargetID != "" {
+		add("target_ids @> ARRAY[$%d::text]", filter.TargetID)
+	}
+	if filter.RequestID != "" {
+		add("request_id = $%d", filter.RequestID)
+	}
+	if filter.After != nil {
+		add("created_at >= $%d", *filter.After)
+	}
+	if filter.Before != nil {
+		add("created_at < $%d", *filter.Before)
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	args = append(args, filter.Limit)
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, owner, actor, auth_method, role, action, resource, target_ids, request_id, details, created_at
+		FROM audit_log
+		`+where+`
+		ORDER BY id DESC
+		LIMIT $`+fmt.Sprint(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(
+			&e.ID,
+			&e.Owner,
+			&e.Actor,
+			&e.AuthMethod,
+			&e.Role,
+			&e.Action,
+			&e.Resource,
+			&e.TargetIDs,
+			&e.RequestID,
+			&e.Details,
+			&e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// PruneAuditLog deletes up to batchSize entries older than before and returns how
+// many were deleted. Call repeatedly until fewer than batchSize rows are deleted.
+func (db *DB) PruneAuditLog(ctx context.Context, before time.Time, batchSize int) (int, error) {
+	tag, err := db.pool.Exec(ctx, `
+		DELETE FROM audit_log
+		WHERE id IN (
+			SELECT id FROM audit_log
+			WHERE created_at < $1
+			ORDER BY id ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+	`, before, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// BatchItem is the outcome of one query of a batch job
+type BatchItem struct {
+	Index  int              `json:"index"`
+	JobID  string           `json:"job_id"`
+	Query  string           `json:"query"`
+	Status string           `json:"status"`
+	Result *json.RawMessage `json:"result,omitempty"`
+	Error  *string          `json:"error,omitempty"`
+}
+
+// BatchResult aggregates the child jobs of a batch job
+type BatchResult struct {
+	Total     int         `json:"total"`
+	Completed int         `json:"completed"`
+	Failed    int         `json:"failed"`
+	Cancelled int         `json:"cancelled"`
+	Pending   int         `json:"pending"`
+	Items     []BatchItem `json:"items"`
+}
+
+// CreateBatchJob stores a 'batch' parent job and one queued 'query' child per query
+// in a single transaction. The parent stays 'processing' until every child is final.
+// Returns the parent ID and the child IDs in query order, or ErrDuplicateJob if the
+// client idempotency key is already held.
+func (db *DB) CreateBatchJob(ctx context.Context, input interface{}, queries []string, contentHash string, opts JobOptions) (string, []string, error) {
+	parentID := uuid.New().String()
+
+	inputBytes, err := json.Marshal(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueue
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO jobs (id, type, input, status, content_hash, retry_count, queue, priority,
+		                  owner, request_hash)
+		VALUES ($1, 'batch', $2, 'processing', $3, 0, $4, $5, $6, $7)
+	`+clientKeyConflict, parentID, inputBytes, contentHash, opts.Queue, opts.Priority,
+		nullIfEmpty(opts.Owner), nullIfEmpty(opts.RequestHash))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return "", nil, ErrDuplicateJob
+	}
+
+	childIDs := make([]string, 0, len(queries))
+	for i, query := range queries {
+		childID := uuid.New().String()
+		childInput, _ := json.Marshal(map[string]string{"query": query})
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO jobs (id, type, input, status, content_hash, retry_count, queue, priority,
+			                  owner, parent_id, batch_index)
+			VALUES ($1, 'query', $2, 'queued', $3, 0, $4, $5, $6, $7, $8)
+		`, childID, childInput, fmt.Sprintf("batch:%s:%d", parentID, i), opts.Queue, opts.Priority,
+			nullIfEmpty(opts.Owner), parentID, i)
+		if err != nil {
+			return "", nil, err
+		}
+
+		childIDs = append(childIDs, childID)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", nil, err
+	}
+
+	return parentID, childIDs, nil
+}
+
+// GetBatchResult returns the per-item outcomes and counts of a batch job
+func (db *DB) GetBatchResult(ctx context.Context, parentID string) (*BatchResult, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT batch_index, id, COALESCE(input->>'query', ''), status, result, error
+		FROM jobs
+		WHERE parent_id = $1
+		ORDER BY batch_index
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batch := &BatchResult{Items: []BatchItem{}}
+	for rows.Next() {
+		var item BatchItem
+		if err := rows.Scan(&item.Index, &item.JobID, &item.Query, &item.Status, &item.Result, &item.Error); err != nil {
+			return nil, err
+		}
+
+		switch item.Status {
+		case "completed":
+			batch.Completed++
+		case "failed":
+			batch.Failed++
+		case "cancelled":
+			batch.Cancelled++
+		default:
+			batch.Pending++
+		}
+
+		batch.Items = append(batch.Items, item)
+	}
+	batch.Total = len(batch.Items)
+
+	return batch, rows.Err()
+}
+
+// FinishBatchItem is called after a job reaches a final state. If the job belongs to a
+// batch whose items are now all final, the batch is completed with the combined results
+// (or failed if no item succeeded). Returns the batch ID ("" if the job has none) and
+// the batch job if this call finished it, nil if it is still running or already finished.
+func (db *DB) FinishBatchItem(ctx context.Context, jobID string) (string, *Job, error) {
+	var parentID *string
+	err := db.pool.QueryRow(ctx, `
+		SELECT parent_id FROM jobs WHERE id = $1
+	`, jobID).Scan(&parentID)
+	if err != nil || parentID == nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil, nil
+		}
+		return "", nil, err
+	}
+
+	batch, err := db.GetBatchResult(ctx, *parentID)
+	if err != nil || batch.Pending > 0 {
+		return *parentID, nil, err
+	}
+
+	status := "completed"
+	var errMsg *string
+	if batch.Completed == 0 {
+		status = "failed"
+	}
+	if unsuccessful := batch.Failed + batch.Cancelled; unsuccessful > 0 {
+		msg := fmt.Sprintf("%d of %d items did not complete", unsuccessful, batch.Total)
+		errMsg = &msg
+	}
+
+	resultBytes, _ := json.Marshal(batch)
+
+	// Items never leave a final state, so only the first caller to see them all final wins
+	job, err := scanJob(db.pool.QueryRow(ctx, `
+		UPDATE jobs p
+		SET status = $2,
+		    result = $3,
+		    error = $4,
+		    updated_at = NOW()
+		WHERE p.id = $1
+		AND p.status = 'processing'
+		AND NOT EXISTS (
+			SELECT 1 FROM jobs c
+			WHERE c.parent_id = p.id
+			AND c.status NOT IN ('completed', 'failed', 'cancelled')
+		)
+		RETURNING `+jobColumns, *parentID, status, resultBytes, errMsg))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return *parentID, nil, nil
+	}
+	if err != nil {
+		return *parentID, nil, err
+	}
+
+	return *parentID, job, nil
+}
+
+// CancelledBatchItem is a batch item cancelled together with its batch
+type CancelledBatchItem struct {
+	JobID          string
+	PreviousStatus string
+}
+
+// CancelBatchItems cancels every unfinished item of a batch job and returns the cancelled
+// items with the status they had, so queued payloads and running workers can be cleaned up.
+func (db *DB) CancelBatchItems(ctx context.Context, parentID string, reason string) ([]CancelledBatchItem, error) {
+	rows, err := db.pool.Query(ctx, `
+		UPDATE jobs j
+		SET status = 'cancelled',
+		    cancel_reason = $2,
+		    visibility_timeout = NULL,
+		    worker_id = NULL,
+		    updated_at = NOW()
+		FROM (SELECT id, status FROM jobs WHERE parent_id = $1 FOR UPDATE) prev
+		WHERE j.id = prev.id
+		AND prev.status IN ('scheduled', 'queued', 'processing')
+		RETURNING j.id, prev.status
+	`, parentID, reason)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []CancelledBatchItem
+	for rows.Next() {
+		var item CancelledBatchItem
+		if err := rows.Scan(&item.JobID, &item.PreviousStatus); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
@@ -2,19 +2,25 @@ package database
 
 import (
 	"context"
-	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
 )
 
-var Pool *pgxpool.Pool
+// DB runs the queries of the API, the worker and the CLI against Postgres.
+// Connect opens it once at startup and it is passed to whatever needs it.
+type DB struct {
+	pool *pgxpool.Pool
+}
 
-func Connect() {
+// Connect opens the connection pool and runs the migrations. Exits on failure.
+func Connect(cfg config.Database) *DB {
 	log.Info().Msg("🔌 Starting database connection...")
 
-	dsn := os.Getenv("DATABASE_URL")
+	dsn := cfg.URL
 	if dsn == "" {
 		log.Fatal().Msg("❌ DATABASE_URL is not set")
 	}
@@ -38,8 +44,6 @@ func Connect() {
 
 	log.Info().Msg("✅ Database connection established")
 
-	Pool = pool
-
 	// Run migrations with separate, longer context
 	migrationCtx, migrationCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer migrationCancel()
@@ -152,5 +156,410 @@ func Connect() {
 		log.Fatal().Err(err).Msg("❌ Migration failed (visibility timeout index)")
 	}
 
+	log.Info().Msg("🔄 Adding cancellation support...")
+	// Allow the 'cancelled' status and record why a job was cancelled
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs DROP CONSTRAINT IF EXISTS jobs_status_check;
+		ALTER TABLE jobs ADD CONSTRAINT jobs_status_check
+			CHECK (status IN ('scheduled', 'queued', 'processing', 'completed', 'failed', 'cancelled'));
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (jobs status constraint)")
+	}
+
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (cancel_reason column)")
+	}
+
+	log.Info().Msg("🔄 Creating webhook_deliveries table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id BIGSERIAL PRIMARY KEY,
+			job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			attempt INTEGER NOT NULL,
+			status_code INTEGER,
+			error TEXT,
+			delivered BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (webhook_deliveries table)")
+	}
+
+	_, err = pool.Exec(migrationCtx, `
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_job_id ON webhook_deliveries(job_id);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (webhook_deliveries index)")
+	}
+
+	log.Info().Msg("🔄 Adding job listing columns and indexes...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS owner TEXT;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (owner column)")
+	}
+
+	_, err = pool.Exec(migrationCtx, `
+		CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs(created_at DESC, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_jobs_status_created_at ON jobs(status, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_jobs_type_created_at ON jobs(type, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_jobs_owner_created_at ON jobs(owner, created_at DESC)
+			WHERE owner IS NOT NULL;
+		CREATE INDEX IF NOT EXISTS idx_jobs_worker_id ON jobs(worker_id)
+			WHERE worker_id IS NOT NULL;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (job listing indexes)")
+	}
+
+	log.Info().Msg("🔄 Adding scheduling support...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS run_at TIMESTAMPTZ;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (run_at column)")
+	}
+
+	_, err = pool.Exec(migrationCtx, `
+		CREATE INDEX IF NOT EXISTS idx_jobs_scheduled_run_at ON jobs(run_at)
+		WHERE status = 'scheduled';
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (run_at index)")
+	}
+
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS job_schedules (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			cron TEXT NOT NULL,
+			type TEXT NOT NULL,
+			input JSONB NOT NULL,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			last_run_at TIMESTAMPTZ,
+			next_run_at TIMESTAMPTZ NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (job_schedules table)")
+	}
+
+	log.Info().Msg("🔄 Adding priority and queue columns...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS queue TEXT NOT NULL DEFAULT 'default';
+
+		ALTER TABLE job_schedules
+		ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS queue TEXT NOT NULL DEFAULT 'default';
+
+		CREATE INDEX IF NOT EXISTS idx_jobs_queue_status ON jobs(queue, status);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (priority and queue columns)")
+	}
+
+	log.Info().Msg("🔄 Creating job_events table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS job_events (
+			id BIGSERIAL PRIMARY KEY,
+			job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+			event TEXT NOT NULL,
+			worker_id TEXT,
+			attempt INTEGER,
+			error TEXT,
+			details JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_job_events_job_id ON job_events(job_id, id);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (job_events table)")
+	}
+
+	log.Info().Msg("🔄 Creating workers table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS workers (
+			id TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL,
+			version TEXT NOT NULL,
+			queues TEXT[] NOT NULL DEFAULT '{}',
+			started_at TIMESTAMPTZ NOT NULL,
+			last_heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			current_job_id UUID,
+			jobs_processed BIGINT NOT NULL DEFAULT 0,
+			jobs_failed BIGINT NOT NULL DEFAULT 0,
+			jobs_retried BIGINT NOT NULL DEFAULT 0
+		);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (workers table)")
+	}
+
+	log.Info().Msg("🔄 Adding lease columns...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS lease_token BIGINT NOT NULL DEFAULT 0;
+
+		ALTER TABLE workers
+		ADD COLUMN IF NOT EXISTS lease_conflicts BIGINT NOT NULL DEFAULT 0;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (lease columns)")
+	}
+
+	log.Info().Msg("🔄 Adding batch columns...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS parent_id UUID REFERENCES jobs(id) ON DELETE CASCADE,
+		ADD COLUMN IF NOT EXISTS batch_index INTEGER;
+
+		CREATE INDEX IF NOT EXISTS idx_jobs_parent_id ON jobs(parent_id, batch_index)
+		WHERE parent_id IS NOT NULL;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (batch columns)")
+	}
+
+	log.Info().Msg("🔄 Adding request_hash column...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE jobs
+		ADD COLUMN IF NOT EXISTS request_hash VARCHAR(64);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (request_hash column)")
+	}
+
+	log.Info().Msg("🔄 Creating idempotency_keys table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			scope TEXT NOT NULL,
+			idem_key TEXT NOT NULL,
+			request_hash VARCHAR(64) NOT NULL,
+			status_code INTEGER,
+			content_type TEXT,
+			response_body BYTEA,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (scope, idem_key)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (idempotency_keys table)")
+	}
+
+	// Identifies each reservation, so a request whose lease expired can't save
+	// over or release the reservation of the request that took the key next
+	log.Info().Msg("🔄 Adding idempotency reservation tokens...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE idempotency_keys
+		ADD COLUMN IF NOT EXISTS token TEXT;
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (idempotency_keys token)")
+	}
+
+	log.Info().Msg("🔄 Creating api_keys table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			owner TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash VARCHAR(64) NOT NULL UNIQUE,
+			admin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ,
+			expires_at TIMESTAMPTZ,
+			revoked_at TIMESTAMPTZ
+		);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (api_keys table)")
+	}
+
+	log.Info().Msg("🔄 Adding tenant ownership columns...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS owner TEXT;
+		ALTER TABLE note_embeddings ADD COLUMN IF NOT EXISTS owner TEXT;
+		ALTER TABLE job_schedules ADD COLUMN IF NOT EXISTS owner TEXT;
+
+		CREATE INDEX IF NOT EXISTS idx_notes_owner ON notes(owner, id DESC);
+		CREATE INDEX IF NOT EXISTS idx_note_embeddings_owner ON note_embeddings(owner);
+
+		-- Schedule names only need to be unique per tenant
+		ALTER TABLE job_schedules DROP CONSTRAINT IF EXISTS job_schedules_name_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_job_schedules_owner_name
+			ON job_schedules(COALESCE(owner, ''), name);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (tenant ownership columns)")
+	}
+
+	// Notes are only visible to the tenant set by withTenant. Superusers bypass
+	// row-level security, so queries filter by owner explicitly as well.
+	log.Info().Msg("🔄 Enabling row-level security on notes...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE notes ENABLE ROW LEVEL SECURITY;
+		ALTER TABLE notes FORCE ROW LEVEL SECURITY;
+		DROP POLICY IF EXISTS notes_tenant_isolation ON notes;
+		CREATE POLICY notes_tenant_isolation ON notes
+			USING (owner IS NOT DISTINCT FROM NULLIF(current_setting('app.tenant', true), ''))
+			WITH CHECK (owner IS NOT DISTINCT FROM NULLIF(current_setting('app.tenant', true), ''));
+
+		ALTER TABLE note_embeddings ENABLE ROW LEVEL SECURITY;
+		ALTER TABLE note_embeddings FORCE ROW LEVEL SECURITY;
+		DROP POLICY IF EXISTS note_embeddings_tenant_isolation ON note_embeddings;
+		CREATE POLICY note_embeddings_tenant_isolation ON note_embeddings
+			USING (owner IS NOT DISTINCT FROM NULLIF(current_setting('app.tenant', true), ''))
+			WITH CHECK (owner IS NOT DISTINCT FROM NULLIF(current_setting('app.tenant', true), ''));
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (row-level security)")
+	}
+
+	// Roles replace the admin flag: admin keys become admins, the rest editors
+	log.Info().Msg("🔄 Adding API key roles...")
+	_, err = pool.Exec(migrationCtx, `
+		ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'editor';
+
+		DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'api_keys' AND column_name = 'admin'
+			) THEN
+				UPDATE api_keys SET role = 'admin' WHERE admin;
+				ALTER TABLE api_keys DROP COLUMN admin;
+			END IF;
+		END $$;
+
+		ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_role_check;
+		ALTER TABLE api_keys ADD CONSTRAINT api_keys_role_check
+			CHECK (role IN ('viewer', 'editor', 'admin'));
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (api_key roles)")
+	}
+
+	log.Info().Msg("🔄 Creating tenant usage and quota tables...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS tenant_usage (
+			owner TEXT NOT NULL,
+			period_start DATE NOT NULL,
+			llm_tokens BIGINT NOT NULL DEFAULT 0,
+			embedding_tokens BIGINT NOT NULL DEFAULT 0,
+			llm_calls BIGINT NOT NULL DEFAULT 0,
+			embedding_calls BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (owner, period_start)
+		);
+
+		-- Per-tenant overrides of the QUOTA_* defaults; NULL keeps the default
+		CREATE TABLE IF NOT EXISTS tenant_quotas (
+			owner TEXT PRIMARY KEY,
+			llm_tokens BIGINT,
+			embedding_tokens BIGINT,
+			notes BIGINT
+		);
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (tenant usage tables)")
+	}
+
+	// Append-only: rows are never updated, only deleted by AUDIT_RETENTION pruning
+	log.Info().Msg("🔄 Creating audit_log table...")
+	_, err = pool.Exec(migrationCtx, `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			owner TEXT,
+			actor TEXT NOT NULL,
+			auth_method TEXT NOT NULL,
+			role TEXT,
+			action TEXT NOT NULL
+				CHECK (action IN ('create', 'update', 'delete', 'search', 'query', 'export')),
+			resource TEXT NOT NULL,
+			target_ids TEXT[] NOT NULL DEFAULT '{}',
+			request_id TEXT,
+			details JSONB,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_audit_log_owner ON audit_log(owner, id);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+		CREATE INDEX IF NOT EXISTS idx_audit_log_target_ids ON audit_log USING GIN (target_ids);
+
+		CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS audit_log_no_update ON audit_log;
+		CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+			FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (audit_log table)")
+	}
+
+	// Each run of a schedule gets one job, even if the scheduler tick runs twice.
+	// Duplicates created before the index existed keep their job under a renamed hash.
+	log.Info().Msg("🔄 Enforcing one job per schedule run...")
+	_, err = pool.Exec(migrationCtx, `
+		UPDATE jobs SET content_hash = content_hash || ':dup:' || id
+		WHERE content_hash LIKE 'schedule:%'
+		AND id NOT IN (
+			SELECT DISTINCT ON (content_hash) id FROM jobs
+			WHERE content_hash LIKE 'schedule:%'
+			ORDER BY content_hash, created_at
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_schedule_run
+			ON jobs(content_hash) WHERE content_hash LIKE 'schedule:%';
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (schedule run index)")
+	}
+
+	// Concurrent retries with the same Idempotency-Key must not both create a job.
+	// Live duplicates created before the index existed keep their job under a renamed hash.
+	log.Info().Msg("🔄 Enforcing one live job per idempotency key...")
+	_, err = pool.Exec(migrationCtx, `
+		UPDATE jobs SET content_hash = content_hash || ':dup:' || id
+		WHERE content_hash LIKE 'client:%'
+		AND status NOT IN ('failed', 'cancelled')
+		AND id NOT IN (
+			SELECT DISTINCT ON (content_hash) id FROM jobs
+			WHERE content_hash LIKE 'client:%'
+			AND status NOT IN ('failed', 'cancelled')
+			ORDER BY content_hash, created_at
+		);
+
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_client_key
+			ON jobs(content_hash)
+			WHERE content_hash LIKE 'client:%' AND status NOT IN ('failed', 'cancelled');
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("❌ Migration failed (idempotency key index)")
+	}
+
 	log.Info().Msg("✅ Database connected & migrations applied successfully")
+
+	return &DB{pool: pool}
 }
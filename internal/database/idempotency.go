@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// StoredResponse is a response saved for replay under an idempotency key
+type StoredResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// IdempotencyRecord is an existing, unexpired idempotency key
+type IdempotencyRecord struct {
+	RequestHash string
+
+	// Response is nil while the original request is still being handled
+	Response *StoredResponse
+}
+
+// ReserveIdempotencyKey claims key within scope for a request until lease passes.
+// If the key was free (or expired), it is now reserved for this request and the
+// returned token identifies the reservation for SaveIdempotentResponse and
+// ReleaseIdempotencyKey. Otherwise returns the existing record so the caller can
+// replay or reject it.
+func (db *DB) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, lease time.Duration) (string, *IdempotencyRecord, error) {
+	token := uuid.New().String()
+
+	var reserved bool
+	err := db.pool.QueryRow(ctx, `
+		INSERT INTO idempotency_keys (scope, idem_key, request_hash, token, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, idem_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    token = EXCLUDED.token,
+		    status_code = NULL,
+		    content_type = NULL,
+		    response_body = NULL,
+		    created_at = NOW(),
+		    expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		RETURNING TRUE
+	`, scope, key, requestHash, token, time.Now().Add(lease)).Scan(&reserved)
+	if err == nil {
+		return token, nil, nil
+	}
+	if err != pgx.ErrNoRows {
+		return "", nil, err
+	}
+
+	// The key is taken by a live request
+	var record IdempotencyRecord
+	var statusCode *int
+	var contentType *string
+	var body []byte
+	err = db.pool.QueryRow(ctx, `
+		SELECT request_hash, status_code, content_type, response_body
+		FROM idempotency_keys
+		WHERE scope = $1 AND idem_key = $2
+	`, scope, key).Scan(&record.RequestHash, &statusCode, &contentType, &body)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if statusCode != nil {
+		record.Response = &StoredResponse{StatusCode: *statusCode, Body: body}
+		if contentType != nil {
+			record.Response.ContentType = *contentType
+		}
+	}
+
+	return "", &record, nil
+}
+
+// SaveIdempotentResponse stores the response of the reservation identified by token
+// and keeps it for replay until ttl passes. Does nothing if the reservation was
+// lost, e.g. because its lease expired and another request took the key.
+func (db *DB) SaveIdempotentResponse(ctx context.Context, scope string, key string, token string, resp StoredResponse, ttl time.Duration) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $4,
+		    content_type = $5,
+		    response_body = $6,
+		    expires_at = $7
+		WHERE scope = $1 AND idem_key = $2
+		AND token = $3 AND status_code IS NULL
+	`, scope, key, token, resp.StatusCode, resp.ContentType, resp.Body, time.Now().Add(ttl))
+	return err
+}
+
+// ReleaseIdempotencyKey frees the reservation identified by token so a failed
+// request can be retried. Does nothing if another request has since taken the key.
+func (db *DB) ReleaseIdempotencyKey(ctx context.Context, scope string, key string, token string) error {
+	_, err := db.pool.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE scope = $1 AND idem_key = $2
+		AND token = $3 AND status_code IS NULL
+	`, scope, key, token)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes keys whose replay window has passed
+func (db *DB) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Job history event kinds recorded in job_events
+const (
+	EventEnqueued           = "enqueued"
+	EventScheduled          = "scheduled"
+	EventClaimed            = "claimed"
+	EventAttemptFailed      = "attempt_failed"
+	EventVisibilityExtended = "visibility_extended"
+	EventReclaimed          = "reclaimed"
+	EventCompleted          = "completed"
+	EventFailed             = "failed"
+	EventCancelled          = "cancelled"
+)
+
+// JobHistoryEvent is one row of a job's append-only history
+type JobHistoryEvent struct {
+	ID        int64           `json:"id"`
+	JobID     string          `json:"job_id"`
+	Event     string          `json:"event"`
+	WorkerID  *string         `json:"worker_id,omitempty"`
+	Attempt   *int            `json:"attempt,omitempty"`
+	Error     *string         `json:"error,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RecordJobEvent appends an event to a job's history. Zero-valued optional
+// fields are stored as NULL.
+func (db *DB) RecordJobEvent(ctx context.Context, jobID string, event string, workerID string, attempt int, errMsg string, details interface{}) error {
+	var detailsBytes []byte
+	if details != nil {
+		var err error
+		if detailsBytes, err = json.Marshal(details); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO job_events (job_id, event, worker_id, attempt, error, details)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, 0), NULLIF($5, ''), $6)
+	`, jobID, event, workerID, attempt, errMsg, detailsBytes)
+	return err
+}
+
+// GetJobHistory returns a job's events, oldest first
+func (db *DB) GetJobHistory(ctx context.Context, jobID string) ([]JobHistoryEvent, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, job_id, event, worker_id, attempt, error, details, created_at
+		FROM job_events
+		WHERE job_id = $1
+		ORDER BY id ASC
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []JobHistoryEvent{}
+	for rows.Next() {
+		var e JobHistoryEvent
+		if err := rows.Scan(
+			&e.ID,
+			&e.JobID,
+			&e.Event,
+			&e.WorkerID,
+			&e.Attempt,
+			&e.Error,
+			&e.Details,
+			&e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
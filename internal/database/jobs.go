@@ -3,7 +3,7 @@ package database
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,34 +20,169 @@ type Job struct {
 	Error             *string          `json:"error,omitempty"`
 	VisibilityTimeout *time.Time       `json:"visibility_timeout,omitempty"`
 	WorkerID          *string          `json:"worker_id,omitempty"`
+	CancelReason      *string          `json:"cancel_reason,omitempty"`
+	RetryCount        int              `json:"retry_count"`
+	Owner             *string          `json:"owner,omitempty"`
+	RunAt             *time.Time       `json:"run_at,omitempty"`
+	Priority          int              `json:"priority"`
+	Queue             string           `json:"queue"`
+	LeaseToken        int64            `json:"lease_token"`
+	ParentID          *string          `json:"parent_id,omitempty"`
+	BatchIndex        *int             `json:"batch_index,omitempty"`
+	RequestHash       *string          `json:"-"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+
+	// Batch is the live progress of an unfinished batch job, filled in by the API
+	Batch *BatchResult `json:"batch,omitempty"`
 }
 
-// Create new job in DB - all jobs must have a content hash
-func CreateJob(ctx context.Context, jobType string, input interface{}, contentHash string) (string, error) {
+// jobColumns is the column list scanned by scanJob
+const jobColumns = `id, type, input, status, result, error, visibility_timeout, worker_id,
+	cancel_reason, COALESCE(retry_count, 0), owner, run_at, priority, queue, lease_token, parent_id, batch_index,
+	request_hash, created_at, updated_at`
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.Type,
+		&job.Input,
+		&job.Status,
+		&job.Result,
+		&job.Error,
+		&job.VisibilityTimeout,
+		&job.WorkerID,
+		&job.CancelReason,
+		&job.RetryCount,
+		&job.Owner,
+		&job.RunAt,
+		&job.Priority,
+		&job.Queue,
+		&job.LeaseToken,
+		&job.ParentID,
+		&job.BatchIndex,
+		&job.RequestHash,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// IsTerminalStatus reports whether a job status is final
+func IsTerminalStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// JobOptions holds optional settings for CreateJob
+type JobOptions struct {
+	// RunAt delays the job: it is stored as 'scheduled' and only queued once due
+	RunAt *time.Time
+
+	// Queue names the Redis queue the job is pushed to (DefaultQueue if empty)
+	Queue string
+
+	// Priority orders jobs within a queue, higher first (MinPriority..MaxPriority)
+	Priority int
+
+	// Owner is the tenant the job belongs to (empty if unauthenticated)
+	Owner string
+
+	// RequestHash fingerprints every result-affecting request parameter, so an
+	// idempotency key reused with a different request can be detected
+	RequestHash string
+}
+
+// ErrDuplicateJob is returned when a job is created with a client idempotency key
+// that another job holds. A key is held until its job fails or is cancelled.
+var ErrDuplicateJob = errors.New("a job with this idempotency key already exists")
+
+// clientKeyConflict skips jobs whose client key is held, see idx_jobs_client_key
+const clientKeyConflict = `
+	ON CONFLICT (content_hash) WHERE content_hash LIKE 'client:%' AND status NOT IN ('failed', 'cancelled')
+	DO NOTHING`
+
+// nullIfEmpty stores empty optional strings as NULL
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Create new job in DB - all jobs must have a content hash.
+// Fails with ErrDuplicateJob if its client idempotency key is already held.
+func (db *DB) CreateJob(ctx context.Context, jobType string, input interface{}, contentHash string, opts JobOptions) (string, error) {
+	id, created, err := db.insertJob(ctx, jobType, input, contentHash, opts, clientKeyConflict)
+	if err != nil {
+		return "", err
+	}
+
+	if !created {
+		return "", ErrDuplicateJob
+	}
+	return id, nil
+}
+
+// CreateScheduleRunJob creates the job of one schedule run, identified by its
+// "schedule:<id>:<run>" content hash. A unique index makes this idempotent:
+// if the run already has a job, created is false and no job is inserted.
+func (db *DB) CreateScheduleRunJob(ctx context.Context, jobType string, input interface{}, runHash string, opts JobOptions) (id string, created bool, err error) {
+	return db.insertJob(ctx, jobType, input, runHash, opts,
+		`ON CONFLICT (content_hash) WHERE content_hash LIKE 'schedule:%' DO NOTHING`)
+}
+
+// insertJob inserts a job, applying onConflict (if any) to the INSERT.
+// Returns created=false if onConflict skipped the row.
+func (db *DB) insertJob(ctx context.Context, jobType string, input interface{}, contentHash string, opts JobOptions, onConflict string) (string, bool, error) {
 	id := uuid.New().String()
 
 	// Convert input payload to JSON
 	inputBytes, err := json.Marshal(input)
 	if err != nil {
-		return "", err
+		return "", false, err
+	}
+
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueue
+	}
+
+	status := "queued"
+	if opts.RunAt != nil && opts.RunAt.After(time.Now()) {
+		status = "scheduled"
 	}
 
 	// Insert into database with content_hash
-	_, err = Pool.Exec(ctx, `
-		INSERT INTO jobs (id, type, input, status, content_hash, retry_count)
-		VALUES ($1, $2, $3, 'queued', $4, 0)
-	`, id, jobType, inputBytes, contentHash)
+	tag, err := db.pool.Exec(ctx, `
+		INSERT INTO jobs (id, type, input, status, content_hash, retry_count, run_at, queue, priority,
+		                  owner, request_hash)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $7, $8, $9, $10)
+	`+onConflict, id, jobType, inputBytes, status, contentHash, opts.RunAt, opts.Queue, opts.Priority,
+		nullIfEmpty(opts.Owner), nullIfEmpty(opts.RequestHash))
 
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	return id, nil
+	if tag.RowsAffected() == 0 {
+		return "", false, nil
+	}
+	return id, true, nil
 }
 
 // Update status
-func UpdateJobStatus(ctx context.Context, id string, status string) error {
-	_, err := Pool.Exec(ctx, `
+func (db *DB) UpdateJobStatus(ctx context.Context, id string, status string) error {
+	_, err := db.pool.Exec(ctx, `
 		UPDATE jobs
 	    SET status = $1, updated_at = NOW()
 		WHERE id = $2
@@ -55,10 +190,10 @@ func UpdateJobStatus(ctx context.Context, id string, status string) error {
 	return err
 }
 
-// Update result
-func UpdateJobResult(ctx context.Context, id string, result interface{}) error {
+// Update result - only the current lease holder of the processing job may complete it
+func (db *DB) UpdateJobResult(ctx context.Context, lease JobLease, result interface{}) error {
 	resultBytes, _ := json.Marshal(result)
-	_, err := Pool.Exec(ctx, `
+	tag, err := db.pool.Exec(ctx, `
 		UPDATE jobs
 		SET status = 'completed',
 			result = $1,
@@ -66,66 +201,61 @@ func UpdateJobResult(ctx context.Context, id string, result interface{}) error {
 			worker_id = NULL,
 			updated_at = NOW()
 		WHERE id = $2
-	`, resultBytes, id)
-	return err
+		AND worker_id = $3
+		AND lease_token = $4
+		AND status = 'processing'
+	`, resultBytes, lease.JobID, lease.WorkerID, lease.Token)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return db.leaseConflict(ctx, lease)
+	}
+	return nil
 }
 
-// Update error
-func UpdateJobError(ctx context.Context, id string, errMsg string) error {
-	_, err := Pool.Exec(ctx, `
+// Update error - only the current lease holder of the processing job may fail it.
+// The failed attempt is counted in retry_count.
+func (db *DB) UpdateJobError(ctx context.Context, lease JobLease, errMsg string) error {
+	tag, err := db.pool.Exec(ctx, `
 		UPDATE jobs
 		SET status = 'failed',
 			error = $1,
+			retry_count = COALESCE(retry_count, 0) + 1,
 			visibility_timeout = NULL,
 			worker_id = NULL,
 			updated_at = NOW()
-		WHERE id = $2 
-	`, errMsg, id)
-	return err
-}
+		WHERE id = $2
+		AND worker_id = $3
+		AND lease_token = $4
+		AND status = 'processing'
+	`, errMsg, lease.JobID, lease.WorkerID, lease.Token)
+	if err != nil {
+		return err
+	}
 
-// Increment retry count for a job
-func IncrementRetryCount(ctx context.Context, jobID string) error {
-	_, err := Pool.Exec(ctx, `
-		UPDATE jobs
-		SET retry_count = retry_count + 1,
-		    updated_at = NOW()
-		WHERE id = $1
-	`, jobID)
-	return err
+	if tag.RowsAffected() == 0 {
+		return db.leaseConflict(ctx, lease)
+	}
+	return nil
 }
 
 // Fetch job by ID
-func GetJobByID(ctx context.Context, id string) (*Job, error) {
-	row := Pool.QueryRow(ctx, `
-		SELECT id, type, input, status, result, error, visibility_timeout, worker_id
+func (db *DB) GetJobByID(ctx context.Context, id string) (*Job, error) {
+	row := db.pool.QueryRow(ctx, `
+		SELECT `+jobColumns+`
 		FROM jobs
 		WHERE id = $1
 	`, id)
 
-	var job Job
-	err := row.Scan(
-		&job.ID,
-		&job.Type,
-		&job.Input,
-		&job.Status,
-		&job.Result,
-		&job.Error,
-		&job.VisibilityTimeout,
-		&job.WorkerID,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return &job, nil
+	return scanJob(row)
 }
 
 // GetJobStatus returns just the status of a job by ID
-func GetJobStatus(ctx context.Context, id string) (string, error) {
+func (db *DB) GetJobStatus(ctx context.Context, id string) (string, error) {
 	var status string
-	err := Pool.QueryRow(ctx, `
+	err := db.pool.QueryRow(ctx, `
 		SELECT status 
 		FROM jobs 
 		WHERE id = $1
@@ -141,62 +271,130 @@ func GetJobStatus(ctx context.Context, id string) (string, error) {
 // ClaimJobForProcessing atomically updates job status from 'queued' to 'processing'
 // with visibility timeout protection. Uses PostgreSQL's row-level locking to ensure
 // only one worker can claim each job. Sets a visibility timeout to allow reclaim if worker crashes.
-// Returns true if successfully claimed, false if already claimed by another worker
-func ClaimJobForProcessing(ctx context.Context, id string, workerID string, timeoutMinutes int) (bool, error) {
+// Every claim issues a new lease token, which all later writes by the worker must present.
+// Returns the lease if successfully claimed, nil if already claimed by another worker,
+// along with the number of previously failed attempts.
+func (db *DB) ClaimJobForProcessing(ctx context.Context, id string, workerID string, timeoutMinutes int) (*JobLease, int, error) {
 	visibilityTimeout := time.Now().Add(time.Duration(timeoutMinutes) * time.Minute)
 
-	result, err := Pool.Exec(ctx, `
+	lease := JobLease{JobID: id, WorkerID: workerID}
+	var retryCount int
+	err := db.pool.QueryRow(ctx, `
 		UPDATE jobs 
 		SET status = 'processing', 
 		    updated_at = NOW(),
 		    visibility_timeout = $2,
-		    worker_id = $3
+		    worker_id = $3,
+		    lease_token = lease_token + 1
 		WHERE id = $1 AND (
 		    status = 'queued' OR 
 		    (status = 'processing' AND visibility_timeout < NOW())
 		)
-	`, id, visibilityTimeout, workerID)
+		RETURNING lease_token, COALESCE(retry_count, 0)
+	`, id, visibilityTimeout, workerID).Scan(&lease.Token, &retryCount)
 
+	if err == pgx.ErrNoRows {
+		return nil, 0, nil
+	}
 	if err != nil {
-		return false, err
+		return nil, 0, err
 	}
 
-	rowsAffected := result.RowsAffected()
-	return rowsAffected == 1, nil
+	return &lease, retryCount, nil
 }
 
-// CheckRecentDuplicateJob looks for duplicate jobs within a time window
-func CheckRecentDuplicateJob(ctx context.Context, contentHash string, windowMinutes int) (*string, error) {
-	var existingJobID string
-	query := fmt.Sprintf(`
-		SELECT id FROM jobs
+// RescheduleJobRetry releases a failed job back to 'scheduled' so the scheduler
+// re-queues it at runAt, recording the error and counting the failed attempt.
+func (db *DB) RescheduleJobRetry(ctx context.Context, lease JobLease, runAt time.Time, errMsg string) error {
+	result, err := db.pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'scheduled',
+		    run_at = $3,
+		    error = $4,
+		    retry_count = COALESCE(retry_count, 0) + 1,
+		    visibility_timeout = NULL,
+		    worker_id = NULL,
+		    updated_at = NOW()
+		WHERE id = $1
+		AND worker_id = $2
+		AND lease_token = $5
+		AND status = 'processing'
+	`, lease.JobID, lease.WorkerID, runAt, errMsg, lease.Token)
+
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return db.leaseConflict(ctx, lease)
+	}
+
+	return nil
+}
+
+// CheckRecentDuplicateJob returns the newest job with the same content hash created
+// within window that hasn't failed or been cancelled, or nil if there is none.
+func (db *DB) CheckRecentDuplicateJob(ctx context.Context, contentHash string, window time.Duration) (*Job, error) {
+	job, err := scanJob(db.pool.QueryRow(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
 		WHERE content_hash = $1
-		AND created_at > NOW() - INTERVAL '%d minutes'
-		AND status IN ('queued', 'processing', 'completed')
+		AND created_at > $2
+		AND status IN ('scheduled', 'queued', 'processing', 'completed')
 		ORDER BY created_at DESC
 		LIMIT 1
-	`, windowMinutes)
-
-	err := Pool.QueryRow(ctx, query, contentHash).Scan(&existingJobID)
+	`, contentHash, time.Now().Add(-window)))
 
 	if err == pgx.ErrNoRows {
 		return nil, nil // No recent duplicate
 	}
-	return &existingJobID, err
+	return job, err
 }
 
-// ReclaimTimedOutJobs finds jobs that have exceeded their visibility timeout and resets them to 'queued'
-// This allows other workers to pick up jobs that were abandoned due to worker crashes
-func ReclaimTimedOutJobs(ctx context.Context) (int, error) {
-	result, err := Pool.Exec(ctx, `
-		UPDATE jobs
-		SET status = 'queued',
-		    visibility_timeout = NULL,
-		    worker_id = NULL,
-		    updated_at = NOW()
-		WHERE status = 'processing' 
-		AND visibility_timeout IS NOT NULL 
-		AND visibility_timeout < NOW()
+// GetLiveJobByContentHash returns the newest job with the content hash that hasn't
+// failed or been cancelled, however old, or nil if there is none
+func (db *DB) GetLiveJobByContentHash(ctx context.Context, contentHash string) (*Job, error) {
+	job, err := scanJob(db.pool.QueryRow(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE content_hash = $1
+		AND status IN ('scheduled', 'queued', 'processing', 'completed')
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, contentHash))
+
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return job, err
+}
+
+// ReclaimTimedOutJobs finds jobs that have exceeded their visibility timeout and reschedules them
+// to run immediately, so the scheduler pushes them back onto their queue.
+// This allows other workers to pick up jobs that were abandoned due to worker crashes.
+// A 'reclaimed' history event naming the previous worker is recorded for each job.
+func (db *DB) ReclaimTimedOutJobs(ctx context.Context) (int, error) {
+	result, err := db.pool.Exec(ctx, `
+		WITH previous AS (
+			SELECT id, worker_id
+			FROM jobs
+			WHERE status = 'processing'
+			AND visibility_timeout IS NOT NULL
+			AND visibility_timeout < NOW()
+			FOR UPDATE SKIP LOCKED
+		), reclaimed AS (
+			UPDATE jobs j
+			SET status = 'scheduled',
+			    run_at = NOW(),
+			    visibility_timeout = NULL,
+			    worker_id = NULL,
+			    updated_at = NOW()
+			FROM previous
+			WHERE j.id = previous.id
+			RETURNING j.id, previous.worker_id
+		)
+		INSERT INTO job_events (job_id, event, worker_id)
+		SELECT id, '`+EventReclaimed+`', worker_id FROM reclaimed
 	`)
 
 	if err != nil {
@@ -208,25 +406,144 @@ func ReclaimTimedOutJobs(ctx context.Context) (int, error) {
 
 // ExtendVisibilityTimeout allows a worker to extend the visibility timeout for a job it's processing
 // This prevents the job from being reclaimed while the worker is still actively processing it
-func ExtendVisibilityTimeout(ctx context.Context, jobID string, workerID string, additionalMinutes int) error {
+func (db *DB) ExtendVisibilityTimeout(ctx context.Context, lease JobLease, additionalMinutes int) error {
 	newTimeout := time.Now().Add(time.Duration(additionalMinutes) * time.Minute)
 
-	result, err := Pool.Exec(ctx, `
+	result, err := db.pool.Exec(ctx, `
 		UPDATE jobs
 		SET visibility_timeout = $1,
 		    updated_at = NOW()
 		WHERE id = $2 
 		AND worker_id = $3 
+		AND lease_token = $4
 		AND status = 'processing'
-	`, newTimeout, jobID, workerID)
+	`, newTimeout, lease.JobID, lease.WorkerID, lease.Token)
 
 	if err != nil {
 		return err
 	}
 
 	if result.RowsAffected() == 0 {
-		return fmt.Errorf("job not found or not owned by worker %s", workerID)
+		return db.leaseConflict(ctx, lease)
 	}
 
 	return nil
 }
+
+// CancelJob marks a scheduled, queued or processing job as cancelled and records the reason.
+// Returns the status the job had before it was cancelled, or pgx.ErrNoRows if the
+// job does not exist or has already reached a final state.
+func (db *DB) CancelJob(ctx context.Context, id string, reason string) (string, error) {
+	var previousStatus string
+	err := db.pool.QueryRow(ctx, `
+		UPDATE jobs j
+		SET status = 'cancelled',
+		    cancel_reason = $2,
+		    visibility_timeout = NULL,
+		    worker_id = NULL,
+		    updated_at = NOW()
+		FROM (SELECT id, status FROM jobs WHERE id = $1 FOR UPDATE) prev
+		WHERE j.id = prev.id
+		AND prev.status IN ('scheduled', 'queued', 'processing')
+		RETURNING prev.status
+	`, id, reason).Scan(&previousStatus)
+
+	if err != nil {
+		return "", err
+	}
+
+	return previousStatus, nil
+}
+
+// PromoteDueScheduledJobs moves scheduled jobs whose run_at has passed to 'queued'.
+// Each job is locked, handed to enqueue (which pushes it onto the queue) and only
+// marked 'queued' if that succeeded, in one transaction per job. A job whose
+// enqueue fails stays 'scheduled' and is retried on the next call; a worker that
+// pops it before the commit waits on the row lock in ClaimJobForProcessing.
+// Returns the promoted jobs; enqueue errors are left to the callback to report.
+func (db *DB) PromoteDueScheduledJobs(ctx context.Context, enqueue func(Job) error) ([]Job, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id FROM jobs
+		WHERE status = 'scheduled'
+		AND run_at <= NOW()
+		ORDER BY run_at ASC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, err
+	}
+
+	var promoted []Job
+	for _, id := range ids {
+		job, err := db.promoteScheduledJob(ctx, id, enqueue)
+		if err != nil {
+			return promoted, err
+		}
+		if job != nil {
+			promoted = append(promoted, *job)
+		}
+	}
+
+	return promoted, nil
+}
+
+// DeferQueuedJob moves a queued job that could not be pushed onto the queue back
+// to 'scheduled', due now, so PromoteDueScheduledJobs retries the push
+func (db *DB) DeferQueuedJob(ctx context.Context, id string) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'scheduled',
+		    run_at = NOW(),
+		    updated_at = NOW()
+		WHERE id = $1
+		AND status = 'queued'
+	`, id)
+	return err
+}
+
+// promoteScheduledJob queues one due job. Returns nil if it is no longer due or
+// scheduled, or if enqueue failed.
+func (db *DB) promoteScheduledJob(ctx context.Context, id string, enqueue func(Job) error) (*Job, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	job, err := scanJob(tx.QueryRow(ctx, `
+		SELECT `+jobColumns+` FROM jobs
+		WHERE id = $1
+		AND status = 'scheduled'
+		AND run_at <= NOW()
+		FOR UPDATE SKIP LOCKED
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil // cancelled, or promoted by someone else meanwhile
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := enqueue(*job); err != nil {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE jobs
+		SET status = 'queued',
+		    updated_at = NOW()
+		WHERE id = $1
+	`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	job.Status = "queued"
+	return job, nil
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobFilter selects jobs for ListJobs and CountJobsByStatus. Zero values are
+// ignored, except Owner: an empty Owner selects the jobs without an owner.
+type JobFilter struct {
+	Statuses      []string
+	Type          string
+	Queue         string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	WorkerID      string
+	Owner         string
+	ParentID      string
+	Cursor        *JobCursor
+	Limit         int
+}
+
+// JobCursor marks the position after which the next page starts.
+// Jobs are ordered newest first by (created_at, id).
+type JobCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        string    `json:"id"`
+}
+
+// EncodeJobCursor returns an opaque cursor pointing just after the given job
+func EncodeJobCursor(job Job) string {
+	data, _ := json.Marshal(JobCursor{CreatedAt: job.CreatedAt, ID: job.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeJobCursor parses a cursor produced by EncodeJobCursor
+func DecodeJobCursor(raw string) (*JobCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var cursor JobCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+// where builds the WHERE clause and its arguments. Status and cursor
+// conditions are skipped when counting so every status bucket is reported.
+func (f JobFilter) where(forCount bool) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	if len(f.Statuses) > 0 && !forCount {
+		add("status = ANY($%d)", f.Statuses)
+	}
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.Queue != "" {
+		add("queue = $%d", f.Queue)
+	}
+	if f.CreatedAfter != nil {
+		add("created_at >= $%d", *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		add("created_at < $%d", *f.CreatedBefore)
+	}
+	if f.WorkerID != "" {
+		add("worker_id = $%d", f.WorkerID)
+	}
+	add("owner IS NOT DISTINCT FROM $%d", nullIfEmpty(f.Owner))
+	if f.ParentID != "" {
+		add("parent_id = $%d", f.ParentID)
+	}
+	if f.Cursor != nil && !forCount {
+		args = append(args, f.Cursor.CreatedAt, f.Cursor.ID)
+		conds = append(conds, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	if len(conds) == 0 {
+		return "", args
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// ListJobs returns up to f.Limit jobs matching the filter, newest first
+func (db *DB) ListJobs(ctx context.Context, f JobFilter) ([]Job, error) {
+	where, args := f.where(false)
+	args = append(args, f.Limit)
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		`+where+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $`+fmt.Sprint(len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// CountJobsByStatus returns the number of jobs per status matching the filter
+func (db *DB) CountJobsByStatus(ctx context.Context, f JobFilter) (map[string]int, error) {
+	where, args := f.where(true)
+
+	rows, err := db.pool.Query(ctx, `
+		SELECT status, COUNT(*)
+		FROM jobs
+		`+where+`
+		GROUP BY status
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrJobNotOwned is returned when a worker tries to change a job it no longer owns:
+// it was reclaimed by another worker, cancelled, or already finished.
+// Use errors.As with *LeaseConflictError for the job's current state.
+var ErrJobNotOwned = errors.New("job not found or not owned by this worker")
+
+// JobLease identifies one claim of a job. The token is incremented on every claim,
+// so a worker that lost the job can't write to it even if it claims it again later.
+type JobLease struct {
+	JobID    string
+	WorkerID string
+	Token    int64
+}
+
+// LeaseConflictError reports a fenced write rejected because the lease is stale
+type LeaseConflictError struct {
+	Lease JobLease
+
+	// Current state of the job, empty if it no longer exists
+	Status        string
+	OwnerWorkerID string
+	OwnerToken    int64
+}
+
+func (e *LeaseConflictError) Error() string {
+	if e.Status == "" {
+		return fmt.Sprintf("lease conflict on job %s: job not found", e.Lease.JobID)
+	}
+
+	if e.Status == "processing" {
+		return fmt.Sprintf("lease conflict on job %s: lease %d of worker %s superseded by lease %d of worker %s",
+			e.Lease.JobID, e.Lease.Token, e.Lease.WorkerID, e.OwnerToken, e.OwnerWorkerID)
+	}
+
+	return fmt.Sprintf("lease conflict on job %s: lease %d of worker %s, job is %s",
+		e.Lease.JobID, e.Lease.Token, e.Lease.WorkerID, e.Status)
+}
+
+// Is makes errors.Is(err, ErrJobNotOwned) match lease conflicts
+func (e *LeaseConflictError) Is(target error) bool {
+	return target == ErrJobNotOwned
+}
+
+// leaseConflict describes why a fenced write by lease matched no row
+func (db *DB) leaseConflict(ctx context.Context, lease JobLease) error {
+	conflict := &LeaseConflictError{Lease: lease}
+
+	var ownerWorkerID *string
+	err := db.pool.QueryRow(ctx, `
+		SELECT status, worker_id, lease_token
+		FROM jobs
+		WHERE id = $1
+	`, lease.JobID).Scan(&conflict.Status, &ownerWorkerID, &conflict.OwnerToken)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if ownerWorkerID != nil {
+		conflict.OwnerWorkerID = *ownerWorkerID
+	}
+
+	return conflict
+}
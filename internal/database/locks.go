@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Advisory lock keys used for leader election between workers
+const (
+	SchedulerLockKey int64 = 720_031
+)
+
+// TryAdvisoryLock attempts to take a session-level Postgres advisory lock on a
+// dedicated connection. If acquired, the returned release func must be called to
+// unlock and return the connection to the pool. ok is false if another session holds it.
+func (db *DB) TryAdvisoryLock(ctx context.Context, key int64) (release func(), ok bool, err error) {
+	conn, err := db.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, false, err
+	}
+
+	if !ok {
+		conn.Release()
+		return nil, false, nil
+	}
+
+	release = func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, key); err != nil {
+			log.Warn().Err(err).Int64("lock_key", key).Msg("failed to release advisory lock")
+		}
+		conn.Release()
+	}
+
+	return release, true, nil
+}
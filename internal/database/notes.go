@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Note represents a single note record.
+type Note struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NoteMatch is a note found by similarity search, closest first
+type NoteMatch struct {
+	ID        int       `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Distance  float64   `json:"distance"`
+}
+
+// ListNotes returns every note of a tenant, newest first
+func (db *DB) ListNotes(ctx context.Context, owner string) ([]Note, error) {
+	var notes []Note
+	err := db.withTenant(ctx, owner, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx,
+			`SELECT id, title, content, created_at FROM notes
+			 WHERE owner IS NOT DISTINCT FROM $1
+			 ORDER BY id DESC`, NullOwner(owner))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var n Note
+			if err := rows.Scan(&n.ID, &n.Title, &n.Content, &n.CreatedAt); err != nil {
+				return err
+			}
+			notes = append(notes, n)
+		}
+
+		return rows.Err()
+	})
+	return notes, err
+}
+
+// CreateNote inserts a note and its embedding together, owned by the tenant
+func (db *DB) CreateNote(ctx context.Context, owner string, title string, content string, embedding []float32) (*Note, error) {
+	n := Note{Title: title, Content: content}
+	err := db.withTenant(ctx, owner, func(tx pgx.Tx) error {
+		err := tx.QueryRow(ctx, `
+			INSERT INTO notes (title, content, owner)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`, title, content, NullOwner(owner)).Scan(&n.ID, &n.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO note_embeddings (note_id, embedding, owner)
+			 VALUES ($1, $2::vector, $3)`,
+			n.ID, vectorLiteral(embedding), NullOwner(owner))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// UpdateNoteEmbedding replaces the embedding of one of the tenant's notes
+func (db *DB) UpdateNoteEmbedding(ctx context.Context, owner string, noteID int, embedding []float32) error {
+	return db.withTenant(ctx, owner, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			UPDATE note_embeddings SET embedding = $1::vector
+			WHERE note_id = $2 AND owner IS NOT DISTINCT FROM $3
+		`, vectorLiteral(embedding), noteID, NullOwner(owner))
+		return err
+	})
+}
+
+// SearchNotes returns the tenant's limit notes closest to embedding (<-> distance)
+func (db *DB) SearchNotes(ctx context.Context, owner string, embedding []float32, limit int) ([]NoteMatch, error) {
+	var matches []NoteMatch
+	err := db.withTenant(ctx, owner, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT n.id, n.title, n.content, n.created_at,
+			       e.embedding <-> $1::vector AS distance
+			FROM notes n
+			JOIN note_embeddings e ON n.id = e.note_id
+			WHERE n.owner IS NOT DISTINCT FROM $2
+			ORDER BY distance ASC
+			LIMIT $3
+		`, vectorLiteral(embedding), NullOwner(owner), limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var m NoteMatch
+			if err := rows.Scan(&m.ID, &m.Title, &m.Content, &m.CreatedAt, &m.Distance); err != nil {
+				return err
+			}
+			matches = append(matches, m)
+		}
+
+		return rows.Err()
+	})
+	return matches, err
+}
+
+// vectorLiteral formats an embedding as a pgvector literal, e.g. "[0.1,0.2,0.3]"
+func vectorLiteral(vec []float32) string {
+	builder := strings.Builder{}
+	builder.WriteString("[")
+	for i, v := range vec {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString(fmt.Sprintf("%f", v))
+	}
+	builder.WriteString("]")
+
+	return builder.String()
+}
@@ -0,0 +1,333 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Redis keys shared by the API and the worker
+const (
+	// LegacyJobQueueKey is the single list used before named queues existed
+	LegacyJobQueueKey = "jobs:queue"
+	JobQueuesSetKey   = "jobs:queues"
+	JobCancelChannel  = "jobs:cancel"
+
+	// JobPayloadsKey maps the ID of each waiting job to its queued payload,
+	// so a cancelled job can be removed from its queue without scanning it
+	JobPayloadsKey = "jobs:payloads"
+)
+
+// DefaultQueue is used when a job doesn't name a queue
+const DefaultQueue = "default"
+
+// Priority bounds; higher priority jobs are popped first within a queue
+const (
+	MinPriority = -100
+	MaxPriority = 100
+)
+
+// QueueKey returns the Redis sorted set holding payloads for a named queue
+func QueueKey(queue string) string {
+	return "jobs:queue:" + queue
+}
+
+// queueScore orders a queue by priority (highest first), then FIFO by enqueue time.
+// Scores stay well within float64's exact integer range.
+func queueScore(priority int, enqueuedAt time.Time) float64 {
+	return float64(-priority)*1e13 + float64(enqueuedAt.UnixMilli())
+}
+
+// JobEventsChannel is the pub/sub channel carrying status transitions for one job
+func JobEventsChannel(jobID string) string {
+	return "jobs:events:" + jobID
+}
+
+// JobEvent is published whenever a job changes status
+// (queued → processing → retrying → completed/failed/cancelled).
+type JobEvent struct {
+	JobID     string    `json:"job_id"`
+	Status    string    `json:"status"`
+	Attempt   int       `json:"attempt,omitempty"`
+	WorkerID  string    `json:"worker_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// JobCancellation is published on JobCancelChannel when a running job is cancelled
+type JobCancellation struct {
+	JobID  string `json:"job_id"`
+	Reason string `json:"reason"`
+}
+
+// QueuedJob is a job payload waiting in a queue
+type QueuedJob struct {
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Input json.RawMessage `json:"input"`
+	Queue string          `json:"queue"`
+	Owner string          `json:"owner"` // Tenant the job runs as; empty if unauthenticated
+}
+
+// EnqueueJob adds a job payload to its named Redis queue for workers to pick up.
+// The owner travels with the payload so the worker runs the job as that tenant.
+func (r *Redis) EnqueueJob(ctx context.Context, jobID string, jobType string, input interface{}, queue string, priority int, owner string) error {
+	if queue == "" {
+		queue = DefaultQueue
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":    jobID,
+		"type":  jobType,
+		"input": input,
+		"queue": queue,
+		"owner": owner,
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.SAdd(ctx, JobQueuesSetKey, queue)
+	pipe.ZAdd(ctx, QueueKey(queue), redis.Z{
+		Score:  queueScore(priority, time.Now()),
+		Member: payload,
+	})
+	pipe.HSet(ctx, JobPayloadsKey, jobID, payload)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DequeueJob waits up to timeout for a job, taking the highest priority payload
+// of the first non-empty queue in the given order. Returns nil if none arrived.
+func (r *Redis) DequeueJob(ctx context.Context, queues []string, timeout time.Duration) (*QueuedJob, error) {
+	keys := make([]string, 0, len(queues))
+	for _, queue := range queues {
+		keys = append(keys, QueueKey(queue))
+	}
+
+	result, err := r.client.BZPopMin(ctx, timeout, keys...).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	raw, _ := result.Member.(string)
+
+	var job QueuedJob
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		return nil, fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	// Only drop the entry if it is still this payload, not one from a later enqueue
+	if err := forgetPayloadScript.Run(ctx, r.client, []string{JobPayloadsKey}, job.ID, raw).Err(); err != nil {
+		log.Warn().Err(err).Str("job_id", job.ID).Msg("Failed to forget dequeued job payload")
+	}
+
+	return &job, nil
+}
+
+// forgetPayloadScript deletes a job's JobPayloadsKey entry if it holds the given payload
+var forgetPayloadScript = redis.NewScript(`
+	if redis.call('HGET', KEYS[1], ARGV[1]) == ARGV[2] then
+		return redis.call('HDEL', KEYS[1], ARGV[1])
+	end
+	return 0
+`)
+
+// KnownQueues returns every queue name that has ever received a job
+func (r *Redis) KnownQueues(ctx context.Context) ([]string, error) {
+	return r.client.SMembers(ctx, JobQueuesSetKey).Result()
+}
+
+// QueueDepths returns the number of payloads waiting in each known queue
+func (r *Redis) QueueDepths(ctx context.Context) (map[string]int64, error) {
+	queues, err := r.KnownQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	depths := make(map[string]int64, len(queues))
+	for _, queue := range queues {
+		n, err := r.client.ZCard(ctx, QueueKey(queue)).Result()
+		if err != nil {
+			return nil, err
+		}
+		depths[queue] = n
+	}
+
+	return depths, nil
+}
+
+// MigrateLegacyQueue moves payloads left in the pre-named-queues list onto the
+// default queue. Returns the number of payloads moved.
+func (r *Redis) MigrateLegacyQueue(ctx context.Context) (int, error) {
+	moved := 0
+	for {
+		raw, err := r.client.LPop(ctx, LegacyJobQueueKey).Result()
+		if err == redis.Nil {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		var payload struct {
+			ID    string          `json:"id"`
+			Type  string          `json:"type"`
+			Input json.RawMessage `json:"input"`
+		}
+		if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+			continue
+		}
+
+		if err := r.EnqueueJob(ctx, payload.ID, payload.Type, payload.Input, DefaultQueue, 0, ""); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+}
+
+// RemoveQueuedJob deletes the waiting payload of the given job from its queue.
+// Returns the number of payloads removed: 0 if a worker already popped it.
+// Payloads enqueued before JobPayloadsKey existed are not found; workers skip
+// them because the job is no longer queued.
+func (r *Redis) RemoveQueuedJob(ctx context.Context, jobID string) (int, error) {
+	raw, err := r.client.HGet(ctx, JobPayloadsKey, jobID).Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var payload struct {
+		Queue string `json:"queue"`
+	}
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return 0, fmt.Errorf("invalid job payload: %w", err)
+	}
+
+	pipe := r.client.TxPipeline()
+	removed := pipe.ZRem(ctx, QueueKey(payload.Queue), raw)
+	pipe.HDel(ctx, JobPayloadsKey, jobID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return int(removed.Val()), nil
+}
+
+// PublishJobCancellation notifies workers that a job should stop processing
+func (r *Redis) PublishJobCancellation(ctx context.Context, jobID string, reason string) error {
+	msg, err := json.Marshal(JobCancellation{JobID: jobID, Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	return r.client.Publish(ctx, JobCancelChannel, msg).Err()
+}
+
+// PublishJobEvent broadcasts a status transition to listeners of the job's channel
+func (r *Redis) PublishJobEvent(ctx context.Context, event JobEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	msg, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return r.client.Publish(ctx, JobEventsChannel(event.JobID), msg).Err()
+}
+
+// SubscribeJobEvents subscribes to a job's status transitions and waits until the
+// subscription is active, so no event published afterwards can be missed.
+// Events arrive on the returned channel until unsubscribe is called.
+func (r *Redis) SubscribeJobEvents(ctx context.Context, jobID string) (<-chan JobEvent, func(), error) {
+	sub, err := r.subscribe(ctx, JobEventsChannel(jobID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan JobEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		for msg := range sub.Channel() {
+			var event JobEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return events, unsubscribe(sub, done), nil
+}
+
+// SubscribeJobCancellations receives the cancellations published by the API until
+// unsubscribe is called
+func (r *Redis) SubscribeJobCancellations(ctx context.Context) (<-chan JobCancellation, func(), error) {
+	sub, err := r.subscribe(ctx, JobCancelChannel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cancellations := make(chan JobCancellation)
+	done := make(chan struct{})
+	go func() {
+		defer close(cancellations)
+		for msg := range sub.Channel() {
+			var cancellation JobCancellation
+			if err := json.Unmarshal([]byte(msg.Payload), &cancellation); err != nil {
+				log.Warn().Err(err).Msg("Invalid cancellation message")
+				continue
+			}
+
+			select {
+			case cancellations <- cancellation:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return cancellations, unsubscribe(sub, done), nil
+}
+
+// unsubscribe returns the function that stops a subscription's relay goroutine
+// and closes it. Calling it more than once is safe.
+func unsubscribe(sub *redis.PubSub, done chan struct{}) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			sub.Close()
+		})
+	}
+}
+
+// subscribe subscribes to a pub/sub channel and waits until the subscription is active
+func (r *Redis) subscribe(ctx context.Context, channel string) (*redis.PubSub, error) {
+	sub := r.client.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	return sub, nil
+}
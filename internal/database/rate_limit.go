@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitKey returns the Redis counter for a caller's current rate limit window
+func RateLimitKey(subject string, windowStart time.Time) string {
+	return "ratelimit:" + subject + ":" + windowStart.UTC().Format("20060102T150405")
+}
+
+// takeRateLimitScript adds cost to a window's counter unless that would exceed the
+// limit, so rejected requests don't eat into the budget. Returns {allowed, used}.
+var takeRateLimitScript = redis.NewScript(`
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+local cost = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+if used + cost > limit then
+	return {0, used}
+end
+used = redis.call('INCRBY', KEYS[1], cost)
+if used == cost then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return {1, used}
+`)
+
+// TakeRateLimit spends cost from a caller's budget of limit per window in Redis, shared
+// by every API instance. Returns whether the request is allowed and the budget used.
+func (r *Redis) TakeRateLimit(ctx context.Context, subject string, cost int, limit int, window time.Duration) (bool, int, error) {
+	windowStart := time.Now().Truncate(window)
+
+	res, err := takeRateLimitScript.Run(ctx, r.client,
+		[]string{RateLimitKey(subject, windowStart)},
+		cost, limit, window.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return res[0] == 1, int(res[1]), nil
+}
@@ -2,23 +2,29 @@ package database
 
 import (
 	"context"
-	"os"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
 )
 
-var RedisClient *redis.Client
+// Redis holds the job queues, job status pub/sub and rate limit counters
+type Redis struct {
+	client *redis.Client
+}
 
-func InitRedis() {
-	addr := os.Getenv("REDIS_ADDR")
+// InitRedis connects to Redis. Returns nil when REDIS_ADDR is not set or Redis is
+// unreachable, which disables async jobs.
+func InitRedis(cfg config.Redis) *Redis {
+	addr := cfg.Addr
 	if addr == "" {
 		log.Warn().Msg("REDIS_ADDR not set, skipping Redis init")
-		return
+		return nil
 	}
 
-	RedisClient = redis.NewClient(&redis.Options{
+	client := redis.NewClient(&redis.Options{
 		Addr: addr,
 		DB:   0,
 	})
@@ -26,10 +32,12 @@ func InitRedis() {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	if _, err := RedisClient.Ping(ctx).Result(); err != nil {
+	if _, err := client.Ping(ctx).Result(); err != nil {
 		log.Warn().
 			Err(err).
 			Msg("Redis unavailable, async jobs disabled")
-		RedisClient = nil
+		return nil
 	}
+
+	return &Redis{client: client}
 }
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// RetentionRule deletes jobs in Status (optionally only of Type) whose last
+// update is older than MaxAge. ExcludeTypes lets a generic rule skip types
+// that have a more specific rule for the same status. A rule with a Tenant
+// only matches that tenant's jobs ("" is the unauthenticated tenant).
+type RetentionRule struct {
+	Status       string
+	Type         string
+	MaxAge       time.Duration
+	ExcludeTypes []string
+	Tenant       *string
+}
+
+// ParseRetentionAge accepts Go durations plus a "d" suffix for days
+func ParseRetentionAge(raw string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(raw, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid age %q", raw)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	age, err := time.ParseDuration(raw)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("invalid age %q", raw)
+	}
+	return age, nil
+}
+
+// retentionWhere is shared by CountPrunableJobs and PruneJobs.
+// Only final statuses are ever eligible for pruning. Batch items are never
+// matched themselves; they are pruned together with their batch job.
+const retentionWhere = `
+	WHERE status = $1
+	AND parent_id IS NULL
+	AND status IN ('completed', 'failed', 'cancelled')
+	AND ($2 = '' OR type = $2)
+	AND NOT (type = ANY($3))
+	AND updated_at < $4
+	AND (NOT $5 OR owner IS NOT DISTINCT FROM $6)
+`
+
+// CountPrunableJobs returns how many jobs the rule would delete
+func (db *DB) CountPrunableJobs(ctx context.Context, rule RetentionRule) (int, error) {
+	var count int
+	err := db.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM jobs
+	`+retentionWhere,
+		retentionArgs(rule)...,
+	).Scan(&count)
+	return count, err
+}
+
+// PruneJobs deletes up to batchSize jobs matching the rule, plus the items of
+// any batch job among them, and returns how many rule matches were deleted.
+// Call repeatedly until fewer than batchSize come back.
+//
+// The rows are locked and passed to archive before they are deleted, in one
+// transaction: if archive fails nothing is deleted. archive may be nil.
+func (db *DB) PruneJobs(ctx context.Context, rule RetentionRule, batchSize int, archive func([]Job) error) (int, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	matched, err := queryJobs(ctx, tx, `
+		SELECT `+jobColumns+` FROM jobs
+		`+retentionWhere+`
+		ORDER BY updated_at ASC
+		LIMIT $7
+		FOR UPDATE SKIP LOCKED`,
+		append(retentionArgs(rule), batchSize)...,
+	)
+	if err != nil || len(matched) == 0 {
+		return 0, err
+	}
+
+	ids := make([]string, 0, len(matched))
+	for _, job := range matched {
+		ids = append(ids, job.ID)
+	}
+
+	// Items would otherwise disappear through ON DELETE CASCADE without being archived
+	items, err := queryJobs(ctx, tx, `
+		SELECT `+jobColumns+` FROM jobs
+		WHERE parent_id = ANY($1)
+		ORDER BY parent_id, batch_index
+		FOR UPDATE`, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if archive != nil {
+		if err := archive(append(matched, items...)); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM jobs WHERE id = ANY($1) OR parent_id = ANY($1)
+	`, ids); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(matched), nil
+}
+
+// queryJobs returns the jobs selected with jobColumns
+func queryJobs(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) ([]Job, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// retentionArgs returns the retentionWhere parameters of a rule
+func retentionArgs(rule RetentionRule) []interface{} {
+	tenant := ""
+	if rule.Tenant != nil {
+		tenant = *rule.Tenant
+	}
+
+	return []interface{}{
+		rule.Status, rule.Type, excludeTypes(rule), time.Now().Add(-rule.MaxAge),
+		rule.Tenant != nil, nullIfEmpty(tenant),
+	}
+}
+
+// excludeTypes never returns nil so the ANY($3) comparison gets an empty array
+func excludeTypes(rule RetentionRule) []string {
+	if rule.ExcludeTypes == nil {
+		return []string{}
+	}
+	return rule.ExcludeTypes
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// JobSchedule is a recurring job definition evaluated by the worker scheduler
+type JobSchedule struct {
+	ID        int             `json:"id"`
+	Name      string          `json:"name"`
+	Cron      string          `json:"cron"`
+	Type      string          `json:"type"`
+	Input     json.RawMessage `json:"input"`
+	Enabled   bool            `json:"enabled"`
+	Queue     string          `json:"queue"`
+	Priority  int             `json:"priority"`
+	Owner     *string         `json:"owner,omitempty"`
+	LastRunAt *time.Time      `json:"last_run_at,omitempty"`
+	NextRunAt time.Time       `json:"next_run_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const scheduleColumns = `id, name, cron, type, input, enabled, queue, priority,
+	owner, last_run_at, next_run_at, created_at`
+
+func (db *DB) scanSchedules(ctx context.Context, sql string, args ...interface{}) ([]JobSchedule, error) {
+	rows, err := db.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []JobSchedule{}
+	for rows.Next() {
+		var s JobSchedule
+		if err := rows.Scan(
+			&s.ID,
+			&s.Name,
+			&s.Cron,
+			&s.Type,
+			&s.Input,
+			&s.Enabled,
+			&s.Queue,
+			&s.Priority,
+			&s.Owner,
+			&s.LastRunAt,
+			&s.NextRunAt,
+			&s.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, rows.Err()
+}
+
+// CreateSchedule stores a new recurring job schedule
+func (db *DB) CreateSchedule(ctx context.Context, s JobSchedule) (*JobSchedule, error) {
+	schedules, err := db.scanSchedules(ctx, `
+		INSERT INTO job_schedules (name, cron, type, input, enabled, queue, priority, owner, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING `+scheduleColumns,
+		s.Name, s.Cron, s.Type, s.Input, s.Enabled, s.Queue, s.Priority, s.Owner, s.NextRunAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schedules[0], nil
+}
+
+// ListSchedules returns a tenant's schedules ordered by name
+func (db *DB) ListSchedules(ctx context.Context, owner string) ([]JobSchedule, error) {
+	return db.scanSchedules(ctx, `
+		SELECT `+scheduleColumns+`
+		FROM job_schedules
+		WHERE owner IS NOT DISTINCT FROM $1
+		ORDER BY name ASC
+	`, nullIfEmpty(owner))
+}
+
+// DeleteSchedule removes a tenant's schedule. Returns false if it did not exist.
+func (db *DB) DeleteSchedule(ctx context.Context, id int, owner string) (bool, error) {
+	result, err := db.pool.Exec(ctx, `
+		DELETE FROM job_schedules
+		WHERE id = $1
+		AND owner IS NOT DISTINCT FROM $2
+	`, id, nullIfEmpty(owner))
+	if err != nil {
+		return false, err
+	}
+
+	return result.RowsAffected() == 1, nil
+}
+
+// DueSchedules returns enabled schedules whose next run time has passed
+func (db *DB) DueSchedules(ctx context.Context) ([]JobSchedule, error) {
+	return db.scanSchedules(ctx, `
+		SELECT `+scheduleColumns+`
+		FROM job_schedules
+		WHERE enabled AND next_run_at <= NOW()
+		ORDER BY next_run_at ASC
+	`)
+}
+
+// MarkScheduleRun records that a schedule fired and when it should fire next
+func (db *DB) MarkScheduleRun(ctx context.Context, id int, ranAt time.Time, nextRunAt time.Time) error {
+	_, err := db.pool.Exec(ctx, `
+		UPDATE job_schedules
+		SET last_run_at = $2,
+		    next_run_at = $3
+		WHERE id = $1
+	`, id, ranAt, nextRunAt)
+	return err
+}
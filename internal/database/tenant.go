@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// withTenant runs fn in a transaction scoped to a tenant: app.tenant is set for
+// the row-level security policies on notes and note_embeddings. An empty owner
+// only sees rows without an owner (authentication disabled).
+func (db *DB) withTenant(ctx context.Context, owner string, fn func(tx pgx.Tx) error) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT set_config('app.tenant', $1, true)`, owner); err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// NullOwner stores the unauthenticated tenant as NULL
+func NullOwner(owner string) *string {
+	return nullIfEmpty(owner)
+}
+
+// AssignUnownedNotes gives notes created before tenants existed, and their
+// embeddings, to a tenant. Returns how many notes were assigned.
+// The row-level security policies only let a session write rows of its own
+// tenant, so they are lifted for this transaction; the lock taken by doing so
+// keeps other sessions out until the policies are restored.
+func (db *DB) AssignUnownedNotes(ctx context.Context, owner string) (int64, error) {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+		ALTER TABLE notes NO FORCE ROW LEVEL SECURITY;
+		ALTER TABLE note_embeddings NO FORCE ROW LEVEL SECURITY;
+	`); err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE notes SET owner = $1 WHERE owner IS NULL`, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE note_embeddings SET owner = $1 WHERE owner IS NULL`, owner); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		ALTER TABLE notes FORCE ROW LEVEL SECURITY;
+		ALTER TABLE note_embeddings FORCE ROW LEVEL SECURITY;
+	`); err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TenantUsage is a tenant's AI usage in one monthly period. The unauthenticated
+// tenant is stored with an empty owner.
+type TenantUsage struct {
+	PeriodStart     time.Time `json:"period_start"`
+	LLMTokens       int64     `json:"llm_tokens"`
+	EmbeddingTokens int64     `json:"embedding_tokens"`
+	LLMCalls        int64     `json:"llm_calls"`
+	EmbeddingCalls  int64     `json:"embedding_calls"`
+}
+
+// TenantQuota overrides the default quotas of one tenant. Nil fields keep the default.
+type TenantQuota struct {
+	LLMTokens       *int64
+	EmbeddingTokens *int64
+	Notes           *int64
+}
+
+// GetTenantUsage returns a tenant's usage for the period starting at periodStart,
+// zero if nothing was recorded yet
+func (db *DB) GetTenantUsage(ctx context.Context, owner string, periodStart time.Time) (*TenantUsage, error) {
+	usage := &TenantUsage{PeriodStart: periodStart}
+
+	err := db.pool.QueryRow(ctx, `
+		SELECT llm_tokens, embedding_tokens, llm_calls, embedding_calls
+		FROM tenant_usage
+		WHERE owner = $1 AND period_start = $2
+	`, owner, periodStart).Scan(&usage.LLMTokens, &usage.EmbeddingTokens, &usage.LLMCalls, &usage.EmbeddingCalls)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// AddTenantUsage adds tokens spent by one request to a tenant's period counters
+func (db *DB) AddTenantUsage(ctx context.Context, owner string, periodStart time.Time, llmTokens int64, embeddingTokens int64) error {
+	llmCalls, embeddingCalls := 0, 0
+	if llmTokens > 0 {
+		llmCalls = 1
+	}
+	if embeddingTokens > 0 {
+		embeddingCalls = 1
+	}
+
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO tenant_usage (owner, period_start, llm_tokens, embedding_tokens, llm_calls, embedding_calls)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (owner, period_start) DO UPDATE
+		SET llm_tokens = tenant_usage.llm_tokens + EXCLUDED.llm_tokens,
+		    embedding_tokens = tenant_usage.embedding_tokens + EXCLUDED.embedding_tokens,
+		    llm_calls = tenant_usage.llm_calls + EXCLUDED.llm_calls,
+		    embedding_calls = tenant_usage.embedding_calls + EXCLUDED.embedding_calls,
+		    updated_at = NOW()
+	`, owner, periodStart, llmTokens, embeddingTokens, llmCalls, embeddingCalls)
+	return err
+}
+
+// GetTenantQuota returns a tenant's quota overrides, nil if it has none
+func (db *DB) GetTenantQuota(ctx context.Context, owner string) (*TenantQuota, error) {
+	var quota TenantQuota
+	err := db.pool.QueryRow(ctx, `
+		SELECT llm_tokens, embedding_tokens, notes
+		FROM tenant_quotas
+		WHERE owner = $1
+	`, owner).Scan(&quota.LLMTokens, &quota.EmbeddingTokens, &quota.Notes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &quota, nil
+}
+
+// CountNotes returns the number of notes a tenant owns
+func (db *DB) CountNotes(ctx context.Context, owner string) (int64, error) {
+	var count int64
+	err := db.withTenant(ctx, owner, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM notes WHERE owner IS NOT DISTINCT FROM $1
+		`, NullOwner(owner)).Scan(&count)
+	})
+	return count, err
+}
@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// WebhookDelivery records a single attempt to deliver a job callback
+type WebhookDelivery struct {
+	ID         int64     `json:"id"`
+	JobID      string    `json:"job_id"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"status_code,omitempty"`
+	Error      *string   `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RecordWebhookDelivery stores the outcome of a callback delivery attempt
+func (db *DB) RecordWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (job_id, url, attempt, status_code, error, delivered)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, d.JobID, d.URL, d.Attempt, d.StatusCode, d.Error, d.Delivered)
+	return err
+}
+
+// GetWebhookDeliveries returns all delivery attempts for a job, oldest first
+func (db *DB) GetWebhookDeliveries(ctx context.Context, jobID string) ([]WebhookDelivery, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, job_id, url, attempt, status_code, error, delivered, created_at
+		FROM webhook_deliveries
+		WHERE job_id = $1
+		ORDER BY id ASC
+	`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(
+			&d.ID,
+			&d.JobID,
+			&d.URL,
+			&d.Attempt,
+			&d.StatusCode,
+			&d.Error,
+			&d.Delivered,
+			&d.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// WorkerDeadAfter is how long a worker may go without a heartbeat before it is reported dead
+const WorkerDeadAfter = 30 * time.Second
+
+// workerForgetAfter is how long dead workers stay listed before they are removed
+const workerForgetAfter = 24 * time.Hour
+
+// WorkerStatus is a worker's latest heartbeat
+type WorkerStatus struct {
+	ID              string    `json:"id"`
+	Hostname        string    `json:"hostname"`
+	Version         string    `json:"version"`
+	Queues          []string  `json:"queues"`
+	StartedAt       time.Time `json:"started_at"`
+	LastHeartbeatAt time.Time `json:"last_heartbeat_at"`
+	CurrentJobID    *string   `json:"current_job_id,omitempty"`
+	JobsProcessed   int64     `json:"jobs_processed"`
+	JobsFailed      int64     `json:"jobs_failed"`
+	JobsRetried     int64     `json:"jobs_retried"`
+	LeaseConflicts  int64     `json:"lease_conflicts"`
+	Live            bool      `json:"live"`
+}
+
+// RecordWorkerHeartbeat registers the worker on first call and refreshes its state afterwards
+func (db *DB) RecordWorkerHeartbeat(ctx context.Context, w WorkerStatus) error {
+	_, err := db.pool.Exec(ctx, `
+		INSERT INTO workers (id, hostname, version, queues, started_at, last_heartbeat_at,
+		                     current_job_id, jobs_processed, jobs_failed, jobs_retried, lease_conflicts)
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE
+		SET last_heartbeat_at = NOW(),
+		    current_job_id = EXCLUDED.current_job_id,
+		    jobs_processed = EXCLUDED.jobs_processed,
+		    jobs_failed = EXCLUDED.jobs_failed,
+		    jobs_retried = EXCLUDED.jobs_retried,
+		    lease_conflicts = EXCLUDED.lease_conflicts
+	`, w.ID, w.Hostname, w.Version, w.Queues, w.StartedAt,
+		w.CurrentJobID, w.JobsProcessed, w.JobsFailed, w.JobsRetried, w.LeaseConflicts)
+	return err
+}
+
+// ForgetDeadWorkers removes workers that stopped heart-beating long ago
+func (db *DB) ForgetDeadWorkers(ctx context.Context) error {
+	_, err := db.pool.Exec(ctx, `
+		DELETE FROM workers
+		WHERE last_heartbeat_at < $1
+	`, time.Now().Add(-workerForgetAfter))
+	return err
+}
+
+// ListWorkers returns all known workers, live ones first, newest heartbeat first
+func (db *DB) ListWorkers(ctx context.Context) ([]WorkerStatus, error) {
+	rows, err := db.pool.Query(ctx, `
+		SELECT id, hostname, version, queues, started_at, last_heartbeat_at,
+		       current_job_id::text, jobs_processed, jobs_failed, jobs_retried, lease_conflicts,
+		       last_heartbeat_at > $1 AS live
+		FROM workers
+		ORDER BY live DESC, last_heartbeat_at DESC
+	`, time.Now().Add(-WorkerDeadAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := []WorkerStatus{}
+	for rows.Next() {
+		var w WorkerStatus
+		if err := rows.Scan(
+			&w.ID,
+			&w.Hostname,
+			&w.Version,
+			&w.Queues,
+			&w.StartedAt,
+			&w.LastHeartbeatAt,
+			&w.CurrentJobID,
+			&w.JobsProcessed,
+			&w.JobsFailed,
+			&w.JobsRetried,
+			&w.LeaseConflicts,
+			&w.Live,
+		); err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+
+	return workers, rows.Err()
+}
+
+// CountLeaseConflicts sums the rejected stale writes reported by all known workers
+func (db *DB) CountLeaseConflicts(ctx context.Context) (int64, error) {
+	var total int64
+	err := db.pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(lease_conflicts), 0)
+		FROM workers
+	`).Scan(&total)
+	return total, err
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/database"
+)
+
+// defaultRotationGrace keeps a rotated key valid long enough for clients to switch over
+const defaultRotationGrace = 24 * time.Hour
+
+// CreateAPIKeyRequest issues a key for the caller's tenant.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Owner     string     `json:"owner,omitempty"` // Defaults to the caller's tenant; no other tenant is allowed
+	Role      string     `json:"role,omitempty"`  // viewer, editor (default) or admin
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// RotateAPIKeyRequest is the optional body of POST /api-keys/:id/rotate.
+type RotateAPIKeyRequest struct {
+	Grace string `json:"grace,omitempty"` // How long the old key keeps working, e.g. "1h" (default 24h)
+}
+
+// ListAPIKeys returns the API keys of the caller's tenant without their secrets.
+func (h *Handler) ListAPIKeys(c *fiber.Ctx) error {
+	owner, handled, err := apiKeyOwner(c)
+	if handled {
+		return err
+	}
+
+	keys, err := h.db.ListAPIKeys(context.Background(), owner)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list API keys")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list API keys",
+		})
+	}
+
+	return c.JSON(fiber.Map{"keys": keys})
+}
+
+// CreateAPIKey issues a new key for the caller's tenant. The plaintext key is only
+// returned in this response.
+func (h *Handler) CreateAPIKey(c *fiber.Ctx) error {
+	owner, handled, err := apiKeyOwner(c)
+	if handled {
+		return err
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "name is required",
+		})
+	}
+
+	// Keys for other tenants are issued with the apikey CLI
+	if o := strings.TrimSpace(req.Owner); o != "" && o != owner {
+		return c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "API keys can only be issued for your own tenant",
+		})
+	}
+
+	role := auth.DefaultRole
+	if req.Role != "" {
+		parsed, err := auth.ParseRole(req.Role)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		role = parsed
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+
+	key, stored, err := auth.IssueAPIKey(context.Background(), h.db, req.Name, owner, role, req.ExpiresAt)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create API key")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create API key",
+		})
+	}
+
+	h.audit(c, database.AuditCreate, "api_key", auditIDs(stored.ID), fiber.Map{
+		"owner": stored.Owner,
+		"role":  stored.Role,
+	})
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"key":     key,
+		"api_key": stored,
+	})
+}
+
+// RotateAPIKey issues a replacement key and revokes the old one after a grace period.
+// Only keys of the caller's tenant can be rotated.
+func (h *Handler) RotateAPIKey(c *fiber.Ctx) error {
+	owner, handled, err := apiKeyOwner(c)
+	if handled {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid API key id",
+		})
+	}
+
+	var req RotateAPIKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid request body",
+			})
+		}
+	}
+
+	grace := defaultRotationGrace
+	if req.Grace != "" {
+		grace, err = time.ParseDuration(req.Grace)
+		if err != nil || grace < 0 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "grace must be a positive duration like \"1h\"",
+			})
+		}
+	}
+
+	key, stored, err := auth.RotateAPIKey(context.Background(), h.db, id, owner, grace)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "API key not found",
+			})
+		case errors.Is(err, auth.ErrAPIKeyRevoked):
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "API key is already revoked",
+			})
+		}
+
+		log.Error().Err(err).Msg("failed to rotate API key")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to rotate API key",
+		})
+	}
+
+	h.audit(c, database.AuditUpdate, "api_key", auditIDs(id, stored.ID), fiber.Map{
+		"rotated_to": stored.ID,
+		"grace":      grace.String(),
+	})
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{
+		"key":            key,
+		"api_key":        stored,
+		"old_revoked_at": time.Now().Add(grace),
+	})
+}
+
+// RevokeAPIKey revokes a key of the caller's tenant immediately.
+func (h *Handler) RevokeAPIKey(c *fiber.Ctx) error {
+	owner, handled, err := apiKeyOwner(c)
+	if handled {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid API key id",
+		})
+	}
+
+	revoked, err := h.db.RevokeAPIKey(context.Background(), id, owner, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to revoke API key")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to revoke API key",
+		})
+	}
+
+	if !revoked {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "API key not found or already revoked",
+		})
+	}
+
+	h.audit(c, database.AuditDelete, "api_key", auditIDs(id), nil)
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// apiKeyOwner returns the tenant whose keys the caller manages. Without a tenant
+// (AUTH_MODE=none) key management is refused, since an empty owner would match
+// every tenant; operators use the apikey CLI instead.
+func apiKeyOwner(c *fiber.Ctx) (owner string, handled bool, err error) {
+	owner = requestOwner(c)
+	if owner == "" {
+		return "", true, c.Status(http.StatusForbidden).JSON(fiber.Map{
+			"error": "API keys can only be managed by an authenticated tenant, use the apikey CLI",
+		})
+	}
+	return owner, false, nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/database"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 500
+)
+
+// audit records that the caller performed action on the given records. Failures
+// are logged rather than failing a request whose work is already done.
+func (h *Handler) audit(c *fiber.Ctx, action string, resource string, targetIDs []string, details interface{}) {
+	entry := database.AuditEntry{
+		Actor:      "anonymous",
+		AuthMethod: "none",
+		Action:     action,
+		Resource:   resource,
+		TargetIDs:  targetIDs,
+	}
+
+	if principal := auth.FromContext(c); principal != nil {
+		entry.Actor = principal.Subject
+		entry.AuthMethod = principal.Method
+		if principal.Role != "" {
+			role := string(principal.Role)
+			entry.Role = &role
+		}
+	}
+
+	if owner := requestOwner(c); owner != "" {
+		entry.Owner = &owner
+	}
+
+	if requestID, _ := c.Locals("requestid").(string); requestID != "" {
+		entry.RequestID = &requestID
+	}
+
+	if err := h.auditLog.RecordAudit(context.Background(), entry, details); err != nil {
+		log.Error().Err(err).Str("action", action).Str("resource", resource).Msg("failed to write audit log")
+	}
+}
+
+// auditIDs formats numeric record IDs as audit target IDs
+func auditIDs(ids ...int) []string {
+	targets := make([]string, 0, len(ids))
+	for _, id := range ids {
+		targets = append(targets, strconv.Itoa(id))
+	}
+	return targets
+}
+
+// ListAuditLog returns audit entries newest first with optional filters:
+// ?actor=...&action=create,delete&resource=note&target_id=42&request_id=...
+// &after=RFC3339&before=RFC3339&owner=...&limit=100&cursor=...
+// Authenticated admins only see the entries of their own tenant.
+func (h *Handler) ListAuditLog(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if owner := requestOwner(c); owner != "" {
+		filter.Owner = owner
+	}
+
+	// Fetch one extra row to know whether another page exists
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	entries, err := h.auditLog.ListAuditEntries(context.Background(), filter)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list audit log")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list audit log",
+		})
+	}
+
+	var nextCursor *string
+	if len(entries) > pageSize {
+		entries = entries[:pageSize]
+		cursor := strconv.FormatInt(entries[len(entries)-1].ID, 10)
+		nextCursor = &cursor
+	}
+
+	return c.JSON(fiber.Map{
+		"entries":     entries,
+		"next_cursor": nextCursor,
+	})
+}
+
+// parseAuditFilter reads audit log filters from the query string
+func parseAuditFilter(c *fiber.Ctx) (database.AuditFilter, error) {
+	filter := database.AuditFilter{
+		Owner:     c.Query("owner"),
+		Actor:     c.Query("actor"),
+		Resource:  c.Query("resource"),
+		TargetID:  c.Query("target_id"),
+		RequestID: c.Query("request_id"),
+		Limit:     defaultAuditPageSize,
+	}
+
+	if raw := c.Query("action"); raw != "" {
+		for _, action := range strings.Split(raw, ",") {
+			if action = strings.TrimSpace(action); action != "" {
+				filter.Actions = append(filter.Actions, action)
+			}
+		}
+	}
+
+	for param, target := range map[string]**time.Time{
+		"after":  &filter.After,
+		"before": &filter.Before,
+	} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+		}
+		*target = &t
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxAuditPageSize {
+			limit = maxAuditPageSize
+		}
+		filter.Limit = limit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			return filter, fmt.Errorf("invalid cursor")
+		}
+		filter.BeforeID = id
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// CancelJobRequest is the optional body for DELETE /jobs/:id and POST /jobs/:id/cancel.
+type CancelJobRequest struct {
+	Reason string `json:"reason"`
+}
+
+const defaultCancelReason = "cancelled by client"
+
+// CancelJob cancels a queued or in-progress job. Queued payloads are removed from
+// Redis and running workers are signalled to abort the RAG pipeline.
+// Cancelling a batch job cancels all of its unfinished items.
+func (h *Handler) CancelJob(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	jobID := c.Params("id")
+	if jobID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "job id is required",
+		})
+	}
+
+	var req CancelJobRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "invalid request body",
+			})
+		}
+	}
+
+	job, err := h.getOwnedJob(ctx, c, jobID)
+	if err != nil {
+		return jobFetchError(c, err)
+	}
+
+	// Items finish their batch, so they are only cancelled through it
+	if job.ParentID != nil {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error":     "batch items cannot be cancelled individually, cancel the batch instead",
+			"parent_id": *job.ParentID,
+		})
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = defaultCancelReason
+	}
+
+	previousStatus, err := h.jobs.CancelJob(ctx, jobID, reason)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error().Err(err).Str("job_id", jobID).Msg("failed to cancel job")
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to cancel job",
+			})
+		}
+
+		// Distinguish unknown jobs from jobs that already finished
+		status, statusErr := h.jobs.GetJobStatus(ctx, jobID)
+		if statusErr != nil {
+			return c.Status(http.StatusNotFound).JSON(fiber.Map{
+				"error": "job not found",
+			})
+		}
+
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error":  "job can no longer be cancelled",
+			"status": status,
+		})
+	}
+
+	h.recordJobEvent(ctx, jobID, database.EventCancelled, map[string]interface{}{
+		"reason":          reason,
+		"previous_status": previousStatus,
+	})
+
+	// The database row is the source of truth; Redis cleanup is best effort
+	if h.queue != nil {
+		switch previousStatus {
+		case "queued":
+			if _, err := h.queue.RemoveQueuedJob(ctx, jobID); err != nil {
+				log.Warn().Err(err).Str("job_id", jobID).Msg("failed to remove queued job payload")
+			}
+		case "processing":
+			if err := h.queue.PublishJobCancellation(ctx, jobID, reason); err != nil {
+				log.Warn().Err(err).Str("job_id", jobID).Msg("failed to signal job cancellation")
+			}
+		}
+	}
+
+	if job.Type == "batch" {
+		h.cancelBatchItems(ctx, jobID, reason)
+	}
+
+	if h.queue != nil {
+		if err := h.queue.PublishJobEvent(ctx, database.JobEvent{
+			JobID:  jobID,
+			Status: "cancelled",
+			Error:  reason,
+		}); err != nil {
+			log.Warn().Err(err).Str("job_id", jobID).Msg("failed to publish job event")
+		}
+	}
+
+	h.audit(c, database.AuditUpdate, "job", []string{jobID}, fiber.Map{
+		"status":          "cancelled",
+		"previous_status": previousStatus,
+	})
+
+	return c.JSON(fiber.Map{
+		"job_id":          jobID,
+		"status":          "cancelled",
+		"previous_status": previousStatus,
+		"reason":          reason,
+	})
+}
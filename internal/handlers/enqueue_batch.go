@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// maxBatchQueries caps the number of queries in a single batch job
+const maxBatchQueries = 100
+
+// BatchQueryRequest runs several queries as one batch job.
+type BatchQueryRequest struct {
+	Queries           []string `json:"queries"`
+	IdempotencyKey    *string  `json:"idempotency_key,omitempty"`    // Optional client key, or the Idempotency-Key header
+	IdempotencyWindow string   `json:"idempotency_window,omitempty"` // Optional dedupe window, e.g. "1h"
+	CallbackURL       *string  `json:"callback_url,omitempty"`       // Optional webhook, called once the whole batch is done
+	Queue             string   `json:"queue,omitempty"`              // Optional named queue for the item jobs
+	Priority          int      `json:"priority,omitempty"`           // Optional priority within the queue
+}
+
+// batchRequestHash fingerprints the parameters that affect a batch job's result
+func batchRequestHash(owner string, req BatchQueryRequest) string {
+	normalized := struct {
+		Queries     []string `json:"queries"`
+		CallbackURL string   `json:"callback_url,omitempty"`
+	}{
+		Queries: make([]string, len(req.Queries)),
+	}
+
+	for i, query := range req.Queries {
+		normalized.Queries[i] = strings.TrimSpace(strings.ToLower(query))
+	}
+
+	if req.CallbackURL != nil {
+		normalized.CallbackURL = *req.CallbackURL
+	}
+
+	return hashRequest(owner, "batch", normalized)
+}
+
+// BatchCost returns the rate limit cost of a batch request: perQuery for each of
+// its queries. Invalid requests cost perQuery; the handler rejects them.
+func BatchCost(perQuery int) func(c *fiber.Ctx) int {
+	return func(c *fiber.Ctx) int {
+		var req struct {
+			Queries []string `json:"queries"`
+		}
+		if err := json.Unmarshal(c.Body(), &req); err != nil || len(req.Queries) == 0 {
+			return perQuery
+		}
+		return len(req.Queries) * perQuery
+	}
+}
+
+// EnqueueBatchJob creates a batch job that fans out to one query job per item.
+// Progress and per-item results are reported by GET /jobs/:id.
+func (h *Handler) EnqueueBatchJob(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	var req BatchQueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid input",
+		})
+	}
+
+	if len(req.Queries) == 0 || len(req.Queries) > maxBatchQueries {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("queries must contain between 1 and %d items", maxBatchQueries),
+		})
+	}
+
+	for i, query := range req.Queries {
+		if strings.TrimSpace(query) == "" {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": fmt.Sprintf("queries[%d] is empty", i),
+			})
+		}
+	}
+
+	if req.CallbackURL != nil {
+		if err := h.validateCallbackURL(ctx, *req.CallbackURL); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	if err := validateQueueOptions(req.Queue, req.Priority); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key, err := idempotencyKey(c, req.IdempotencyKey)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	window, err := h.idempotencyWindow(req.IdempotencyWindow)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	owner := requestOwner(c)
+	requestHash := batchRequestHash(owner, req)
+	finalHash := jobContentHash(owner, key, requestHash)
+
+	existing, err := h.findDuplicateJob(ctx, key, finalHash, window)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "duplicate check failed"})
+	}
+
+	if existing != nil {
+		return replayJob(c, existing, requestHash)
+	}
+
+	// Reject up front rather than failing every item once they run
+	if handled, err := h.checkQuota(ctx, c, quota.EmbeddingTokens, quota.LLMTokens); handled {
+		return err
+	}
+
+	if h.queue == nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "background jobs are not available on this deployment",
+		})
+	}
+
+	jobID, itemIDs, err := h.jobs.CreateBatchJob(ctx, req, req.Queries, finalHash, database.JobOptions{
+		Queue:       req.Queue,
+		Priority:    req.Priority,
+		Owner:       owner,
+		RequestHash: requestHash,
+	})
+	if errors.Is(err, database.ErrDuplicateJob) {
+		return h.replayDuplicateJob(ctx, c, finalHash, requestHash)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create batch job records in database")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create job record",
+		})
+	}
+
+	h.recordJobEvent(ctx, jobID, database.EventEnqueued, map[string]interface{}{
+		"queue": req.Queue,
+		"items": len(itemIDs),
+	})
+
+	for i, itemID := range itemIDs {
+		if err := h.queue.EnqueueJob(ctx, itemID, "query", QueryRequest{Query: req.Queries[i]}, req.Queue, req.Priority, owner); err != nil {
+			log.Error().Err(err).Str("job_id", jobID).Msg("Failed to enqueue batch item")
+			h.cancelBatch(ctx, jobID, "failed to enqueue batch items")
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "failed to enqueue job",
+			})
+		}
+
+		h.recordJobEvent(ctx, itemID, database.EventEnqueued, map[string]interface{}{
+			"queue":     req.Queue,
+			"priority":  req.Priority,
+			"parent_id": jobID,
+		})
+	}
+
+	if err := h.queue.PublishJobEvent(ctx, database.JobEvent{JobID: jobID, Status: "processing"}); err != nil {
+		log.Warn().Err(err).Str("job_id", jobID).Msg("failed to publish job event")
+	}
+
+	h.audit(c, database.AuditCreate, "job", append([]string{jobID}, itemIDs...), fiber.Map{"type": "batch"})
+
+	return c.JSON(fiber.Map{
+		"job_id":   jobID,
+		"status":   "processing",
+		"item_ids": itemIDs,
+	})
+}
+
+// cancelBatch cancels a batch job that could not be fully enqueued, together with its items
+func (h *Handler) cancelBatch(ctx context.Context, jobID string, reason string) {
+	if _, err := h.jobs.CancelJob(ctx, jobID, reason); err != nil {
+		log.Warn().Err(err).Str("job_id", jobID).Msg("failed to cancel batch job")
+	}
+
+	h.cancelBatchItems(ctx, jobID, reason)
+}
+
+// cancelBatchItems cancels the unfinished items of a cancelled batch job.
+// Queued payloads are removed from Redis and running workers are signalled to abort.
+func (h *Handler) cancelBatchItems(ctx context.Context, jobID string, reason string) {
+	items, err := h.jobs.CancelBatchItems(ctx, jobID, reason)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID).Msg("failed to cancel batch items")
+		return
+	}
+
+	for _, item := range items {
+		h.recordJobEvent(ctx, item.JobID, database.EventCancelled, map[string]interface{}{
+			"reason":          reason,
+			"previous_status": item.PreviousStatus,
+			"parent_id":       jobID,
+		})
+
+		if h.queue == nil {
+			continue
+		}
+
+		switch item.PreviousStatus {
+		case "queued":
+			if _, err := h.queue.RemoveQueuedJob(ctx, item.JobID); err != nil {
+				log.Warn().Err(err).Str("job_id", item.JobID).Msg("failed to remove queued job payload")
+			}
+		case "processing":
+			if err := h.queue.PublishJobCancellation(ctx, item.JobID, reason); err != nil {
+				log.Warn().Err(err).Str("job_id", item.JobID).Msg("failed to signal job cancellation")
+			}
+		}
+	}
+}
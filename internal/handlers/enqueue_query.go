@@ -2,30 +2,38 @@ package handlers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/webhooks"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 )
 
-func generateJobContentHash(req QueryRequest) string {
+// queryRequestHash fingerprints the parameters that affect a query job's result
+func queryRequestHash(owner string, req QueryRequest) string {
 	normalized := struct {
-		Query string `json:"query"`
+		Query       string `json:"query"`
+		CallbackURL string `json:"callback_url,omitempty"`
 	}{
 		Query: strings.TrimSpace(strings.ToLower(req.Query)),
 	}
 
-	data, _ := json.Marshal(normalized)
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString((hash[:]))
+	// Identical queries with different callbacks must each be delivered
+	if req.CallbackURL != nil {
+		normalized.CallbackURL = *req.CallbackURL
+	}
+
+	return hashRequest(owner, "query", normalized)
 }
 
-func EnqueueQueryJob(c *fiber.Ctx) error {
+func (h *Handler) EnqueueQueryJob(c *fiber.Ctx) error {
 	ctx := context.Background()
 
 	// Parse request body
@@ -36,32 +44,79 @@ func EnqueueQueryJob(c *fiber.Ctx) error {
 		})
 	}
 
-	//  Generate content hash
-	var finalHash string
-	// Use client-provided key
-	if req.IdempotencyKey != nil {
-		finalHash = "client:" + *req.IdempotencyKey
-	} else {
-		// Use content-based hash
-		finalHash = "content:" + generateJobContentHash(req)
+	if req.CallbackURL != nil {
+		if err := h.validateCallbackURL(ctx, *req.CallbackURL); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	if err := validateQueueOptions(req.Queue, req.Priority); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
 	}
 
-	// Check for recent duplicate (last 5 minutes)
-	existingJobID, err := database.CheckRecentDuplicateJob(ctx, finalHash, 5)
+	runAt, err := resolveRunAt(req)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	key, err := idempotencyKey(c, req.IdempotencyKey)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	window, err := h.idempotencyWindow(req.IdempotencyWindow)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	//  Generate content hash, scoped to the tenant
+	owner := requestOwner(c)
+	requestHash := queryRequestHash(owner, req)
+	finalHash := jobContentHash(owner, key, requestHash)
+
+	// Check for a job with the same key, or a recent one for the same request
+	existing, err := h.findDuplicateJob(ctx, key, finalHash, window)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "duplicate check failed"})
 	}
 
-	if existingJobID != nil {
-		// Return existing job instead of creating new one
-		return c.JSON(fiber.Map{
-			"job_id":  *existingJobID,
-			"status":  "existing_job_found",
-			"message": "Identical query was recently submitted",
+	if existing != nil {
+		// Answer from the existing job instead of creating a new one
+		return replayJob(c, existing, requestHash)
+	}
+
+	// Reject up front rather than failing the job once it runs
+	if handled, err := h.checkQuota(ctx, c, quota.EmbeddingTokens, quota.LLMTokens); handled {
+		return err
+	}
+
+	if h.queue == nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "background jobs are not available on this deployment",
 		})
 	}
 
-	jobID, err := database.CreateJob(ctx, "query", req, finalHash)
+	jobID, err := h.jobs.CreateJob(ctx, "query", req, finalHash, database.JobOptions{
+		RunAt:       runAt,
+		Queue:       req.Queue,
+		Priority:    req.Priority,
+		Owner:       owner,
+		RequestHash: requestHash,
+	})
+	if errors.Is(err, database.ErrDuplicateJob) {
+		// A concurrent request with the same key got there first
+		return h.replayDuplicateJob(ctx, c, finalHash, requestHash)
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create job record in database")
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
@@ -69,32 +124,121 @@ func EnqueueQueryJob(c *fiber.Ctx) error {
 		})
 	}
 
-	// 3.  Prepare job payload for Redis
-	jobPayload := map[string]interface{}{
-		"id":    jobID,
-		"type":  "query",
-		"input": req,
-	}
+	// Delayed jobs are queued by the worker scheduler once run_at passes
+	if runAt != nil && runAt.After(time.Now()) {
+		h.recordJobEvent(ctx, jobID, database.EventScheduled, map[string]interface{}{
+			"run_at": runAt,
+			"queue":  req.Queue,
+		})
 
-	payloadBytes, _ := json.Marshal(jobPayload)
+		h.audit(c, database.AuditCreate, "job", []string{jobID}, fiber.Map{"type": "query", "run_at": runAt})
 
-	// 4. Push into Redis queue
-	// Redis key: jobs:queue
-	if database.RedisClient == nil {
-		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
-			"error": "background jobs are not available on this deployment",
+		return c.JSON(fiber.Map{
+			"job_id": jobID,
+			"status": "scheduled",
+			"run_at": runAt,
 		})
 	}
 
-	if err := database.RedisClient.RPush(ctx, "jobs:queue", payloadBytes).Err(); err != nil {
+	// Push into Redis queue
+	if err := h.queue.EnqueueJob(ctx, jobID, "query", req, req.Queue, req.Priority, owner); err != nil {
+		log.Error().Err(err).Str("job_id", jobID).Msg("Failed to enqueue job, deferring it to the scheduler")
+		return h.deferJob(ctx, c, jobID)
+	}
+
+	h.recordJobEvent(ctx, jobID, database.EventEnqueued, map[string]interface{}{
+		"queue":    req.Queue,
+		"priority": req.Priority,
+	})
+
+	if err := h.queue.PublishJobEvent(ctx, database.JobEvent{JobID: jobID, Status: "queued"}); err != nil {
+		log.Warn().Err(err).Str("job_id", jobID).Msg("failed to publish job event")
+	}
+
+	h.audit(c, database.AuditCreate, "job", []string{jobID}, fiber.Map{"type": "query"})
+
+	// Respond immediately
+	return c.JSON(fiber.Map{
+		"job_id": jobID,
+		"status": "queued",
+	})
+}
+
+// deferJob answers a request whose job was created but not pushed onto the queue.
+// The job is handed to the worker scheduler, which pushes it once the queue is back,
+// rather than left queued without a payload where a retry would find it in progress forever.
+func (h *Handler) deferJob(ctx context.Context, c *fiber.Ctx, jobID string) error {
+	if err := h.jobs.DeferQueuedJob(ctx, jobID); err != nil {
+		log.Error().Err(err).Str("job_id", jobID).Msg("Failed to defer job")
+
+		// Free the idempotency key for a retry instead
+		if _, err := h.jobs.CancelJob(ctx, jobID, "failed to enqueue job"); err != nil {
+			log.Error().Err(err).Str("job_id", jobID).Msg("Failed to cancel job")
+		}
+
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "failed to enqueue job",
 		})
 	}
 
-	// 5. Respond immediately
+	h.recordJobEvent(ctx, jobID, database.EventScheduled, map[string]interface{}{
+		"reason": "enqueue failed",
+	})
+
+	h.audit(c, database.AuditCreate, "job", []string{jobID}, fiber.Map{"type": "query"})
+
 	return c.JSON(fiber.Map{
 		"job_id": jobID,
-		"status": "queued",
+		"status": "scheduled",
 	})
 }
+
+// recordJobEvent appends an API-side event to the job's history
+func (h *Handler) recordJobEvent(ctx context.Context, jobID string, event string, details interface{}) {
+	if err := h.jobs.RecordJobEvent(ctx, jobID, event, "", 0, "", details); err != nil {
+		log.Warn().Err(err).Str("job_id", jobID).Str("event", event).Msg("failed to record job event")
+	}
+}
+
+// validateCallbackURL checks a job's callback URL, and that callbacks can be sent at all
+func (h *Handler) validateCallbackURL(ctx context.Context, raw string) error {
+	if err := webhooks.CheckSigning(h.webhooks); err != nil {
+		return err
+	}
+	return webhooks.ValidateCallbackURL(ctx, raw)
+}
+
+// queueNamePattern restricts queue names to safe Redis key suffixes
+var queueNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// validateQueueOptions checks the optional queue name and priority of a job
+func validateQueueOptions(queue string, priority int) error {
+	if queue != "" && !queueNamePattern.MatchString(queue) {
+		return fmt.Errorf("queue must be 1-32 characters of a-z, 0-9, '_' or '-'")
+	}
+
+	if priority < database.MinPriority || priority > database.MaxPriority {
+		return fmt.Errorf("priority must be between %d and %d", database.MinPriority, database.MaxPriority)
+	}
+
+	return nil
+}
+
+// resolveRunAt returns when a job should run from its run_at or delay option.
+// Returns nil for jobs that should run immediately.
+func resolveRunAt(req QueryRequest) (*time.Time, error) {
+	if req.RunAt != nil && req.Delay != "" {
+		return nil, fmt.Errorf("run_at and delay cannot both be set")
+	}
+
+	if req.Delay != "" {
+		delay, err := time.ParseDuration(req.Delay)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("delay must be a positive duration like \"10m\"")
+		}
+		runAt := time.Now().Add(delay)
+		return &runAt, nil
+	}
+
+	return req.RunAt, nil
+}
@@ -2,16 +2,27 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
 
 	"notes-memory-core-rag/internal/database"
 )
 
-func GetJob(c *fiber.Ctx) error {
+// maxJobWait caps how long a long-polling GET /jobs/:id?wait= request may block
+const maxJobWait = 60 * time.Second
+
+// GetJob returns a job by ID. With ?wait=30s it long-polls: if the job is not
+// finished it blocks until the worker publishes a status change or the wait expires.
+func (h *Handler) GetJob(c *fiber.Ctx) error {
+	ctx := context.Background()
+
 	jobID := c.Params("id")
 	if jobID == "" {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
@@ -19,22 +30,165 @@ func GetJob(c *fiber.Ctx) error {
 		})
 	}
 
-	job, err := database.GetJobByID(context.Background(), jobID)
+	wait, err := parseWait(c.Query("wait"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Subscribe before reading the row so a transition in between isn't missed
+	var events <-chan database.JobEvent
+	if wait > 0 && h.queue != nil {
+		var unsubscribe func()
+		events, unsubscribe, err = h.queue.SubscribeJobEvents(ctx, jobID)
+		if err != nil {
+			log.Warn().Err(err).Str("job_id", jobID).Msg("failed to subscribe to job events")
+		} else {
+			defer unsubscribe()
+		}
+	}
+
+	job, err := h.getOwnedJob(ctx, c, jobID)
+	if err != nil {
+		return jobFetchError(c, err)
+	}
+
+	if events == nil || database.IsTerminalStatus(job.Status) {
+		return c.JSON(h.withBatchProgress(ctx, job))
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-events:
+		job, err = h.getOwnedJob(ctx, c, jobID)
+		if err != nil {
+			return jobFetchError(c, err)
+		}
+	case <-timer.C:
+	}
+
+	return c.JSON(h.withBatchProgress(ctx, job))
+}
+
+// withBatchProgress attaches per-item progress to an unfinished batch job.
+// Finished batches already carry the combined results in result.
+func (h *Handler) withBatchProgress(ctx context.Context, job *database.Job) *database.Job {
+	if job.Type != "batch" || database.IsTerminalStatus(job.Status) {
+		return job
+	}
+
+	batch, err := h.jobs.GetBatchResult(ctx, job.ID)
+	if err != nil {
+		log.Warn().Err(err).Str("job_id", job.ID).Msg("failed to fetch batch progress")
+		return job
+	}
+
+	job.Batch = batch
+	return job
+}
+
+// parseWait parses the ?wait= parameter as a duration ("30s") or whole seconds ("30")
+func parseWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+
+	wait, err := time.ParseDuration(raw)
 	if err != nil {
-		//  This is the ONLY case that should return 404
-		if err == sql.ErrNoRows {
-			return c.Status(http.StatusNotFound).JSON(fiber.Map{
-				"error": "job not found",
-			})
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid wait duration")
 		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	if wait < 0 {
+		return 0, fmt.Errorf("invalid wait duration")
+	}
+
+	if wait > maxJobWait {
+		wait = maxJobWait
+	}
+
+	return wait, nil
+}
+
+// jobFetchError maps a job lookup error to a 404 or 500 response
+func jobFetchError(c *fiber.Ctx, err error) error {
+	//  This is the ONLY case that should return 404
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "job not found",
+		})
+	}
+
+	//  All other errors are real server errors
+	log.Error().Err(err).Msg("failed to fetch job")
+
+	return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+		"error": "failed to fetch job",
+	})
+}
+
+// GetJobHistory lists every recorded transition of a job, oldest first.
+func (h *Handler) GetJobHistory(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	jobID := c.Params("id")
+	if jobID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "job id is required",
+		})
+	}
+
+	if _, err := h.getOwnedJob(ctx, c, jobID); err != nil {
+		return jobFetchError(c, err)
+	}
+
+	events, err := h.jobs.GetJobHistory(ctx, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch job history")
+
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch job history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"job_id": jobID,
+		"events": events,
+	})
+}
+
+// GetJobDeliveries lists the webhook delivery attempts recorded for a job.
+func (h *Handler) GetJobDeliveries(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	jobID := c.Params("id")
+	if jobID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "job id is required",
+		})
+	}
+
+	if _, err := h.getOwnedJob(ctx, c, jobID); err != nil {
+		return jobFetchError(c, err)
+	}
 
-		//  All other errors are real server errors
-		log.Error().Err(err).Msg("failed to fetch job")
+	deliveries, err := h.db.GetWebhookDeliveries(ctx, jobID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch webhook deliveries")
 
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to fetch job",
+			"error": "failed to fetch webhook deliveries",
 		})
 	}
 
-	return c.JSON(job)
+	return c.JSON(fiber.Map{
+		"job_id":     jobID,
+		"deliveries": deliveries,
+	})
 }
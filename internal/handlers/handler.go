@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"time"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/store"
+)
+
+// Deps are the dependencies of the API handlers. The API wires the Postgres and
+// Redis implementations; tests can use the memory package instead.
+type Deps struct {
+	Notes  store.NotesStore
+	Jobs   store.JobStore
+	Queue  store.Queue // nil without Redis: async endpoints answer 503
+	Audit  store.AuditLog
+	Quotas *quota.Quotas
+
+	Embedder  ai.Embedder
+	Responder ai.Responder
+
+	// DB serves the admin routes (API keys, schedules, workers, webhook deliveries)
+	DB *database.DB
+
+	// IdempotencyWindow is the default dedupe window of job enqueues (IDEMPOTENCY_WINDOW)
+	IdempotencyWindow time.Duration
+
+	// Webhooks decides whether jobs may request callbacks
+	Webhooks config.Webhooks
+}
+
+// Handler serves the API routes. Create one with New.
+type Handler struct {
+	notes    store.NotesStore
+	jobs     store.JobStore
+	queue    store.Queue
+	auditLog store.AuditLog
+	quotas   *quota.Quotas
+	embedder ai.Embedder
+	rag      *RAGPipeline
+	db       *database.DB
+	webhooks config.Webhooks
+
+	defaultIdempotencyWindow time.Duration
+}
+
+// New returns the API handlers backed by deps
+func New(deps Deps) *Handler {
+	return &Handler{
+		notes:                    deps.Notes,
+		jobs:                     deps.Jobs,
+		queue:                    deps.Queue,
+		auditLog:                 deps.Audit,
+		quotas:                   deps.Quotas,
+		embedder:                 deps.Embedder,
+		rag:                      NewRAGPipeline(deps.Notes, deps.Embedder, deps.Responder, deps.Quotas),
+		db:                       deps.DB,
+		webhooks:                 deps.Webhooks,
+		defaultIdempotencyWindow: deps.IdempotencyWindow,
+	}
+}
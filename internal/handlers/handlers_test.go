@@ -0,0 +1,542 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/store/memory"
+)
+
+// testOwnerHeader selects the tenant a test request is made as
+const testOwnerHeader = "X-Test-Owner"
+
+type testAPI struct {
+	app     *fiber.App
+	handler *Handler
+	store   *memory.Store
+	queue   *memory.Queue
+}
+
+// newTestAPI serves the handlers from memory stores. Requests authenticate as
+// an editor of the tenant in testOwnerHeader.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	s := memory.New()
+	q := memory.NewQueue()
+
+	h := New(Deps{
+		Notes:             s,
+		Jobs:              s,
+		Queue:             q,
+		Audit:             s,
+		Quotas:            quota.New(config.Quota{}, s),
+		Embedder:          ai.MockEmbedder{},
+		Responder:         ai.MockResponder{},
+		IdempotencyWindow: 5 * time.Minute,
+	})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		auth.SetPrincipal(c, &auth.Principal{
+			Subject: "test",
+			Owner:   strings.Clone(c.Get(testOwnerHeader)), // Fiber reuses the header buffer
+			Method:  "api_key",
+			Role:    auth.RoleEditor,
+		})
+		return c.Next()
+	})
+
+	app.Get("/notes", h.GetNotes)
+	app.Post("/notes", h.CreateNote)
+	app.Post("/search", h.SemanticSearch)
+	app.Post("/query", h.Query)
+	app.Get("/jobs", h.ListJobs)
+	app.Post("/jobs/query", h.EnqueueQueryJob)
+	app.Get("/jobs/:id", h.GetJob)
+	app.Delete("/jobs/:id", h.CancelJob)
+
+	return &testAPI{app: app, handler: h, store: s, queue: q}
+}
+
+// do sends a request as owner and decodes the JSON response into out, if given
+func (api *testAPI) do(t *testing.T, method string, path string, owner string, body interface{}, out interface{}) int {
+	t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(testOwnerHeader, owner)
+
+	resp, err := api.app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func (api *testAPI) createNote(t *testing.T, owner string, title string, content string) database.Note {
+	t.Helper()
+
+	var note database.Note
+	status := api.do(t, http.MethodPost, "/notes", owner, fiber.Map{"title": title, "content": content}, &note)
+	if status != http.StatusCreated {
+		t.Fatalf("POST /notes: status %d, want %d", status, http.StatusCreated)
+	}
+	return note
+}
+
+func TestCreateNote(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	note := api.createNote(t, "acme", "Standup", "Ship the importer on Friday")
+	if note.ID == 0 || note.Title != "Standup" || note.Content != "Ship the importer on Friday" {
+		t.Fatalf("unexpected note %+v", note)
+	}
+
+	notes, err := api.store.ListNotes(ctx, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].ID != note.ID {
+		t.Fatalf("acme notes = %+v, want the created note", notes)
+	}
+
+	if others, _ := api.store.ListNotes(ctx, "globex"); len(others) != 0 {
+		t.Fatalf("globex sees %d notes, want 0", len(others))
+	}
+
+	entries, err := api.store.ListAuditEntries(ctx, database.AuditFilter{Owner: "acme", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != database.AuditCreate || entries[0].Resource != "note" {
+		t.Fatalf("audit entries = %+v, want one note creation", entries)
+	}
+}
+
+func TestCreateNoteValidation(t *testing.T) {
+	api := newTestAPI(t)
+
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"missing title", fiber.Map{"content": "text"}},
+		{"missing content", fiber.Map{"title": "title"}},
+		{"not an object", "just a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := api.do(t, http.MethodPost, "/notes", "acme", tt.body, nil); status != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCreateNoteQuota(t *testing.T) {
+	api := newTestAPI(t)
+
+	maxNotes := int64(1)
+	api.store.SetTenantQuota("acme", database.TenantQuota{Notes: &maxNotes})
+
+	api.createNote(t, "acme", "First", "fits the quota")
+
+	var body map[string]interface{}
+	status := api.do(t, http.MethodPost, "/notes", "acme", fiber.Map{"title": "Second", "content": "over the quota"}, &body)
+	if status != http.StatusPaymentRequired || body["resource"] != string(quota.Notes) {
+		t.Fatalf("status %d, body %v; want %d for the notes quota", status, body, http.StatusPaymentRequired)
+	}
+
+	// Other tenants keep the default (unlimited) quota
+	api.createNote(t, "globex", "Second", "another tenant")
+}
+
+func TestSemanticSearchIsTenantScoped(t *testing.T) {
+	api := newTestAPI(t)
+
+	acme := api.createNote(t, "acme", "Roadmap", "Q3 roadmap: search relevance")
+	api.createNote(t, "globex", "Roadmap", "Q3 roadmap: search relevance")
+
+	var resp struct {
+		Results []database.NoteMatch `json:"results"`
+	}
+	status := api.do(t, http.MethodPost, "/search", "acme", fiber.Map{"query": "roadmap"}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want %d", status, http.StatusOK)
+	}
+
+	if len(resp.Results) != 1 || resp.Results[0].ID != acme.ID {
+		t.Fatalf("results = %+v, want only acme's note %d", resp.Results, acme.ID)
+	}
+
+	if status := api.do(t, http.MethodPost, "/search", "acme", fiber.Map{"query": "  "}, nil); status != http.StatusBadRequest {
+		t.Fatalf("empty query: status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	acme := api.createNote(t, "acme", "Offsite", "The offsite is in Lisbon")
+	api.createNote(t, "globex", "Secret", "Globex merger closes in May")
+
+	var result RAGResult
+	status := api.do(t, http.MethodPost, "/query", "acme", fiber.Map{"query": "where is the offsite?"}, &result)
+	if status != http.StatusOK {
+		t.Fatalf("status %d, want %d", status, http.StatusOK)
+	}
+
+	if len(result.Results) != 1 || result.Results[0].ID != acme.ID {
+		t.Fatalf("results = %+v, want only acme's note %d", result.Results, acme.ID)
+	}
+	if !strings.Contains(result.Response, "Lisbon") || strings.Contains(result.Response, "merger") {
+		t.Fatalf("response %q must be grounded in acme's notes only", result.Response)
+	}
+
+	// The pipeline's tokens count against the tenant
+	usage, err := api.store.GetTenantUsage(ctx, "acme", quota.PeriodStart(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.LLMTokens == 0 || usage.EmbeddingTokens == 0 {
+		t.Fatalf("usage = %+v, want LLM and embedding tokens recorded", usage)
+	}
+}
+
+func TestQueryQuotaExceeded(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	limit := int64(100)
+	api.store.SetTenantQuota("acme", database.TenantQuota{LLMTokens: &limit})
+	if err := api.store.AddTenantUsage(ctx, "acme", quota.PeriodStart(time.Now()), limit, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var body map[string]interface{}
+	status := api.do(t, http.MethodPost, "/query", "acme", fiber.Map{"query": "anything"}, &body)
+	if status != http.StatusTooManyRequests || body["resource"] != string(quota.LLMTokens) {
+		t.Fatalf("status %d, body %v; want %d for the LLM token quota", status, body, http.StatusTooManyRequests)
+	}
+
+	// Rejected before any AI call, so nothing more is spent
+	usage, _ := api.store.GetTenantUsage(ctx, "acme", quota.PeriodStart(time.Now()))
+	if usage.LLMTokens != limit || usage.EmbeddingTokens != 0 {
+		t.Fatalf("usage = %+v, want it unchanged", usage)
+	}
+
+	// Quotas are per tenant
+	if status := api.do(t, http.MethodPost, "/query", "globex", fiber.Map{"query": "anything"}, nil); status != http.StatusOK {
+		t.Fatalf("globex: status %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestEnqueueAndCancelJob(t *testing.T) {
+	api := newTestAPI(t)
+	ctx := context.Background()
+
+	var enqueued struct {
+		JobID  string `json:"job_id"`
+		Status string `json:"status"`
+	}
+	status := api.do(t, http.MethodPost, "/jobs/query", "acme", fiber.Map{"query": "summarize my notes"}, &enqueued)
+	if status != http.StatusOK || enqueued.Status != "queued" || enqueued.JobID == "" {
+		t.Fatalf("status %d, body %+v; want a queued job", status, enqueued)
+	}
+
+	job, err := api.store.GetJobByID(ctx, enqueued.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "queued" || job.Owner == nil || *job.Owner != "acme" {
+		t.Fatalf("job = %+v, want a queued job owned by acme", job)
+	}
+
+	depths, _ := api.queue.QueueDepths(ctx)
+	if depths[database.DefaultQueue] != 1 {
+		t.Fatalf("queue depths = %v, want the payload on %q", depths, database.DefaultQueue)
+	}
+
+	// The same request within the window answers with the same job
+	var duplicate struct {
+		JobID string `json:"job_id"`
+	}
+	api.do(t, http.MethodPost, "/jobs/query", "acme", fiber.Map{"query": "summarize my notes"}, &duplicate)
+	if duplicate.JobID != enqueued.JobID {
+		t.Fatalf("duplicate enqueue created job %s, want %s", duplicate.JobID, enqueued.JobID)
+	}
+
+	// Other tenants can neither see nor cancel the job
+	path := "/jobs/" + enqueued.JobID
+	if status := api.do(t, http.MethodGet, path, "globex", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("GET as globex: status %d, want %d", status, http.StatusNotFound)
+	}
+	if status := api.do(t, http.MethodDelete, path, "globex", nil, nil); status != http.StatusNotFound {
+		t.Fatalf("DELETE as globex: status %d, want %d", status, http.StatusNotFound)
+	}
+
+	var cancelled map[string]interface{}
+	status = api.do(t, http.MethodDelete, path, "acme", fiber.Map{"reason": "changed my mind"}, &cancelled)
+	if status != http.StatusOK || cancelled["previous_status"] != "queued" || cancelled["reason"] != "changed my mind" {
+		t.Fatalf("status %d, body %v; want the queued job cancelled", status, cancelled)
+	}
+
+	if job, _ := api.store.GetJobByID(ctx, enqueued.JobID); job.Status != "cancelled" {
+		t.Fatalf("job status %q, want cancelled", job.Status)
+	}
+	if depths, _ := api.queue.QueueDepths(ctx); depths[database.DefaultQueue] != 0 {
+		t.Fatalf("queue depths = %v, want the payload removed", depths)
+	}
+
+	var again map[string]interface{}
+	status = api.do(t, http.MethodDelete, path, "acme", nil, &again)
+	if status != http.StatusConflict || again["status"] != "cancelled" {
+		t.Fatalf("second cancel: status %d, body %v; want %d", status, again, http.StatusConflict)
+	}
+}
+
+// lockstepJobs holds the first n duplicate checks until all of them arrived, so
+// concurrent requests all miss each other's jobs and race to create one
+type lockstepJobs struct {
+	*memory.Store
+	checks  atomic.Int32
+	n       int32
+	arrived sync.WaitGroup
+}
+
+func newLockstepJobs(s *memory.Store, n int) *lockstepJobs {
+	l := &lockstepJobs{Store: s, n: int32(n)}
+	l.arrived.Add(n)
+	return l
+}
+
+func (l *lockstepJobs) GetLiveJobByContentHash(ctx context.Context, contentHash string) (*database.Job, error) {
+	if l.checks.Add(1) <= l.n {
+		l.arrived.Done()
+		l.arrived.Wait()
+	}
+	return l.Store.GetLiveJobByContentHash(ctx, contentHash)
+}
+
+func TestEnqueueSameKeyInParallel(t *testing.T) {
+	api := newTestAPI(t)
+
+	const requests = 10
+	api.handler.jobs = newLockstepJobs(api.store, requests)
+	type response struct {
+		status int
+		jobID  string
+	}
+	responses := make(chan response, requests)
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/jobs/query", strings.NewReader(`{"query": "summarize my notes"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", "retry-42")
+			req.Header.Set(testOwnerHeader, "acme")
+
+			resp, err := api.app.Test(req, -1)
+			if err != nil {
+				responses <- response{}
+				return
+			}
+			defer resp.Body.Close()
+
+			var body struct {
+				JobID string `json:"job_id"`
+			}
+			json.NewDecoder(resp.Body).Decode(&body)
+			responses <- response{resp.StatusCode, body.JobID}
+		}()
+	}
+	wg.Wait()
+	close(responses)
+
+	jobs, err := api.store.ListJobs(context.Background(), database.JobFilter{Owner: "acme", Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("%d jobs created, want 1", len(jobs))
+	}
+
+	// One request creates the job; the others lose the insert and see it in progress
+	created := 0
+	for r := range responses {
+		switch {
+		case r.jobID != jobs[0].ID:
+			t.Errorf("status %d for job %q, want job %s", r.status, r.jobID, jobs[0].ID)
+		case r.status == http.StatusOK:
+			created++
+		case r.status != http.StatusConflict:
+			t.Errorf("status %d, want %d or %d", r.status, http.StatusOK, http.StatusConflict)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d requests created the job, want 1", created)
+	}
+}
+
+func TestEnqueueKeyIgnoresWindow(t *testing.T) {
+	api := newTestAPI(t)
+
+	// "0s" only turns off deduplication of requests without a key
+	body := fiber.Map{"query": "summarize my notes", "idempotency_key": "retry-42", "idempotency_window": "0s"}
+
+	var first, second struct {
+		JobID string `json:"job_id"`
+	}
+	api.do(t, http.MethodPost, "/jobs/query", "acme", body, &first)
+	if status := api.do(t, http.MethodPost, "/jobs/query", "acme", body, &second); status != http.StatusConflict || second.JobID != first.JobID {
+		t.Fatalf("retry: status %d for job %q, want %d for job %s", status, second.JobID, http.StatusConflict, first.JobID)
+	}
+
+	unkeyed := fiber.Map{"query": "summarize my notes", "idempotency_window": "0s"}
+	api.do(t, http.MethodPost, "/jobs/query", "acme", unkeyed, &first)
+	api.do(t, http.MethodPost, "/jobs/query", "acme", unkeyed, &second)
+	if first.JobID == second.JobID {
+		t.Fatalf("requests without a key share job %s with a 0s window", first.JobID)
+	}
+}
+
+// failingQueue is a queue that is down for pushes
+type failingQueue struct {
+	*memory.Queue
+}
+
+func (failingQueue) EnqueueJob(ctx context.Context, jobID string, jobType string, input interface{}, queue string, priority int, owner string) error {
+	return errors.New("redis: connection refused")
+}
+
+func TestEnqueueFailureDefersJob(t *testing.T) {
+	api := newTestAPI(t)
+	api.handler.queue = failingQueue{api.queue}
+
+	var enqueued struct {
+		JobID  string `json:"job_id"`
+		Status string `json:"status"`
+	}
+	body := fiber.Map{"query": "summarize my notes", "idempotency_key": "retry-42"}
+	status := api.do(t, http.MethodPost, "/jobs/query", "acme", body, &enqueued)
+	if status != http.StatusOK || enqueued.Status != "scheduled" {
+		t.Fatalf("status %d, body %+v; want the job handed to the scheduler", status, enqueued)
+	}
+
+	// The scheduler finds the job due rather than queued without a payload
+	job, err := api.store.GetJobByID(context.Background(), enqueued.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "scheduled" || job.RunAt == nil || job.RunAt.After(time.Now()) {
+		t.Fatalf("job = %+v, want it scheduled and due", job)
+	}
+}
+
+func TestListJobsIsTenantScoped(t *testing.T) {
+	api := newTestAPI(t)
+
+	for _, owner := range []string{"acme", "globex", ""} {
+		if status := api.do(t, http.MethodPost, "/jobs/query", owner, fiber.Map{"query": "report for " + owner}, nil); status != http.StatusOK {
+			t.Fatalf("enqueue as %q: status %d", owner, status)
+		}
+	}
+
+	tests := []struct {
+		owner string
+		path  string
+	}{
+		{"acme", "/jobs"},
+		{"acme", "/jobs?owner=globex"},
+		{"", "/jobs"},
+		{"", "/jobs?owner=acme"},
+	}
+
+	for _, tt := range tests {
+		var resp struct {
+			Jobs   []database.Job `json:"jobs"`
+			Counts map[string]int `json:"counts"`
+		}
+		if status := api.do(t, http.MethodGet, tt.path, tt.owner, nil, &resp); status != http.StatusOK {
+			t.Fatalf("GET %s as %q: status %d", tt.path, tt.owner, status)
+		}
+
+		// Without a tenant, only jobs without an owner are listed
+		owned := len(resp.Jobs) == 1 && (resp.Jobs[0].Owner == nil) == (tt.owner == "") &&
+			(tt.owner == "" || *resp.Jobs[0].Owner == tt.owner)
+		if !owned || resp.Counts["queued"] != 1 {
+			t.Errorf("GET %s as %q: jobs %+v, counts %v; want only the caller's job", tt.path, tt.owner, resp.Jobs, resp.Counts)
+		}
+	}
+}
+
+func TestValidateScheduleInput(t *testing.T) {
+	tests := []struct {
+		jobType string
+		input   string
+		wantErr string
+	}{
+		{"query", `{"query": "weekly digest"}`, ""},
+		{"query", `{}`, "input.query is required"},
+		{"reembed", ``, ""},
+		{"cleanup", `{"older_than": "30d"}`, ""},
+		{"cleanup", `{"older_than": "12h", "statuses": ["failed"]}`, ""},
+		{"cleanup", `{}`, "older_than is required"},
+		{"cleanup", `{"older_than": "soon"}`, "invalid older_than"},
+		{"cleanup", `{"older_than": "30d", "statuses": ["processing"]}`, "invalid status"},
+		{"digest", `{}`, "unsupported schedule type"},
+	}
+
+	for _, tt := range tests {
+		err := validateScheduleInput(tt.jobType, json.RawMessage(tt.input))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s %s: %v, want it accepted", tt.jobType, tt.input, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s %s: %v, want an error containing %q", tt.jobType, tt.input, err, tt.wantErr)
+		}
+	}
+}
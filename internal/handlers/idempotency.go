@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"notes-memory-core-rag/internal/database"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// maxIdempotencyWindow caps per-request windows
+	maxIdempotencyWindow = 24 * time.Hour
+
+	// maxIdempotencyKeyLength keeps client keys reasonable
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyWindow returns how far back to look for a duplicate of a request without
+// a client key: the request's idempotency_window if set, else IDEMPOTENCY_WINDOW
+// (default 5 minutes). "0s" disables the check; client keys are always honored.
+func (h *Handler) idempotencyWindow(raw string) (time.Duration, error) {
+	if raw == "" {
+		return h.defaultIdempotencyWindow, nil
+	}
+
+	window, err := time.ParseDuration(raw)
+	if err != nil || window < 0 || window > maxIdempotencyWindow {
+		return 0, fmt.Errorf("idempotency_window must be a duration between 0s and %s", maxIdempotencyWindow)
+	}
+
+	return window, nil
+}
+
+// idempotencyKey returns the client key from the Idempotency-Key header or the
+// idempotency_key body field. Both may be set only if they match.
+func idempotencyKey(c *fiber.Ctx, bodyKey *string) (string, error) {
+	key := strings.TrimSpace(c.Get("Idempotency-Key"))
+
+	if bodyKey != nil {
+		if key != "" && key != *bodyKey {
+			return "", fmt.Errorf("idempotency key header and idempotency_key do not match")
+		}
+		key = *bodyKey
+	}
+
+	if len(key) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+
+	return key, nil
+}
+
+// hashRequest fingerprints a request from its tenant and every result-affecting parameter
+func hashRequest(owner string, jobType string, params interface{}) string {
+	data, _ := json.Marshal(struct {
+		Owner  string      `json:"owner"`
+		Type   string      `json:"type"`
+		Params interface{} `json:"params"`
+	}{owner, jobType, params})
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString((hash[:]))
+}
+
+// jobContentHash returns the deduplication key of a job: the client key if one was
+// given, otherwise the request fingerprint. Both are scoped to the tenant.
+func jobContentHash(owner string, key string, requestHash string) string {
+	if key != "" {
+		return "client:" + hashRequest(owner, "", key)
+	}
+	return "content:" + requestHash
+}
+
+// findDuplicateJob returns the job a request duplicates, or nil. A client key
+// matches its job for as long as the job is kept and hasn't failed or been cancelled;
+// without a key, the same request matches within window.
+func (h *Handler) findDuplicateJob(ctx context.Context, key string, contentHash string, window time.Duration) (*database.Job, error) {
+	if key != "" {
+		return h.jobs.GetLiveJobByContentHash(ctx, contentHash)
+	}
+
+	if window <= 0 {
+		return nil, nil
+	}
+	return h.jobs.CheckRecentDuplicateJob(ctx, contentHash, window)
+}
+
+// replayDuplicateJob answers a request whose job could not be created because a
+// concurrent request with the same client key created one first
+func (h *Handler) replayDuplicateJob(ctx context.Context, c *fiber.Ctx, contentHash string, requestHash string) error {
+	existing, err := h.jobs.GetLiveJobByContentHash(ctx, contentHash)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "duplicate check failed"})
+	}
+
+	if existing == nil {
+		// The other job failed or was cancelled meanwhile, so a retry creates a new one
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "an identical request is still in progress",
+		})
+	}
+
+	return replayJob(c, existing, requestHash)
+}
+
+// replayJob answers a request that matched a recent job:
+//   - 422 if the idempotency key was used with a different request
+//   - 409 while the original job is still scheduled, queued or processing
+//   - 200 with the cached result once it completed, marked with Idempotent-Replayed
+func replayJob(c *fiber.Ctx, existing *database.Job, requestHash string) error {
+	if existing.RequestHash != nil && *existing.RequestHash != requestHash {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error":  "idempotency key was already used with a different request",
+			"job_id": existing.ID,
+		})
+	}
+
+	if existing.Status != "completed" {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error":  "an identical request is still in progress",
+			"job_id": existing.ID,
+			"status": existing.Status,
+		})
+	}
+
+	c.Set("Idempotent-Replayed", "true")
+	return c.JSON(fiber.Map{
+		"job_id":   existing.ID,
+		"status":   existing.Status,
+		"result":   existing.Result,
+		"replayed": true,
+	})
+}
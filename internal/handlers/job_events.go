@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// sseHeartbeatInterval keeps idle connections alive through proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// StreamJobEvents streams a job's status transitions as Server-Sent Events.
+// The current status is sent first; the stream ends once the job is finished.
+func (h *Handler) StreamJobEvents(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	jobID := c.Params("id")
+	if jobID == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "job id is required",
+		})
+	}
+
+	if h.queue == nil {
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "background jobs are not available on this deployment",
+		})
+	}
+
+	// Subscribe before reading the row so a transition in between isn't missed
+	events, unsubscribe, err := h.queue.SubscribeJobEvents(ctx, jobID)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", jobID).Msg("failed to subscribe to job events")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to subscribe to job events",
+		})
+	}
+
+	job, err := h.getOwnedJob(ctx, c, jobID)
+	if err != nil {
+		unsubscribe()
+		return jobFetchError(c, err)
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		initial := database.JobEvent{
+			JobID:     job.ID,
+			Status:    job.Status,
+			Timestamp: time.Now().UTC(),
+		}
+		if err := writeSSEEvent(w, initial); err != nil || database.IsTerminalStatus(job.Status) {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if err := writeSSEEvent(w, event); err != nil {
+					return
+				}
+
+				if database.IsTerminalStatus(event.Status) {
+					return
+				}
+
+			case <-heartbeat.C:
+				// A failed flush means the client went away
+				if _, err := w.WriteString(": heartbeat\n\n"); err != nil {
+					return
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}))
+
+	return nil
+}
+
+// writeSSEEvent writes one "status" event and flushes it to the client
+func writeSSEEvent(w *bufio.Writer, event database.JobEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+const (
+	defaultJobsPageSize = 50
+	maxJobsPageSize     = 200
+)
+
+// ListJobs returns jobs newest first with optional filters:
+// ?status=queued,processing&type=query&queue=default&created_after=RFC3339&created_before=RFC3339
+// &worker_id=...&parent_id=...&limit=50&cursor=...
+// The response includes per-status counts for the same filters.
+// Callers only see the jobs of their own tenant.
+func (h *Handler) ListJobs(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	filter, err := parseJobFilter(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	filter.Owner = requestOwner(c)
+
+	// Fetch one extra row to know whether another page exists
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	jobs, err := h.jobs.ListJobs(ctx, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list jobs")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list jobs",
+		})
+	}
+
+	counts, err := h.jobs.CountJobsByStatus(ctx, filter)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count jobs")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to count jobs",
+		})
+	}
+
+	var nextCursor *string
+	if len(jobs) > pageSize {
+		jobs = jobs[:pageSize]
+		cursor := database.EncodeJobCursor(jobs[len(jobs)-1])
+		nextCursor = &cursor
+	}
+
+	return c.JSON(fiber.Map{
+		"jobs":        jobs,
+		"counts":      counts,
+		"next_cursor": nextCursor,
+	})
+}
+
+// parseJobFilter reads job listing filters from the query string
+func parseJobFilter(c *fiber.Ctx) (database.JobFilter, error) {
+	filter := database.JobFilter{
+		Type:     c.Query("type"),
+		Queue:    c.Query("queue"),
+		WorkerID: c.Query("worker_id"),
+		ParentID: c.Query("parent_id"),
+		Limit:    defaultJobsPageSize,
+	}
+
+	if raw := c.Query("status"); raw != "" {
+		for _, status := range strings.Split(raw, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+
+	for param, target := range map[string]**time.Time{
+		"created_after":  &filter.CreatedAfter,
+		"created_before": &filter.CreatedBefore,
+	} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+		}
+		*target = &t
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+		if limit > maxJobsPageSize {
+			limit = maxJobsPageSize
+		}
+		filter.Limit = limit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := database.DecodeJobCursor(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.Cursor = cursor
+	}
+
+	return filter, nil
+}
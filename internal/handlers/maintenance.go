@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// CleanupRequest is the input of a "cleanup" job, which deletes the tenant's
+// finished jobs last updated more than OlderThan ago.
+type CleanupRequest struct {
+	OlderThan string   `json:"older_than"`         // e.g. "30d" or "12h"
+	Statuses  []string `json:"statuses,omitempty"` // Optional, defaults to every final status
+}
+
+// CleanupResult is the result of a "cleanup" job.
+type CleanupResult struct {
+	Deleted int `json:"deleted"`
+}
+
+// ReembedResult is the result of a "reembed" job, which embeds every note of
+// the tenant again. It takes no input.
+type ReembedResult struct {
+	Reembedded int `json:"reembedded"`
+
+	noteIDs []int
+}
+
+// NoteIDs returns the IDs of the re-embedded notes, as audit target IDs
+func (r *ReembedResult) NoteIDs() []string {
+	return auditIDs(r.noteIDs...)
+}
+
+// RetentionRules returns one rule per status, limited to the jobs of owner
+func (r CleanupRequest) RetentionRules(owner string) ([]database.RetentionRule, error) {
+	if strings.TrimSpace(r.OlderThan) == "" {
+		return nil, errors.New("older_than is required")
+	}
+
+	maxAge, err := database.ParseRetentionAge(strings.TrimSpace(r.OlderThan))
+	if err != nil {
+		return nil, fmt.Errorf("invalid older_than: %w", err)
+	}
+
+	statuses := r.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{"completed", "failed", "cancelled"}
+	}
+
+	rules := make([]database.RetentionRule, 0, len(statuses))
+	for _, status := range statuses {
+		if !database.IsTerminalStatus(status) {
+			return nil, fmt.Errorf("invalid status %q: only completed, failed and cancelled jobs can be cleaned up", status)
+		}
+		rules = append(rules, database.RetentionRule{
+			Status: status,
+			MaxAge: maxAge,
+			Tenant: &owner,
+		})
+	}
+
+	return rules, nil
+}
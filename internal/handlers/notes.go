@@ -3,22 +3,14 @@ package handlers
 import (
 	"context"
 	"net/http"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
 
 	"notes-memory-core-rag/internal/ai"
 	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
 )
 
-// Note represents a single note record.
-type Note struct {
-	ID        int       `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-}
-
 // HealthCheck returns a simple service status.
 func HealthCheck(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{
@@ -27,36 +19,29 @@ func HealthCheck(c *fiber.Ctx) error {
 	})
 }
 
-// GetNotes retrieves all notes.
-func GetNotes(c *fiber.Ctx) error {
-	ctx := context.Background()
-
-	rows, err := database.Pool.Query(ctx,
-		`SELECT id, title, content, created_at FROM notes ORDER BY id DESC`)
+// GetNotes retrieves all notes of the caller's tenant.
+func (h *Handler) GetNotes(c *fiber.Ctx) error {
+	notes, err := h.notes.ListNotes(context.Background(), requestOwner(c))
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).
 			JSON(fiber.Map{"error": err.Error()})
 	}
-	defer rows.Close()
-
-	var notes []Note
-	for rows.Next() {
-		var n Note
-		if err := rows.Scan(&n.ID, &n.Title, &n.Content, &n.CreatedAt); err != nil {
-			return c.Status(http.StatusInternalServerError).
-				JSON(fiber.Map{"error": err.Error()})
-		}
-		notes = append(notes, n)
+
+	// Listing returns every note of the tenant, so it is audited as an export
+	ids := make([]int, 0, len(notes))
+	for _, n := range notes {
+		ids = append(ids, n.ID)
 	}
+	h.audit(c, database.AuditExport, "note", auditIDs(ids...), nil)
 
 	return c.JSON(notes)
 }
 
 // CreateNote inserts a new note and generates an embedding (mock or real).
-func CreateNote(c *fiber.Ctx) error {
+func (h *Handler) CreateNote(c *fiber.Ctx) error {
 	ctx := context.Background()
 
-	var n Note
+	var n database.Note
 	if err := c.BodyParser(&n); err != nil {
 		return c.Status(http.StatusBadRequest).
 			JSON(fiber.Map{"error": "invalid request body"})
@@ -67,35 +52,28 @@ func CreateNote(c *fiber.Ctx) error {
 			JSON(fiber.Map{"error": "title and content are required"})
 	}
 
-	// Insert the note
-	query := `
-		INSERT INTO notes (title, content)
-		VALUES ($1, $2)
-		RETURNING id, created_at
-	`
-	err := database.Pool.QueryRow(ctx, query, n.Title, n.Content).
-		Scan(&n.ID, &n.CreatedAt)
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).
-			JSON(fiber.Map{"error": err.Error()})
+	if handled, err := h.checkQuota(ctx, c, quota.Notes, quota.EmbeddingTokens); handled {
+		return err
 	}
 
+	embedCtx, usage := ai.TrackUsage(ctx)
+	defer h.quotas.Record(ctx, requestOwner(c), usage)
+
 	// Generate embedding (mock or real based on env)
-	vectorStr, err := ai.GetEmbeddingAsVectorLiteral(ctx, n.Content)
+	embedding, err := h.embedder.Embed(embedCtx, n.Content)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).
 			JSON(fiber.Map{"error": "failed to generate embedding"})
 	}
 
-	// Insert vector
-	_, err = database.Pool.Exec(ctx,
-		`INSERT INTO note_embeddings (note_id, embedding)
-		 VALUES ($1, $2::vector)`,
-		n.ID, vectorStr)
+	// Insert the note and its vector together, owned by the caller's tenant
+	created, err := h.notes.CreateNote(ctx, requestOwner(c), n.Title, n.Content, embedding)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).
-			JSON(fiber.Map{"error": "failed to insert embedding"})
+			JSON(fiber.Map{"error": "failed to create note"})
 	}
 
-	return c.Status(http.StatusCreated).JSON(n)
+	h.audit(c, database.AuditCreate, "note", auditIDs(created.ID), nil)
+
+	return c.Status(http.StatusCreated).JSON(created)
 }
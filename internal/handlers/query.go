@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -10,16 +11,26 @@ import (
 
 	"notes-memory-core-rag/internal/ai"
 	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
 )
 
+// semanticSearchResults is how many notes POST /search returns
+const semanticSearchResults = 5
+
 // QueryRequest represents a semantic search or RAG request.
 type QueryRequest struct {
-	Query          string  `json:"query"`
-	IdempotencyKey *string `json:"idempotency_key,omitempty"` // Optional client key
+	Query             string     `json:"query"`
+	IdempotencyKey    *string    `json:"idempotency_key,omitempty"`    // Optional client key, or the Idempotency-Key header
+	IdempotencyWindow string     `json:"idempotency_window,omitempty"` // Optional dedupe window for async jobs, e.g. "1h"
+	CallbackURL       *string    `json:"callback_url,omitempty"`       // Optional webhook for async jobs
+	RunAt             *time.Time `json:"run_at,omitempty"`             // Optional start time for async jobs
+	Delay             string     `json:"delay,omitempty"`              // Optional delay for async jobs, e.g. "10m"
+	Queue             string     `json:"queue,omitempty"`              // Optional named queue for async jobs
+	Priority          int        `json:"priority,omitempty"`           // Optional priority within the queue
 }
 
-// SemanticSearch performs vector similarity search using pgvector.
-func SemanticSearch(c *fiber.Ctx) error {
+// SemanticSearch performs vector similarity search over the caller's notes using pgvector.
+func (h *Handler) SemanticSearch(c *fiber.Ctx) error {
 	var req QueryRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
@@ -36,54 +47,35 @@ func SemanticSearch(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
+	owner := requestOwner(c)
+	if handled, err := h.checkQuota(ctx, c, quota.EmbeddingTokens); handled {
+		return err
+	}
+
+	ctx, usage := ai.TrackUsage(ctx)
+	defer h.quotas.Record(context.Background(), owner, usage)
+
 	// Get embedding for query (mock or real)
-	queryVec, err := ai.GetEmbeddingAsVectorLiteral(ctx, req.Query)
+	queryVec, err := h.embedder.Embed(ctx, req.Query)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": "failed to generate embedding",
 		})
 	}
 
-	// Perform similarity search (<-> operator)
-	rows, err := database.Pool.Query(ctx, `
-		SELECT n.id, n.title, n.content, n.created_at,
-		       e.embedding <-> $1::vector AS distance
-		FROM notes n
-		JOIN note_embeddings e ON n.id = e.note_id
-		ORDER BY distance ASC
-		LIMIT 5;
-	`, queryVec)
+	// Perform similarity search (<-> operator) within the caller's tenant
+	results, err := h.notes.SearchNotes(ctx, owner, queryVec, semanticSearchResults)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
-	defer rows.Close()
-
-	type SearchResult struct {
-		ID        int       `json:"id"`
-		Title     string    `json:"title"`
-		Content   string    `json:"content"`
-		CreatedAt time.Time `json:"created_at"`
-		Distance  float64   `json:"distance"`
-	}
 
-	var results []SearchResult
-	for rows.Next() {
-		var r SearchResult
-		if err := rows.Scan(
-			&r.ID,
-			&r.Title,
-			&r.Content,
-			&r.CreatedAt,
-			&r.Distance,
-		); err != nil {
-			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-				"error": err.Error(),
-			})
-		}
-		results = append(results, r)
+	ids := make([]int, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.ID)
 	}
+	h.audit(c, database.AuditSearch, "note", auditIDs(ids...), nil)
 
 	return c.JSON(fiber.Map{
 		"query":   req.Query,
@@ -92,7 +84,7 @@ func SemanticSearch(c *fiber.Ctx) error {
 }
 
 // Query performs full RAG: semantic search + AI-generated answer.
-func Query(c *fiber.Ctx) error {
+func (h *Handler) Query(c *fiber.Ctx) error {
 	var req QueryRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
@@ -106,12 +98,17 @@ func Query(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := RunRAGPipeline(context.Background(), req.Query)
+	result, err := h.rag.Run(context.Background(), requestOwner(c), req.Query)
+	if errors.Is(err, quota.ErrQuotaExceeded) {
+		return quotaExceededError(c, err)
+	}
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
+	h.audit(c, database.AuditQuery, "note", result.NoteIDs(), nil)
+
 	return c.JSON(result)
 }
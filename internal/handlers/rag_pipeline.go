@@ -4,64 +4,82 @@ import (
 	"context"
 	"notes-memory-core-rag/internal/ai"
 	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/store"
 	"time"
 )
 
-type SearchResult struct {
-	ID        int       `json:"id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	Distance  float64   `json:"distance"`
-}
+// ragContextNotes is how many of the closest notes ground an answer
+const ragContextNotes = 3
 
 type RAGResult struct {
-	Query    string         `json:"query"`
-	Response string         `json:"response"`
-	Results  []SearchResult `json:"results"`
+	Query    string               `json:"query"`
+	Response string               `json:"response"`
+	Results  []database.NoteMatch `json:"results"`
+}
+
+// NoteIDs returns the IDs of the notes the answer was grounded in, as audit target IDs
+func (r *RAGResult) NoteIDs() []string {
+	ids := make([]int, 0, len(r.Results))
+	for _, result := range r.Results {
+		ids = append(ids, result.ID)
+	}
+	return auditIDs(ids...)
+}
+
+// RAGPipeline answers queries from a tenant's notes. The API runs it for
+// POST /query and the worker for async query jobs, along with reembed jobs.
+type RAGPipeline struct {
+	notes     store.NotesStore
+	embedder  ai.Embedder
+	responder ai.Responder
+	quotas    *quota.Quotas
+}
+
+// NewRAGPipeline returns a pipeline searching notes and answering with responder
+func NewRAGPipeline(notes store.NotesStore, embedder ai.Embedder, responder ai.Responder, quotas *quota.Quotas) *RAGPipeline {
+	return &RAGPipeline{
+		notes:     notes,
+		embedder:  embedder,
+		responder: responder,
+		quotas:    quotas,
+	}
 }
 
-func RunRAGPipeline(parentCtx context.Context, query string) (*RAGResult, error) {
+// Run answers a query from the notes of one tenant (owner).
+// Fails with a quota.ExceededError once the tenant's token quotas are used up.
+func (p *RAGPipeline) Run(parentCtx context.Context, owner string, query string) (*RAGResult, error) {
 	// Enforce an upper bound for the entire pipeline
 	ctx, cancel := context.WithTimeout(parentCtx, 15*time.Second)
 	defer cancel()
 
+	if err := p.quotas.Check(ctx, owner, quota.EmbeddingTokens, quota.LLMTokens); err != nil {
+		return nil, err
+	}
+
+	// Tokens are recorded even if a later step fails, since they were spent
+	ctx, usage := ai.TrackUsage(ctx)
+	defer p.quotas.Record(context.Background(), owner, usage)
+
 	// 1. Embed text
-	queryVec, err := ai.GetEmbeddingAsVectorLiteral(ctx, query)
+	queryVec, err := p.embedder.Embed(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	// 2. Vector similarity search
-	rows, err := database.Pool.Query(ctx, `
-		SELECT n.id, n.title, n.content, n.created_at,
-			e.embedding <-> $1::vector AS distance
-		FROM notes n
-		JOIN note_embeddings e ON n.id = e.note_id
-		ORDER BY distance ASC
-		LIMIT 3;
-		`, queryVec)
+	// 2. Vector similarity search, restricted to the tenant's notes
+	results, err := p.notes.SearchNotes(ctx, owner, queryVec, ragContextNotes)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var results []SearchResult
-	var contextTexts []string
 
-	for rows.Next() {
-		var r SearchResult
-		if err := rows.Scan(&r.ID, &r.Title, &r.Content, &r.CreatedAt, &r.Distance); err != nil {
-			return nil, err
-		}
-
-		results = append(results, r)
+	contextTexts := make([]string, 0, len(results))
+	for _, r := range results {
 		contextTexts = append(contextTexts, r.Content)
-
 	}
 
 	// 3. LLM answer generation
-	aiResponse, err := ai.GenerateAIResponse(ctx, query, contextTexts)
+	aiResponse, err := p.responder.Respond(ctx, query, contextTexts)
 	if err != nil {
 		return nil, err
 	}
@@ -72,3 +90,35 @@ func RunRAGPipeline(parentCtx context.Context, query string) (*RAGResult, error)
 		Results:  results,
 	}, nil
 }
+
+// Reembed embeds every note of one tenant (owner) again, e.g. after the embedding
+// model changed. Fails with a quota.ExceededError if the tenant's embedding quota is used up.
+func (p *RAGPipeline) Reembed(ctx context.Context, owner string) (*ReembedResult, error) {
+	if err := p.quotas.Check(ctx, owner, quota.EmbeddingTokens); err != nil {
+		return nil, err
+	}
+
+	ctx, usage := ai.TrackUsage(ctx)
+	defer p.quotas.Record(context.Background(), owner, usage)
+
+	notes, err := p.notes.ListNotes(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReembedResult{}
+	for _, n := range notes {
+		embedding, err := p.embedder.Embed(ctx, n.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.notes.UpdateNoteEmbedding(ctx, owner, n.ID, embedding); err != nil {
+			return nil, err
+		}
+		result.Reembedded++
+		result.noteIDs = append(result.noteIDs, n.ID)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/schedule"
+)
+
+// CreateScheduleRequest defines a recurring job.
+type CreateScheduleRequest struct {
+	Name     string          `json:"name"`
+	Cron     string          `json:"cron"`
+	Type     string          `json:"type"`
+	Input    json.RawMessage `json:"input"`
+	Enabled  *bool           `json:"enabled,omitempty"`
+	Queue    string          `json:"queue,omitempty"`
+	Priority int             `json:"priority,omitempty"`
+}
+
+// CreateSchedule registers a recurring job evaluated by the worker scheduler.
+func (h *Handler) CreateSchedule(c *fiber.Ctx) error {
+	ctx := context.Background()
+
+	var req CreateScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid request body",
+		})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.Cron == "" {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "name and cron are required",
+		})
+	}
+
+	if req.Type == "" {
+		req.Type = "query"
+	}
+	if err := validateScheduleInput(req.Type, req.Input); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := validateQueueOptions(req.Queue, req.Priority); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if req.Queue == "" {
+		req.Queue = database.DefaultQueue
+	}
+
+	cron, err := schedule.Parse(req.Cron)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid cron expression: " + err.Error(),
+		})
+	}
+
+	nextRunAt := cron.Next(time.Now().UTC())
+	if nextRunAt.IsZero() {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "cron expression never fires",
+		})
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	created, err := h.db.CreateSchedule(ctx, database.JobSchedule{
+		Name:      req.Name,
+		Cron:      req.Cron,
+		Type:      req.Type,
+		Input:     req.Input,
+		Enabled:   enabled,
+		Queue:     req.Queue,
+		Priority:  req.Priority,
+		Owner:     database.NullOwner(requestOwner(c)),
+		NextRunAt: nextRunAt,
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return c.Status(http.StatusConflict).JSON(fiber.Map{
+				"error": "a schedule with this name already exists",
+			})
+		}
+
+		log.Error().Err(err).Msg("failed to create schedule")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to create schedule",
+		})
+	}
+
+	h.audit(c, database.AuditCreate, "schedule", auditIDs(created.ID), nil)
+
+	return c.Status(http.StatusCreated).JSON(created)
+}
+
+// validateScheduleInput checks a schedule's input against the job type it runs,
+// so a bad schedule is rejected now rather than failing on every run
+func validateScheduleInput(jobType string, input json.RawMessage) error {
+	switch jobType {
+	case "query":
+		var req QueryRequest
+		if err := json.Unmarshal(input, &req); err != nil || strings.TrimSpace(req.Query) == "" {
+			return errors.New("input.query is required for query schedules")
+		}
+		return nil
+
+	case "reembed":
+		return nil
+
+	case "cleanup":
+		var req CleanupRequest
+		if err := json.Unmarshal(input, &req); err != nil {
+			return errors.New("invalid input for cleanup schedules")
+		}
+		if _, err := req.RetentionRules(""); err != nil {
+			return fmt.Errorf("invalid input for cleanup schedules: %w", err)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported schedule type %q: expected query, reembed or cleanup", jobType)
+	}
+}
+
+// ListSchedules returns the recurring job schedules of the caller's tenant.
+func (h *Handler) ListSchedules(c *fiber.Ctx) error {
+	schedules, err := h.db.ListSchedules(context.Background(), requestOwner(c))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list schedules")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list schedules",
+		})
+	}
+
+	return c.JSON(schedules)
+}
+
+// DeleteSchedule removes a recurring job schedule.
+func (h *Handler) DeleteSchedule(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid schedule id",
+		})
+	}
+
+	deleted, err := h.db.DeleteSchedule(context.Background(), id, requestOwner(c))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete schedule")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to delete schedule",
+		})
+	}
+
+	if !deleted {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{
+			"error": "schedule not found",
+		})
+	}
+
+	h.audit(c, database.AuditDelete, "schedule", auditIDs(id), nil)
+
+	return c.SendStatus(http.StatusNoContent)
+}
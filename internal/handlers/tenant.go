@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// requestOwner returns the tenant of the caller, set by the authentication
+// middleware in c.Locals("owner"). Notes, jobs and schedules are scoped to it.
+// Empty when authentication is disabled.
+func requestOwner(c *fiber.Ctx) string {
+	owner, _ := c.Locals("owner").(string)
+	return owner
+}
+
+// getOwnedJob fetches a job of the caller's tenant. Jobs of other tenants are
+// reported as pgx.ErrNoRows, so their IDs can't be probed.
+func (h *Handler) getOwnedJob(ctx context.Context, c *fiber.Ctx, jobID string) (*database.Job, error) {
+	job, err := h.jobs.GetJobByID(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ownsJob(c, job) {
+		return nil, pgx.ErrNoRows
+	}
+
+	return job, nil
+}
+
+// ownsJob reports whether the caller's tenant owns a job
+func ownsJob(c *fiber.Ctx, job *database.Job) bool {
+	owner := requestOwner(c)
+	if job.Owner == nil {
+		return owner == ""
+	}
+	return *job.Owner == owner
+}
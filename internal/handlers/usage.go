@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/quota"
+)
+
+// GetUsage returns the caller's tenant usage for the current month against its quotas.
+func (h *Handler) GetUsage(c *fiber.Ctx) error {
+	report, err := h.quotas.GetReport(context.Background(), requestOwner(c))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch usage")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to fetch usage",
+		})
+	}
+
+	return c.JSON(report)
+}
+
+// quotaExceededError responds to an exhausted quota. Monthly token quotas refill,
+// so they get 429 with Retry-After; the note count only frees up when notes are
+// removed or the quota is raised, so it gets 402.
+func quotaExceededError(c *fiber.Ctx, err error) error {
+	var exceeded *quota.ExceededError
+	if !errors.As(err, &exceeded) {
+		return c.Status(http.StatusPaymentRequired).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	body := fiber.Map{
+		"error":    "quota exceeded",
+		"resource": exceeded.Resource,
+		"limit":    exceeded.Limit,
+		"used":     exceeded.Used,
+	}
+
+	if exceeded.ResetsAt == nil {
+		return c.Status(http.StatusPaymentRequired).JSON(body)
+	}
+
+	body["resets_at"] = exceeded.ResetsAt
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(time.Until(*exceeded.ResetsAt).Seconds())+1))
+	return c.Status(http.StatusTooManyRequests).JSON(body)
+}
+
+// checkQuota responds with quotaExceededError or 500 if the tenant can't use the
+// given resources. Returns handled=false when the request may proceed.
+func (h *Handler) checkQuota(ctx context.Context, c *fiber.Ctx, resources ...quota.Resource) (handled bool, err error) {
+	if err := h.quotas.Check(ctx, requestOwner(c), resources...); err != nil {
+		if errors.Is(err, quota.ErrQuotaExceeded) {
+			return true, quotaExceededError(c, err)
+		}
+
+		log.Error().Err(err).Msg("failed to check quota")
+		return true, c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to check quota",
+		})
+	}
+
+	return false, nil
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// ListWorkers reports every registered worker with its latest heartbeat.
+// Workers that haven't sent a heartbeat within database.WorkerDeadAfter are marked dead.
+func (h *Handler) ListWorkers(c *fiber.Ctx) error {
+	workers, err := h.db.ListWorkers(context.Background())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list workers")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "failed to list workers",
+		})
+	}
+
+	live := 0
+	for _, w := range workers {
+		if w.Live {
+			live++
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"workers": workers,
+		"live":    live,
+		"dead":    len(workers) - live,
+	})
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+)
+
+// publicPaths are reachable without credentials
+var publicPaths = map[string]bool{
+	"/health": true,
+}
+
+// Auth requires every request except publicPaths to carry credentials, sent as
+// "Authorization: Bearer <credential>" or "X-API-Key: <key>", and stores the resolved
+// principal on the request. cfg.Mode (AUTH_MODE) selects what is accepted:
+//   - apikey (default): API keys only
+//   - jwt: JWT bearer tokens from the identity provider, plus API keys for services
+//   - none: authentication is disabled, for local development
+func Auth(cfg config.Auth, db *database.DB) fiber.Handler {
+	mode := cfg.Mode
+	if mode == "none" {
+		log.Warn().Msg("⚠️ AUTH_MODE=none: authentication is disabled, every route is public")
+
+		// Anonymous callers act as admins of the unowned tenant
+		return func(c *fiber.Ctx) error {
+			auth.SetPrincipal(c, &auth.Principal{
+				Subject: "anonymous",
+				Method:  "none",
+				Role:    auth.RoleAdmin,
+			})
+			return c.Next()
+		}
+	}
+
+	var verifier *auth.JWTVerifier
+	switch mode {
+	case "", "apikey":
+	case "jwt":
+		jwtCfg, err := auth.NewJWTConfig(cfg.JWT)
+		if err != nil {
+			log.Fatal().Err(err).Msg("❌ Invalid JWT configuration")
+		}
+		verifier = auth.NewJWTVerifier(jwtCfg)
+		log.Info().
+			Str("jwks", jwtCfg.JWKSURL+jwtCfg.JWKSFile).
+			Str("tenant_claim", jwtCfg.TenantClaim).
+			Msg("🔐 JWT bearer authentication enabled")
+	default:
+		log.Fatal().Str("mode", mode).Msg("❌ Unknown AUTH_MODE, expected apikey, jwt or none")
+	}
+
+	return func(c *fiber.Ctx) error {
+		if publicPaths[c.Path()] || c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+
+		credential := credentials(c)
+		if credential == "" {
+			if verifier != nil {
+				return unauthorized(c, "missing bearer token or API key")
+			}
+			return unauthorized(c, "missing API key")
+		}
+
+		// API keys never contain dots, so anything shaped like a JWT is a token
+		if verifier != nil && auth.LooksLikeJWT(credential) {
+			return authenticateJWT(c, verifier, credential)
+		}
+
+		return authenticateAPIKey(c, db, credential)
+	}
+}
+
+// authenticateAPIKey resolves an API key to its principal
+func authenticateAPIKey(c *fiber.Ctx, db *database.DB, key string) error {
+	ctx := context.Background()
+	principal, err := auth.Authenticate(ctx, db, key)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return unauthorized(c, "invalid API key")
+		}
+
+		log.Error().Err(err).Msg("failed to authenticate API key")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "authentication failed",
+		})
+	}
+
+	if err := db.TouchAPIKey(ctx, principal.KeyID); err != nil {
+		log.Warn().Err(err).Int("key_id", principal.KeyID).Msg("failed to record API key use")
+	}
+
+	auth.SetPrincipal(c, principal)
+	return c.Next()
+}
+
+// authenticateJWT validates a bearer token against the identity provider's keys
+func authenticateJWT(c *fiber.Ctx, verifier *auth.JWTVerifier, token string) error {
+	principal, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidToken) {
+			log.Debug().Err(err).Msg("rejected bearer token")
+			return unauthorized(c, "invalid or expired token")
+		}
+
+		log.Error().Err(err).Msg("failed to load JWKS")
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "authentication is temporarily unavailable",
+		})
+	}
+
+	auth.SetPrincipal(c, principal)
+	return c.Next()
+}
+
+// Require rejects callers whose role does not grant perm with 403 Forbidden.
+// Routes declare their permission when they are registered.
+func Require(perm auth.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal := auth.FromContext(c)
+		if !principal.Can(perm) {
+			var role auth.Role
+			if principal != nil {
+				role = principal.Role
+			}
+
+			return c.Status(http.StatusForbidden).JSON(fiber.Map{
+				"error":    "insufficient permissions",
+				"required": perm,
+				"role":     role,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// credentials returns the API key or bearer token presented by the client
+func credentials(c *fiber.Ctx) string {
+	if header := c.Get(fiber.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return strings.TrimSpace(c.Get("X-API-Key"))
+}
+
+// unauthorized responds 401 with a Bearer challenge
+func unauthorized(c *fiber.Ctx, msg string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="notes-rag"`)
+	return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+		"error": msg,
+	})
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/store"
+)
+
+const (
+	// maxStoredResponseBytes skips storing unusually large responses
+	maxStoredResponseBytes = 1 << 20
+
+	// idempotencyKeyCleanupInterval is how often expired keys are deleted
+	idempotencyKeyCleanupInterval = time.Hour
+
+	// idempotencyLockLease is how long a key stays reserved while its request runs.
+	// A crashed request frees the key after this instead of after the full KeyTTL.
+	idempotencyLockLease = 2 * time.Minute
+)
+
+// Idempotency makes mutating endpoints safe to retry. When a request carries an
+// Idempotency-Key header, its response is stored in Postgres and replayed for
+// retries with the same key. Reusing a key with a different method, path or body
+// returns 422, and retrying while the first request is still running returns 409.
+// Responses are replayed for cfg.KeyTTL (IDEMPOTENCY_KEY_TTL); until a response
+// is stored, the key is only held for idempotencyLockLease.
+// Requests without the header are passed through.
+func Idempotency(cfg config.Idempotency, keys store.IdempotencyStore) fiber.Handler {
+	ttl := cfg.KeyTTL
+
+	go cleanupIdempotencyKeys(keys)
+
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get("Idempotency-Key"))
+		if key == "" {
+			return c.Next()
+		}
+
+		if len(key) > 255 {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "idempotency key must be at most 255 characters",
+			})
+		}
+
+		ctx := context.Background()
+
+		// Keys are scoped to the authenticated tenant, if any
+		scope, _ := c.Locals("owner").(string)
+		requestHash := hashIdempotentRequest(c)
+
+		token, existing, err := keys.ReserveIdempotencyKey(ctx, scope, key, requestHash, idempotencyLockLease)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to reserve idempotency key")
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+				"error": "idempotency check failed",
+			})
+		}
+
+		if existing != nil {
+			return replayIdempotent(c, existing, requestHash)
+		}
+
+		err = c.Next()
+
+		// Only successful and client-error responses are final; let the client retry the rest
+		status := c.Response().StatusCode()
+		body := c.Response().Body()
+		if err != nil || retryableStatus(status) || len(body) > maxStoredResponseBytes {
+			if releaseErr := keys.ReleaseIdempotencyKey(ctx, scope, key, token); releaseErr != nil {
+				log.Warn().Err(releaseErr).Msg("failed to release idempotency key")
+			}
+			return err
+		}
+
+		if saveErr := keys.SaveIdempotentResponse(ctx, scope, key, token, database.StoredResponse{
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), body...),
+		}, ttl); saveErr != nil {
+			log.Warn().Err(saveErr).Msg("failed to store idempotent response")
+		}
+
+		return nil
+	}
+}
+
+// retryableStatus reports whether a response is transient, so retrying the same
+// request may succeed: timeouts, conflicts, rate limits and server errors
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
+
+// hashIdempotentRequest fingerprints the method, path and body of a request
+func hashIdempotentRequest(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Path()))
+	h.Write([]byte{0})
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayIdempotent answers a retry from the stored response, or rejects it
+func replayIdempotent(c *fiber.Ctx, existing *database.IdempotencyRecord, requestHash string) error {
+	if existing.RequestHash != requestHash {
+		return c.Status(http.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "idempotency key was already used with a different request",
+		})
+	}
+
+	if existing.Response == nil {
+		return c.Status(http.StatusConflict).JSON(fiber.Map{
+			"error": "a request with this idempotency key is still in progress",
+		})
+	}
+
+	c.Set("Idempotent-Replayed", "true")
+	if existing.Response.ContentType != "" {
+		c.Set(fiber.HeaderContentType, existing.Response.ContentType)
+	}
+	return c.Status(existing.Response.StatusCode).Send(existing.Response.Body)
+}
+
+// cleanupIdempotencyKeys periodically deletes keys whose replay window has passed
+func cleanupIdempotencyKeys(keys store.IdempotencyStore) {
+	ticker := time.NewTicker(idempotencyKeyCleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := keys.DeleteExpiredIdempotencyKeys(context.Background())
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to delete expired idempotency keys")
+			continue
+		}
+		if deleted > 0 {
+			log.Info().Int64("keys", deleted).Msg("🧹 Deleted expired idempotency keys")
+		}
+	}
+}
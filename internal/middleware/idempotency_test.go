@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/store/memory"
+)
+
+// idempotentRequest sends POST /work with an Idempotency-Key
+func idempotentRequest(t *testing.T, app *fiber.App, key string, body string) (int, string, http.Header) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/work", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(raw), resp.Header
+}
+
+func TestIdempotencyReplaysResponses(t *testing.T) {
+	var calls atomic.Int32
+	app := fiber.New()
+	app.Use(Idempotency(config.Idempotency{KeyTTL: time.Hour}, memory.New()))
+	app.Post("/work", func(c *fiber.Ctx) error {
+		calls.Add(1)
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"id": 1})
+	})
+
+	status, body, _ := idempotentRequest(t, app, "k1", `{"n": 1}`)
+	if status != http.StatusCreated {
+		t.Fatalf("status %d, want %d", status, http.StatusCreated)
+	}
+
+	replayStatus, replayBody, header := idempotentRequest(t, app, "k1", `{"n": 1}`)
+	if replayStatus != status || replayBody != body || header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry: status %d, body %s; want the stored %d %s replayed", replayStatus, replayBody, status, body)
+	}
+
+	if status, _, _ := idempotentRequest(t, app, "k1", `{"n": 2}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("key reused with another body: status %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want once", n)
+	}
+}
+
+func TestIdempotencyReleasesRetryableResponses(t *testing.T) {
+	var calls atomic.Int32
+	app := fiber.New()
+	app.Use(Idempotency(config.Idempotency{KeyTTL: time.Hour}, memory.New()))
+	app.Post("/work", func(c *fiber.Ctx) error {
+		if calls.Add(1) == 1 {
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "slow down"})
+		}
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"id": 1})
+	})
+
+	if status, _, _ := idempotentRequest(t, app, "k1", `{}`); status != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want %d", status, http.StatusTooManyRequests)
+	}
+
+	// The 429 isn't stored, so the retry runs the handler
+	if status, _, _ := idempotentRequest(t, app, "k1", `{}`); status != http.StatusCreated {
+		t.Fatalf("retry: status %d, want %d", status, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyStaleReleaseKeepsNewReservation(t *testing.T) {
+	keys := memory.New()
+
+	var calls atomic.Int32
+	started := make(chan struct{}, 2)
+	releaseFirst := make(chan struct{})
+	releaseSecond := make(chan struct{})
+
+	app := fiber.New()
+	app.Use(Idempotency(config.Idempotency{KeyTTL: time.Hour}, keys))
+	app.Post("/work", func(c *fiber.Ctx) error {
+		switch calls.Add(1) {
+		case 1:
+			started <- struct{}{}
+			<-releaseFirst
+			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "upstream failed"})
+		case 2:
+			started <- struct{}{}
+			<-releaseSecond
+			return c.Status(http.StatusCreated).JSON(fiber.Map{"id": 2})
+		default:
+			return c.Status(http.StatusCreated).JSON(fiber.Map{"id": 3})
+		}
+	})
+
+	first := make(chan int)
+	go func() {
+		status, _, _ := idempotentRequest(t, app, "k1", `{}`)
+		first <- status
+	}()
+	<-started
+
+	// The first request outlives its lease and a retry takes the key
+	keys.ExpireIdempotencyKey("", "k1")
+
+	second := make(chan int)
+	go func() {
+		status, _, _ := idempotentRequest(t, app, "k1", `{}`)
+		second <- status
+	}()
+	<-started
+
+	// The first request fails and releases its reservation, which is no longer the key's
+	close(releaseFirst)
+	if status := <-first; status != http.StatusInternalServerError {
+		t.Fatalf("first request: status %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	// The second request still holds the key
+	if status, _, _ := idempotentRequest(t, app, "k1", `{}`); status != http.StatusConflict {
+		t.Fatalf("third request: status %d, want %d while the second runs", status, http.StatusConflict)
+	}
+
+	close(releaseSecond)
+	if status := <-second; status != http.StatusCreated {
+		t.Fatalf("second request: status %d, want %d", status, http.StatusCreated)
+	}
+
+	status, _, header := idempotentRequest(t, app, "k1", `{}`)
+	if status != http.StatusCreated || header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("fourth request: status %d, want the second response replayed", status)
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler ran %d times, want twice", n)
+	}
+}
@@ -15,10 +15,12 @@ func LoggerMiddleware(c *fiber.Ctx) error {
 	err := c.Next()
 
 	duration := time.Since(start)
+	requestID, _ := c.Locals("requestid").(string)
 
 	log.Info().
 		Str("method", c.Method()).
 		Str("path", c.Path()).
+		Str("request_id", requestID).
 		Int("status", c.Response().StatusCode()).
 		Dur("duration_ms", duration).
 		Msg("request handled")
@@ -1,19 +1,195 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
-	"github.com/gofiber/storage/memory"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
 )
 
-func RateLimit() fiber.Handler {
-	store := memory.New()
+// RateLimiter creates per-route rate limiters. Every caller (API key, token user,
+// or client IP when authentication is disabled) gets a budget of RATE_LIMIT_BUDGET
+// cost points per RATE_LIMIT_WINDOW, shared by all routes; each route spends the
+// cost declared at registration, so LLM calls use the budget up faster than CRUD.
+// Budgets live in Redis so they are shared across instances and survive restarts,
+// with an in-memory fallback when Redis is unavailable.
+// Its limiters must run after Auth, which identifies the caller.
+type RateLimiter struct {
+	budget   int
+	window   time.Duration
+	redis    *database.Redis
+	fallback *memoryRateLimiter
+	policy   string
+}
+
+// RateLimit returns the rate limiter of cfg
+func RateLimit(cfg config.RateLimit, redis *database.Redis) *RateLimiter {
+	return &RateLimiter{
+		budget:   cfg.Budget,
+		window:   cfg.Window,
+		redis:    redis,
+		fallback: newMemoryRateLimiter(),
+		policy:   fmt.Sprintf("%d;w=%d", cfg.Budget, int(cfg.Window.Seconds())),
+	}
+}
+
+// Cost returns a limiter spending a fixed cost per request
+func (l *RateLimiter) Cost(cost int) fiber.Handler {
+	// A route costing more than the whole budget could never be called
+	if cost > l.budget {
+		log.Warn().Int("cost", cost).Int("budget", l.budget).Msg("route cost exceeds RATE_LIMIT_BUDGET, capping it")
+	}
+
+	return l.CostFunc(func(*fiber.Ctx) int { return cost })
+}
+
+// CostFunc returns a limiter spending costOf(request) per request, for routes
+// whose cost depends on the request, e.g. on how many items it submits.
+// Costs above the budget are capped at it.
+func (l *RateLimiter) CostFunc(costOf func(c *fiber.Ctx) int) fiber.Handler {
+	budget, window, fallback, policy := l.budget, l.window, l.fallback, l.policy
+
+	return func(c *fiber.Ctx) error {
+		cost := min(costOf(c), budget)
+		subject := rateLimitSubject(c)
+
+		allowed, used, err := takeRateLimit(l.redis, subject, cost, budget, window, fallback)
+		if err != nil {
+			log.Warn().Err(err).Msg("Redis rate limiting failed, using in-memory limits")
+			allowed, used = fallback.take(subject, cost, budget, window)
+		}
+
+		reset := time.Until(time.Now().Truncate(window).Add(window))
+		resetSeconds := int(reset.Seconds() + 0.999)
+
+		c.Set("RateLimit-Policy", policy)
+		c.Set("RateLimit-Limit", strconv.Itoa(budget))
+		c.Set("RateLimit-Remaining", strconv.Itoa(max(budget-used, 0)))
+		c.Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if !allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(resetSeconds))
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "rate limit exceeded",
+				"cost":        cost,
+				"retry_after": resetSeconds,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// IPRateLimit limits requests per client IP before Auth, so floods of invalid API
+// keys or tokens can't hammer key lookups and token verification. Each request
+// costs 1 of RATE_LIMIT_IP_BUDGET per RATE_LIMIT_WINDOW; publicPaths are exempt.
+// Authenticated callers are limited by RateLimit afterwards.
+func IPRateLimit(cfg config.RateLimit, redis *database.Redis) fiber.Handler {
+	budget, window := cfg.IPBudget, cfg.Window
+	fallback := newMemoryRateLimiter()
+
+	return func(c *fiber.Ctx) error {
+		if publicPaths[c.Path()] {
+			return c.Next()
+		}
+
+		subject := "preauth:ip:" + c.IP()
+
+		allowed, _, err := takeRateLimit(redis, subject, 1, budget, window, fallback)
+		if err != nil {
+			log.Warn().Err(err).Msg("Redis rate limiting failed, using in-memory limits")
+			allowed, _ = fallback.take(subject, 1, budget, window)
+		}
+
+		if !allowed {
+			reset := time.Until(time.Now().Truncate(window).Add(window))
+			resetSeconds := int(reset.Seconds() + 0.999)
+
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(resetSeconds))
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{
+				"error":       "too many requests from this address",
+				"retry_after": resetSeconds,
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// takeRateLimit spends from the caller's budget in Redis, or in memory without Redis
+func takeRateLimit(redis *database.Redis, subject string, cost int, budget int, window time.Duration, fallback *memoryRateLimiter) (bool, int, error) {
+	if redis == nil {
+		allowed, used := fallback.take(subject, cost, budget, window)
+		return allowed, used, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	return redis.TakeRateLimit(ctx, subject, cost, budget, window)
+}
+
+// rateLimitSubject identifies whose budget a request spends
+func rateLimitSubject(c *fiber.Ctx) string {
+	principal := auth.FromContext(c)
+	switch {
+	case principal == nil || principal.Method == "none":
+		return "ip:" + c.IP()
+	case principal.KeyID != 0:
+		return "key:" + strconv.Itoa(principal.KeyID)
+	default:
+		return principal.Method + ":" + principal.Owner + ":" + principal.Subject
+	}
+}
+
+// memoryRateLimiter keeps per-instance budgets when Redis is unavailable
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateLimitWindow
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	used  int
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{windows: make(map[string]*rateLimitWindow)}
+}
+
+// take spends cost from subject's budget unless that would exceed it
+func (m *memoryRateLimiter) take(subject string, cost int, budget int, window time.Duration) (bool, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := time.Now().Truncate(window)
+
+	w, ok := m.windows[subject]
+	if !ok || !w.start.Equal(start) {
+		// Drop finished windows so idle callers don't accumulate
+		for key, old := range m.windows {
+			if old.start.Before(start) {
+				delete(m.windows, key)
+			}
+		}
+
+		w = &rateLimitWindow{start: start}
+		m.windows[subject] = w
+	}
+
+	if w.used+cost > budget {
+		return false, w.used
+	}
 
-	return limiter.New(limiter.Config{
-		Max:        3,
-		Expiration: 1 * time.Minute,
-		Store:      store,
-	})
+	w.used += cost
+	return true, w.used
 }
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
+)
+
+// testKeyHeader selects the API key ID a test request is made with
+const testKeyHeader = "X-Test-Key"
+
+// newRateLimitedApp serves GET /cheap (cost 1) and POST /llm (cost 3) behind the
+// in-memory limiter, as the API key named by testKeyHeader
+func newRateLimitedApp(budget int) *fiber.App {
+	limiter := RateLimit(config.RateLimit{Budget: budget, Window: time.Hour}, nil)
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		keyID, _ := strconv.Atoi(c.Get(testKeyHeader))
+		auth.SetPrincipal(c, &auth.Principal{Method: "apikey", KeyID: keyID})
+		return c.Next()
+	})
+	app.Get("/cheap", limiter.Cost(1), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	app.Post("/llm", limiter.Cost(3), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	return app
+}
+
+// rateLimitedRequest sends a request as API key keyID
+func rateLimitedRequest(t *testing.T, app *fiber.App, method string, path string, keyID int) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(testKeyHeader, strconv.Itoa(keyID))
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimitSpendsRouteCosts(t *testing.T) {
+	app := newRateLimitedApp(5)
+
+	resp := rateLimitedRequest(t, app, http.MethodPost, "/llm", 1)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("RateLimit-Remaining") != "2" || resp.Header.Get("RateLimit-Limit") != "5" {
+		t.Fatalf("status %d, remaining %s; want 200 with 2 of 5 left", resp.StatusCode, resp.Header.Get("RateLimit-Remaining"))
+	}
+
+	// A second LLM call would overspend the budget, cheap routes still fit
+	resp = rateLimitedRequest(t, app, http.MethodPost, "/llm", 1)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Fatalf("status %d, Retry-After %q; want 429 with Retry-After", resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := rateLimitedRequest(t, app, http.MethodGet, "/cheap", 1); resp.StatusCode != http.StatusOK {
+			t.Fatalf("cheap request %d: status %d, want 200", i, resp.StatusCode)
+		}
+	}
+	if resp := rateLimitedRequest(t, app, http.MethodGet, "/cheap", 1); resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("status %d, remaining %s; want 429 once the budget is spent", resp.StatusCode, resp.Header.Get("RateLimit-Remaining"))
+	}
+
+	// Each API key has its own budget
+	if resp := rateLimitedRequest(t, app, http.MethodPost, "/llm", 2); resp.StatusCode != http.StatusOK {
+		t.Fatalf("other key: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRateLimitCapsCostAtBudget(t *testing.T) {
+	app := newRateLimitedApp(2)
+
+	// A route costing more than the budget is still callable once per window
+	if resp := rateLimitedRequest(t, app, http.MethodPost, "/llm", 1); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", resp.StatusCode)
+	}
+	if resp := rateLimitedRequest(t, app, http.MethodPost, "/llm", 1); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestIPRateLimit(t *testing.T) {
+	app := fiber.New()
+	app.Use(IPRateLimit(config.RateLimit{IPBudget: 2, Window: time.Hour}, nil))
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	app.Get("/notes", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	for i := 0; i < 2; i++ {
+		if resp := rateLimitedRequest(t, app, http.MethodGet, "/notes", 0); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, resp.StatusCode)
+		}
+	}
+	if resp := rateLimitedRequest(t, app, http.MethodGet, "/notes", 0); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429 once the address spent its budget", resp.StatusCode)
+	}
+
+	// Public paths are never limited
+	if resp := rateLimitedRequest(t, app, http.MethodGet, "/health", 0); resp.StatusCode != http.StatusOK {
+		t.Fatalf("/health: status %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
+)
+
+// CORS configures cross-origin access (CORS_* settings). Without allowed origins,
+// which is the production default, cross-origin requests are refused.
+func CORS(cfg config.CORS) fiber.Handler {
+	if len(cfg.AllowOrigins) == 0 {
+		log.Info().Msg("CORS_ALLOW_ORIGINS not set, cross-origin requests are disabled")
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	return cors.New(cors.Config{
+		AllowOrigins:     strings.Join(cfg.AllowOrigins, ","),
+		AllowCredentials: cfg.AllowCredentials,
+		AllowMethods:     strings.Join(cfg.AllowMethods, ","),
+		AllowHeaders:     strings.Join(cfg.AllowHeaders, ","),
+		ExposeHeaders:    strings.Join(cfg.ExposeHeaders, ","),
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	})
+}
+
+// SecurityHeaders sets defensive headers on every response. The API only serves
+// JSON, so content may not be framed, sniffed or load anything. HSTS is only sent
+// when cfg.HSTSMaxAge is set, by default in production only, since browsers would
+// otherwise pin plain-HTTP local setups to HTTPS.
+func SecurityHeaders(cfg config.Server) fiber.Handler {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		c.Set(fiber.HeaderXFrameOptions, "DENY")
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+		c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; frame-ancestors 'none'")
+		c.Set("Cross-Origin-Resource-Policy", "same-origin")
+		if hsts != "" {
+			c.Set(fiber.HeaderStrictTransportSecurity, hsts)
+		}
+		return c.Next()
+	}
+}
+
+// ErrorHandler answers errors that escape the handlers, such as unknown routes or
+// bodies over the size limit, in the same {"error": ...} shape the handlers use.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	code := http.StatusInternalServerError
+	msg := "internal server error"
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		code = fiberErr.Code
+		msg = strings.ToLower(fiberErr.Message)
+	} else {
+		log.Error().Err(err).Str("path", c.Path()).Msg("unhandled error")
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"error": msg,
+	})
+}
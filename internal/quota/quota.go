@@ -0,0 +1,233 @@
+// Package quota enforces per-tenant monthly limits on LLM and embedding tokens
+// and a cap on the number of notes.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/store"
+)
+
+// Resource is something a tenant's quota limits
+type Resource string
+
+const (
+	LLMTokens       Resource = "llm_tokens"
+	EmbeddingTokens Resource = "embedding_tokens"
+	Notes           Resource = "notes"
+)
+
+// ErrQuotaExceeded is matched by every *ExceededError
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ExceededError reports which quota ran out
+type ExceededError struct {
+	Resource Resource
+	Limit    int64
+	Used     int64
+
+	// ResetsAt is when a monthly quota refills; nil for the note count
+	ResetsAt *time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded (%d of %d used)", e.Resource, e.Used, e.Limit)
+}
+
+func (e *ExceededError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// Limits are a tenant's quotas. Zero means unlimited.
+type Limits struct {
+	LLMTokens       int64 `json:"llm_tokens"`
+	EmbeddingTokens int64 `json:"embedding_tokens"`
+	Notes           int64 `json:"notes"`
+}
+
+// Quotas checks and records tenant usage against the default quotas and the
+// per-tenant overrides held by a store.UsageStore
+type Quotas struct {
+	// defaults apply to tenants without tenant_quotas overrides
+	defaults Limits
+	usage    store.UsageStore
+}
+
+// New returns the quotas with the defaults QUOTA_LLM_TOKENS and
+// QUOTA_EMBEDDING_TOKENS per month, and QUOTA_NOTES
+func New(cfg config.Quota, usage store.UsageStore) *Quotas {
+	return &Quotas{
+		defaults: Limits{
+			LLMTokens:       cfg.LLMTokens,
+			EmbeddingTokens: cfg.EmbeddingTokens,
+			Notes:           cfg.Notes,
+		},
+		usage: usage,
+	}
+}
+
+// DefaultLimits returns the quotas of tenants without overrides
+func (q *Quotas) DefaultLimits() Limits {
+	return q.defaults
+}
+
+// LimitsFor returns a tenant's quotas: its tenant_quotas overrides, else the defaults
+func (q *Quotas) LimitsFor(ctx context.Context, owner string) (Limits, error) {
+	limits := q.DefaultLimits()
+
+	override, err := q.usage.GetTenantQuota(ctx, owner)
+	if err != nil || override == nil {
+		return limits, err
+	}
+
+	if override.LLMTokens != nil {
+		limits.LLMTokens = *override.LLMTokens
+	}
+	if override.EmbeddingTokens != nil {
+		limits.EmbeddingTokens = *override.EmbeddingTokens
+	}
+	if override.Notes != nil {
+		limits.Notes = *override.Notes
+	}
+
+	return limits, nil
+}
+
+// PeriodStart returns the start of the monthly usage period containing t (UTC)
+func PeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// periodEnd returns when the period starting at start ends and quotas refill
+func periodEnd(start time.Time) time.Time {
+	return start.AddDate(0, 1, 0)
+}
+
+// Check returns an *ExceededError if any of the given resources is used up.
+// Token usage is only known after a call, so it is checked before each call and
+// the last call of a period may overshoot the quota by its own usage.
+func (q *Quotas) Check(ctx context.Context, owner string, resources ...Resource) error {
+	limits, err := q.LimitsFor(ctx, owner)
+	if err != nil {
+		return err
+	}
+
+	start := PeriodStart(time.Now())
+	resetsAt := periodEnd(start)
+
+	var usage *database.TenantUsage
+	for _, resource := range resources {
+		var limit, used int64
+
+		switch resource {
+		case LLMTokens, EmbeddingTokens:
+			limit = limits.EmbeddingTokens
+			if resource == LLMTokens {
+				limit = limits.LLMTokens
+			}
+			if limit == 0 {
+				continue
+			}
+
+			// One read covers both token quotas
+			if usage == nil {
+				usage, err = q.usage.GetTenantUsage(ctx, owner, start)
+				if err != nil {
+					return err
+				}
+			}
+
+			used = usage.EmbeddingTokens
+			if resource == LLMTokens {
+				used = usage.LLMTokens
+			}
+
+			if used >= limit {
+				return &ExceededError{Resource: resource, Limit: limit, Used: used, ResetsAt: &resetsAt}
+			}
+
+		case Notes:
+			limit = limits.Notes
+			if limit == 0 {
+				continue
+			}
+
+			used, err = q.usage.CountNotes(ctx, owner)
+			if err != nil {
+				return err
+			}
+
+			if used >= limit {
+				return &ExceededError{Resource: resource, Limit: limit, Used: used}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Record adds the tokens counted by usage to the tenant's current period.
+// Failures are logged rather than failing a request whose AI calls already ran.
+func (q *Quotas) Record(ctx context.Context, owner string, usage *ai.Usage) {
+	llmTokens, embeddingTokens := usage.Tokens()
+	if llmTokens == 0 && embeddingTokens == 0 {
+		return
+	}
+
+	if err := q.usage.AddTenantUsage(ctx, owner, PeriodStart(time.Now()), llmTokens, embeddingTokens); err != nil {
+		log.Warn().Err(err).Str("owner", owner).Msg("failed to record tenant usage")
+	}
+}
+
+// ResourceUsage is the use of one quota
+type ResourceUsage struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"` // 0 means unlimited
+}
+
+// Report is a tenant's current usage against its quotas
+type Report struct {
+	PeriodStart     time.Time     `json:"period_start"`
+	ResetsAt        time.Time     `json:"resets_at"`
+	LLMTokens       ResourceUsage `json:"llm_tokens"`
+	EmbeddingTokens ResourceUsage `json:"embedding_tokens"`
+	Notes           ResourceUsage `json:"notes"`
+	LLMCalls        int64         `json:"llm_calls"`
+	EmbeddingCalls  int64         `json:"embedding_calls"`
+}
+
+// GetReport returns a tenant's usage for the current period
+func (q *Quotas) GetReport(ctx context.Context, owner string) (*Report, error) {
+	limits, err := q.LimitsFor(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	start := PeriodStart(time.Now())
+	usage, err := q.usage.GetTenantUsage(ctx, owner, start)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := q.usage.CountNotes(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		PeriodStart:     start,
+		ResetsAt:        periodEnd(start),
+		LLMTokens:       ResourceUsage{Used: usage.LLMTokens, Limit: limits.LLMTokens},
+		EmbeddingTokens: ResourceUsage{Used: usage.EmbeddingTokens, Limit: limits.EmbeddingTokens},
+		Notes:           ResourceUsage{Used: notes, Limit: limits.Notes},
+		LLMCalls:        usage.LLMCalls,
+		EmbeddingCalls:  usage.EmbeddingCalls,
+	}, nil
+}
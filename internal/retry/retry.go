@@ -0,0 +1,183 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/quota"
+)
+
+// ---------------------------
+//  ERROR CLASSIFICATION
+// ---------------------------
+
+// permanentError marks an error that must not be retried (bad input, config errors).
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so Classify reports it as non-retryable.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Classify reports whether err is worth retrying and how long the upstream
+// asked us to wait (0 if it didn't say). Unknown errors are treated as retryable.
+func Classify(err error) (retryable bool, retryAfter time.Duration) {
+	var after interface{ RetryAfter() time.Duration }
+	if errors.As(err, &after) {
+		retryAfter = after.RetryAfter()
+	}
+
+	var permanent *permanentError
+	// An exhausted quota only refills next month
+	if errors.As(err, &permanent) || errors.Is(err, ai.ErrMissingAPIKey) || errors.Is(err, quota.ErrQuotaExceeded) {
+		return false, 0
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.HTTPStatusCode), retryAfter
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return retryableStatus(reqErr.HTTPStatusCode), retryAfter
+	}
+
+	// Timeouts, network and database errors are transient
+	return true, retryAfter
+}
+
+// retryableStatus treats throttling, timeouts and server errors as transient
+// and every other 4xx, including 409 Conflict, as a permanent client error.
+func retryableStatus(code int) bool {
+	switch {
+	case code == 0:
+		return true
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return true
+	case code >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// ---------------------------
+//  RETRY POLICIES
+// ---------------------------
+
+// Policy controls how often and how quickly a job type is retried.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultPolicy applies to job types without a configured policy.
+var DefaultPolicy = Policy{
+	MaxAttempts: 3,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    5 * time.Minute,
+}
+
+// Backoff returns the delay before retrying after the given failed attempt:
+// exponential from BaseDelay, capped at MaxDelay, with jitter in [d/2, d].
+func (p Policy) Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Policies maps job types to their retry policy.
+type Policies map[string]Policy
+
+// For returns the policy for a job type, falling back to DefaultPolicy.
+func (p Policies) For(jobType string) Policy {
+	if policy, ok := p[jobType]; ok {
+		return policy
+	}
+	return DefaultPolicy
+}
+
+// ParsePolicies parses JOB_RETRY_POLICIES, e.g.
+// "query:attempts=5,base=1s,max=1m;batch:attempts=2". Unset fields use DefaultPolicy.
+func ParsePolicies(raw string) (Policies, error) {
+	policies := Policies{}
+
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return policies, nil
+	}
+
+	for _, entry := range strings.Split(raw, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		jobType, settings, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(jobType) == "" {
+			return nil, fmt.Errorf("invalid retry policy %q, expected type:key=value,...", entry)
+		}
+
+		policy := DefaultPolicy
+		for _, setting := range strings.Split(settings, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid retry policy setting %q", setting)
+			}
+
+			var err error
+			switch key {
+			case "attempts":
+				policy.MaxAttempts, err = strconv.Atoi(value)
+				if err == nil && policy.MaxAttempts < 1 {
+					err = fmt.Errorf("must be at least 1")
+				}
+			case "base":
+				policy.BaseDelay, err = time.ParseDuration(value)
+			case "max":
+				policy.MaxDelay, err = time.ParseDuration(value)
+			default:
+				err = fmt.Errorf("unknown key")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid retry policy setting %q: %w", setting, err)
+			}
+		}
+
+		policies[strings.TrimSpace(jobType)] = policy
+	}
+
+	return policies, nil
+}
@@ -0,0 +1,86 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/quota"
+)
+
+func apiError(code int) error {
+	return &openai.APIError{HTTPStatusCode: code, Message: http.StatusText(code)}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantAfter     time.Duration
+	}{
+		{"permanent", Permanent(errors.New("query text is required")), false, 0},
+		{"wrapped permanent", fmt.Errorf("job: %w", Permanent(errors.New("bad input"))), false, 0},
+		{"missing API key", ai.ErrMissingAPIKey, false, 0},
+		{"quota exceeded", &quota.ExceededError{Resource: quota.LLMTokens, Limit: 10, Used: 10}, false, 0},
+
+		{"bad request", apiError(http.StatusBadRequest), false, 0},
+		{"unauthorized", apiError(http.StatusUnauthorized), false, 0},
+		{"conflict", apiError(http.StatusConflict), false, 0},
+		{"request timeout", apiError(http.StatusRequestTimeout), true, 0},
+		{"rate limited", apiError(http.StatusTooManyRequests), true, 0},
+		{"server error", apiError(http.StatusInternalServerError), true, 0},
+		{"unavailable", apiError(http.StatusServiceUnavailable), true, 0},
+		{"no status", apiError(0), true, 0},
+
+		{"request error", &openai.RequestError{HTTPStatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}, true, 0},
+		{"request error 4xx", &openai.RequestError{HTTPStatusCode: http.StatusNotFound, Err: errors.New("not found")}, false, 0},
+
+		{"rate limited with Retry-After", &ai.RetryAfterError{Err: apiError(http.StatusTooManyRequests), After: 30 * time.Second}, true, 30 * time.Second},
+		{"deadline", context.DeadlineExceeded, true, 0},
+		{"unknown", errors.New("connection reset by peer"), true, 0},
+	}
+
+	for _, tt := range tests {
+		retryable, after := Classify(tt.err)
+		if retryable != tt.wantRetryable || after != tt.wantAfter {
+			t.Errorf("%s: Classify = (%v, %s), want (%v, %s)", tt.name, retryable, after, tt.wantRetryable, tt.wantAfter)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		attempt int
+		max     time.Duration // before jitter, which takes off up to half
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := policy.Backoff(tt.attempt); d < tt.max/2 || d > tt.max {
+				t.Fatalf("Backoff(%d) = %s, want within [%s, %s]", tt.attempt, d, tt.max/2, tt.max)
+			}
+		}
+	}
+
+	// Delays too short to halve are not jittered
+	if d := (Policy{BaseDelay: time.Nanosecond, MaxDelay: time.Nanosecond}).Backoff(1); d != time.Nanosecond {
+		t.Fatalf("Backoff = %s, want %s", d, time.Nanosecond)
+	}
+}
@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@every 5m",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"a * * * *",
+		"1-x * * * *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/-5 * * * *",
+		"*/x * * * *",
+		"1,,2 * * * *",
+	}
+
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q): expected an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// A Monday
+	from := time.Date(2024, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", from, date(2024, time.January, 15, 10, 8)},
+		{"seconds are truncated", "8 10 * * *", from, date(2024, time.January, 15, 10, 8)},
+		{"strictly after", "0 10 * * *", date(2024, time.January, 15, 10, 0), date(2024, time.January, 16, 10, 0)},
+		{"surrounding whitespace", "  15 * * * *  ", from, date(2024, time.January, 15, 10, 15)},
+
+		// Steps
+		{"step", "*/15 * * * *", from, date(2024, time.January, 15, 10, 15)},
+		{"step over range", "0-30/10 * * * *", from, date(2024, time.January, 15, 10, 10)},
+		{"step from value", "5/20 * * * *", from, date(2024, time.January, 15, 10, 25)},
+		{"step past range end", "0-30/10 * * * *", date(2024, time.January, 15, 10, 31), date(2024, time.January, 15, 11, 0)},
+
+		// Ranges and lists
+		{"hour range", "0 9-17 * * *", from, date(2024, time.January, 15, 11, 0)},
+		{"hour range exhausted", "0 9-10 * * *", from, date(2024, time.January, 16, 9, 0)},
+		{"minute list", "1,15,45 * * * *", from, date(2024, time.January, 15, 10, 15)},
+		{"list of ranges", "0 1-2,20-21 * * *", from, date(2024, time.January, 15, 20, 0)},
+		{"month list", "0 0 1 1,7 *", from, date(2024, time.July, 1, 0, 0)},
+		{"weekday range", "0 9 * * 1-5", date(2024, time.January, 19, 12, 0), date(2024, time.January, 22, 9, 0)},
+
+		// Macros
+		{"hourly", "@hourly", from, date(2024, time.January, 15, 11, 0)},
+		{"daily", "@daily", from, date(2024, time.January, 16, 0, 0)},
+		{"midnight", "@midnight", from, date(2024, time.January, 16, 0, 0)},
+		{"weekly", "@weekly", from, date(2024, time.January, 21, 0, 0)},
+		{"monthly", "@monthly", from, date(2024, time.February, 1, 0, 0)},
+		{"yearly", "@yearly", from, date(2025, time.January, 1, 0, 0)},
+		{"annually", "@annually", from, date(2025, time.January, 1, 0, 0)},
+
+		// Day of month vs day of week
+		{"day of week only", "0 0 * * 5", from, date(2024, time.January, 19, 0, 0)},
+		{"7 is sunday", "0 0 * * 7", from, date(2024, time.January, 21, 0, 0)},
+		{"day of month only", "0 0 20 * *", from, date(2024, time.January, 20, 0, 0)},
+		{"both restricted, weekday first", "0 0 20 * 5", from, date(2024, time.January, 19, 0, 0)},
+		{"both restricted, day first", "0 0 16 * 5", from, date(2024, time.January, 16, 0, 0)},
+		{"starred step needs both", "0 0 */10 * 5", from, date(2024, time.March, 1, 0, 0)},
+
+		// Rollover
+		{"day rollover", "30 0 * * *", date(2024, time.January, 15, 23, 59), date(2024, time.January, 16, 0, 30)},
+		{"month rollover", "0 0 1 * *", date(2024, time.January, 31, 23, 59), date(2024, time.February, 1, 0, 0)},
+		{"year rollover", "0 0 1 * *", date(2024, time.December, 31, 23, 59), date(2025, time.January, 1, 0, 0)},
+		{"skips short months", "0 0 31 * *", date(2024, time.January, 31, 12, 0), date(2024, time.March, 31, 0, 0)},
+		{"same time next year", "30 23 31 12 *", date(2024, time.December, 31, 23, 30), date(2025, time.December, 31, 23, 30)},
+		{"leap day", "0 0 29 2 *", date(2024, time.March, 1, 0, 0), date(2028, time.February, 29, 0, 0)},
+
+		// Never fires
+		{"february 30", "0 0 30 2 *", from, time.Time{}},
+		{"april 31", "0 0 31 4 *", from, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+
+			got := cron.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%s) of %q = %s, want %s", tt.from.Format(time.RFC3339), tt.expr,
+					got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+
+	cron, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := cron.Next(time.Date(2024, time.January, 15, 10, 0, 0, 0, loc))
+	want := time.Date(2024, time.January, 16, 9, 0, 0, 0, loc)
+	if !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
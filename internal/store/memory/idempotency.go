@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+type idempotencyScopeKey struct {
+	scope string
+	key   string
+}
+
+type idempotencyKey struct {
+	requestHash string
+	token       string
+	response    *database.StoredResponse
+	expiresAt   time.Time
+}
+
+// ReserveIdempotencyKey claims key within scope until lease passes and returns the
+// reservation's token, or returns the existing record if the key is still held
+func (s *Store) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, lease time.Duration) (string, *database.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyScopeKey{scope, key}
+	if k, ok := s.idempotencyKeys[id]; ok && !k.expiresAt.Before(time.Now()) {
+		return "", &database.IdempotencyRecord{RequestHash: k.requestHash, Response: k.response}, nil
+	}
+
+	token := uuid.New().String()
+	s.idempotencyKeys[id] = &idempotencyKey{
+		requestHash: requestHash,
+		token:       token,
+		expiresAt:   time.Now().Add(lease),
+	}
+	return token, nil, nil
+}
+
+// SaveIdempotentResponse stores the response of the reservation identified by token
+// for replay until ttl passes
+func (s *Store) SaveIdempotentResponse(ctx context.Context, scope string, key string, token string, resp database.StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k := s.reservation(scope, key, token); k != nil {
+		k.response = &resp
+		k.expiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey frees the reservation identified by token
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, scope string, key string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.reservation(scope, key, token) != nil {
+		delete(s.idempotencyKeys, idempotencyScopeKey{scope, key})
+	}
+	return nil
+}
+
+// reservation returns the key if token still holds it without a stored response
+func (s *Store) reservation(scope string, key string, token string) *idempotencyKey {
+	k, ok := s.idempotencyKeys[idempotencyScopeKey{scope, key}]
+	if !ok || k.token != token || k.response != nil {
+		return nil
+	}
+	return k
+}
+
+// DeleteExpiredIdempotencyKeys removes keys whose lease or replay window has passed
+func (s *Store) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for id, k := range s.idempotencyKeys {
+		if k.expiresAt.Before(now) {
+			delete(s.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ExpireIdempotencyKey ends the lease or replay window of a key now, as if it had passed
+func (s *Store) ExpireIdempotencyKey(scope string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.idempotencyKeys[idempotencyScopeKey{scope, key}]; ok {
+		k.expiresAt = time.Now().Add(-time.Second)
+	}
+}
@@ -0,0 +1,690 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+type job struct {
+	database.Job
+	contentHash string
+}
+
+// snapshot returns a copy the caller may modify
+func (j *job) snapshot() *database.Job {
+	copied := j.Job
+	return &copied
+}
+
+// CreateJob stores a new job, 'scheduled' if it runs later and 'queued' otherwise.
+// Fails with database.ErrDuplicateJob if its client idempotency key is already held.
+func (s *Store) CreateJob(ctx context.Context, jobType string, input interface{}, contentHash string, opts database.JobOptions) (string, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.Queue == "" {
+		opts.Queue = database.DefaultQueue
+	}
+
+	status := "queued"
+	if opts.RunAt != nil && opts.RunAt.After(time.Now()) {
+		status = "scheduled"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clientKeyHeld(contentHash) {
+		return "", database.ErrDuplicateJob
+	}
+
+	j := s.insertJob(jobType, inputJSON, status, contentHash, opts)
+	j.RunAt = opts.RunAt
+	j.RequestHash = optional(opts.RequestHash)
+	return j.ID, nil
+}
+
+// CreateBatchJob stores a 'batch' parent job and one queued 'query' child per query.
+// Returns the parent ID and the child IDs in query order.
+func (s *Store) CreateBatchJob(ctx context.Context, input interface{}, queries []string, contentHash string, opts database.JobOptions) (string, []string, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if opts.Queue == "" {
+		opts.Queue = database.DefaultQueue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.clientKeyHeld(contentHash) {
+		return "", nil, database.ErrDuplicateJob
+	}
+
+	parent := s.insertJob("batch", inputJSON, "processing", contentHash, opts)
+	parent.RequestHash = optional(opts.RequestHash)
+
+	childIDs := make([]string, 0, len(queries))
+	for i, query := range queries {
+		childInput, _ := json.Marshal(map[string]string{"query": query})
+
+		child := s.insertJob("query", childInput, "queued", fmt.Sprintf("batch:%s:%d", parent.ID, i), opts)
+		child.ParentID = &parent.ID
+		index := i
+		child.BatchIndex = &index
+
+		childIDs = append(childIDs, child.ID)
+	}
+
+	return parent.ID, childIDs, nil
+}
+
+// clientKeyHeld reports whether a live job holds a client idempotency key
+func (s *Store) clientKeyHeld(contentHash string) bool {
+	return strings.HasPrefix(contentHash, "client:") && s.liveJob(contentHash, time.Time{}) != nil
+}
+
+func (s *Store) insertJob(jobType string, input json.RawMessage, status string, contentHash string, opts database.JobOptions) *job {
+	now := time.Now()
+	j := &job{
+		Job: database.Job{
+			ID:        uuid.New().String(),
+			Type:      jobType,
+			Input:     input,
+			Status:    status,
+			Owner:     optional(opts.Owner),
+			Priority:  opts.Priority,
+			Queue:     opts.Queue,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		contentHash: contentHash,
+	}
+	s.jobs[j.ID] = j
+	return j
+}
+
+// GetJobByID returns a job, pgx.ErrNoRows if it doesn't exist
+func (s *Store) GetJobByID(ctx context.Context, id string) (*database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return j.snapshot(), nil
+}
+
+// GetJobStatus returns just the status of a job, pgx.ErrNoRows if it doesn't exist
+func (s *Store) GetJobStatus(ctx context.Context, id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return "", pgx.ErrNoRows
+	}
+	return j.Status, nil
+}
+
+// GetBatchResult returns the per-item outcomes and counts of a batch job
+func (s *Store) GetBatchResult(ctx context.Context, parentID string) (*database.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.batchResult(parentID), nil
+}
+
+func (s *Store) batchResult(parentID string) *database.BatchResult {
+	batch := &database.BatchResult{Items: []database.BatchItem{}}
+	for _, j := range s.jobs {
+		if j.ParentID == nil || *j.ParentID != parentID {
+			continue
+		}
+
+		var input struct {
+			Query string `json:"query"`
+		}
+		json.Unmarshal(j.Input, &input)
+
+		item := database.BatchItem{
+			JobID:  j.ID,
+			Query:  input.Query,
+			Status: j.Status,
+			Result: j.Result,
+			Error:  j.Error,
+		}
+		if j.BatchIndex != nil {
+			item.Index = *j.BatchIndex
+		}
+
+		switch item.Status {
+		case "completed":
+			batch.Completed++
+		case "failed":
+			batch.Failed++
+		case "cancelled":
+			batch.Cancelled++
+		default:
+			batch.Pending++
+		}
+
+		batch.Items = append(batch.Items, item)
+	}
+
+	sort.Slice(batch.Items, func(i, k int) bool {
+		return batch.Items[i].Index < batch.Items[k].Index
+	})
+	batch.Total = len(batch.Items)
+
+	return batch
+}
+
+// CheckRecentDuplicateJob returns the newest job with the same content hash created
+// within window that hasn't failed or been cancelled, or nil if there is none.
+func (s *Store) CheckRecentDuplicateJob(ctx context.Context, contentHash string, window time.Duration) (*database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if newest := s.liveJob(contentHash, time.Now().Add(-window)); newest != nil {
+		return newest.snapshot(), nil
+	}
+	return nil, nil
+}
+
+// GetLiveJobByContentHash returns the newest job with the content hash that hasn't
+// failed or been cancelled, however old, or nil if there is none
+func (s *Store) GetLiveJobByContentHash(ctx context.Context, contentHash string) (*database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if newest := s.liveJob(contentHash, time.Time{}); newest != nil {
+		return newest.snapshot(), nil
+	}
+	return nil, nil
+}
+
+// liveJob returns the newest job with the content hash created after since that
+// hasn't failed or been cancelled
+func (s *Store) liveJob(contentHash string, since time.Time) *job {
+	var newest *job
+	for _, j := range s.jobs {
+		if j.contentHash != contentHash || !j.CreatedAt.After(since) {
+			continue
+		}
+		switch j.Status {
+		case "scheduled", "queued", "processing", "completed":
+		default:
+			continue
+		}
+
+		if newest == nil || j.CreatedAt.After(newest.CreatedAt) {
+			newest = j
+		}
+	}
+	return newest
+}
+
+// ListJobs returns up to f.Limit jobs matching the filter, newest first
+func (s *Store) ListJobs(ctx context.Context, f database.JobFilter) ([]database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := []database.Job{}
+	for _, j := range s.jobs {
+		if jobMatches(j, f, false) {
+			jobs = append(jobs, j.Job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		return newerThan(jobs[i].CreatedAt, jobs[i].ID, jobs[k].CreatedAt, jobs[k].ID)
+	})
+
+	if len(jobs) > f.Limit {
+		jobs = jobs[:f.Limit]
+	}
+	return jobs, nil
+}
+
+// CountJobsByStatus returns the number of jobs per status matching the filter
+func (s *Store) CountJobsByStatus(ctx context.Context, f database.JobFilter) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, j := range s.jobs {
+		if jobMatches(j, f, true) {
+			counts[j.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// jobMatches applies a JobFilter. Status and cursor are skipped when counting,
+// so every status bucket is reported.
+func jobMatches(j *job, f database.JobFilter, forCount bool) bool {
+	switch {
+	case len(f.Statuses) > 0 && !forCount && !contains(f.Statuses, j.Status),
+		f.Type != "" && j.Type != f.Type,
+		f.Queue != "" && j.Queue != f.Queue,
+		f.CreatedAfter != nil && j.CreatedAt.Before(*f.CreatedAfter),
+		f.CreatedBefore != nil && !j.CreatedAt.Before(*f.CreatedBefore),
+		f.WorkerID != "" && (j.WorkerID == nil || *j.WorkerID != f.WorkerID),
+		f.Owner != "" && (j.Owner == nil || *j.Owner != f.Owner),
+		f.Owner == "" && j.Owner != nil,
+		f.ParentID != "" && (j.ParentID == nil || *j.ParentID != f.ParentID),
+		f.Cursor != nil && !forCount && !newerThan(f.Cursor.CreatedAt, f.Cursor.ID, j.CreatedAt, j.ID):
+		return false
+	}
+	return true
+}
+
+// newerThan orders jobs by (created_at, id) descending
+func newerThan(createdAt time.Time, id string, otherCreatedAt time.Time, otherID string) bool {
+	if !createdAt.Equal(otherCreatedAt) {
+		return createdAt.After(otherCreatedAt)
+	}
+	return id > otherID
+}
+
+// CancelJob marks a scheduled, queued or processing job as cancelled. Returns the
+// status it had, or pgx.ErrNoRows if it doesn't exist or already finished.
+func (s *Store) CancelJob(ctx context.Context, id string, reason string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok || !cancellable(j.Status) {
+		return "", pgx.ErrNoRows
+	}
+
+	previousStatus := j.Status
+	j.cancel(reason)
+	return previousStatus, nil
+}
+
+// CancelBatchItems cancels every unfinished item of a batch job and returns them
+// with the status they had
+func (s *Store) CancelBatchItems(ctx context.Context, parentID string, reason string) ([]database.CancelledBatchItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []database.CancelledBatchItem
+	for _, j := range s.jobs {
+		if j.ParentID == nil || *j.ParentID != parentID || !cancellable(j.Status) {
+			continue
+		}
+
+		items = append(items, database.CancelledBatchItem{JobID: j.ID, PreviousStatus: j.Status})
+		j.cancel(reason)
+	}
+	return items, nil
+}
+
+func cancellable(status string) bool {
+	return status == "scheduled" || status == "queued" || status == "processing"
+}
+
+func (j *job) cancel(reason string) {
+	j.Status = "cancelled"
+	j.CancelReason = &reason
+	j.release()
+}
+
+// release clears the job's lease
+func (j *job) release() {
+	j.VisibilityTimeout = nil
+	j.WorkerID = nil
+	j.UpdatedAt = time.Now()
+}
+
+// ClaimJobForProcessing moves a queued job, or a processing job whose visibility
+// timeout passed, to 'processing' under a new lease. Returns nil if the job
+// can't be claimed, along with the number of previously failed attempts.
+func (s *Store) ClaimJobForProcessing(ctx context.Context, id string, workerID string, timeoutMinutes int) (*database.JobLease, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, 0, nil
+	}
+
+	expired := j.Status == "processing" && j.VisibilityTimeout != nil && j.VisibilityTimeout.Before(time.Now())
+	if j.Status != "queued" && !expired {
+		return nil, 0, nil
+	}
+
+	visibilityTimeout := time.Now().Add(time.Duration(timeoutMinutes) * time.Minute)
+	j.Status = "processing"
+	j.VisibilityTimeout = &visibilityTimeout
+	j.WorkerID = &workerID
+	j.LeaseToken++
+	j.UpdatedAt = time.Now()
+
+	return &database.JobLease{JobID: id, WorkerID: workerID, Token: j.LeaseToken}, j.RetryCount, nil
+}
+
+// leased returns the job if lease still owns it, else a *database.LeaseConflictError
+func (s *Store) leased(lease database.JobLease) (*job, error) {
+	j, ok := s.jobs[lease.JobID]
+	if !ok {
+		return nil, &database.LeaseConflictError{Lease: lease}
+	}
+
+	if j.Status == "processing" && j.WorkerID != nil && *j.WorkerID == lease.WorkerID && j.LeaseToken == lease.Token {
+		return j, nil
+	}
+
+	conflict := &database.LeaseConflictError{Lease: lease, Status: j.Status, OwnerToken: j.LeaseToken}
+	if j.WorkerID != nil {
+		conflict.OwnerWorkerID = *j.WorkerID
+	}
+	return nil, conflict
+}
+
+// ExpireJobLease ends the visibility timeout of a processing job now, as if its
+// worker had stopped renewing it
+func (s *Store) ExpireJobLease(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[id]; ok && j.Status == "processing" {
+		expired := time.Now().Add(-time.Second)
+		j.VisibilityTimeout = &expired
+	}
+}
+
+// ExtendVisibilityTimeout pushes back the visibility timeout of a leased job
+func (s *Store) ExtendVisibilityTimeout(ctx context.Context, lease database.JobLease, additionalMinutes int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := s.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	visibilityTimeout := time.Now().Add(time.Duration(additionalMinutes) * time.Minute)
+	j.VisibilityTimeout = &visibilityTimeout
+	j.UpdatedAt = time.Now()
+	return nil
+}
+
+// UpdateJobResult completes a leased job with its result
+func (s *Store) UpdateJobResult(ctx context.Context, lease database.JobLease, result interface{}) error {
+	resultJSON, _ := json.Marshal(result)
+	raw := json.RawMessage(resultJSON)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := s.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	j.Status = "completed"
+	j.Result = &raw
+	j.release()
+	return nil
+}
+
+// UpdateJobError fails a leased job, counting the failed attempt
+func (s *Store) UpdateJobError(ctx context.Context, lease database.JobLease, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := s.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	j.Status = "failed"
+	j.Error = &errMsg
+	j.RetryCount++
+	j.release()
+	return nil
+}
+
+// RescheduleJobRetry releases a leased job back to 'scheduled' until runAt,
+// counting the failed attempt
+func (s *Store) RescheduleJobRetry(ctx context.Context, lease database.JobLease, runAt time.Time, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := s.leased(lease)
+	if err != nil {
+		return err
+	}
+
+	j.Status = "scheduled"
+	j.RunAt = &runAt
+	j.Error = &errMsg
+	j.RetryCount++
+	j.release()
+	return nil
+}
+
+// FinishBatchItem completes or fails the batch of a finished job once all its items
+// are final. Returns the batch ID ("" if the job has none) and the batch job if
+// this call finished it.
+func (s *Store) FinishBatchItem(ctx context.Context, jobID string) (string, *database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[jobID]
+	if !ok || j.ParentID == nil {
+		return "", nil, nil
+	}
+	parentID := *j.ParentID
+
+	batch := s.batchResult(parentID)
+	parent, ok := s.jobs[parentID]
+	if batch.Pending > 0 || !ok || parent.Status != "processing" {
+		return parentID, nil, nil
+	}
+
+	parent.Status = "completed"
+	if batch.Completed == 0 {
+		parent.Status = "failed"
+	}
+	if unsuccessful := batch.Failed + batch.Cancelled; unsuccessful > 0 {
+		msg := fmt.Sprintf("%d of %d items did not complete", unsuccessful, batch.Total)
+		parent.Error = &msg
+	}
+
+	resultJSON, _ := json.Marshal(batch)
+	raw := json.RawMessage(resultJSON)
+	parent.Result = &raw
+	parent.UpdatedAt = time.Now()
+
+	return parentID, parent.snapshot(), nil
+}
+
+// ReclaimTimedOutJobs reschedules processing jobs whose visibility timeout passed
+// to run immediately, recording a 'reclaimed' event for each
+func (s *Store) ReclaimTimedOutJobs(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	reclaimed := 0
+	for _, j := range s.jobs {
+		if j.Status != "processing" || j.VisibilityTimeout == nil || !j.VisibilityTimeout.Before(now) {
+			continue
+		}
+
+		previousWorker := j.WorkerID
+		j.Status = "scheduled"
+		j.RunAt = &now
+		j.release()
+
+		s.appendJobEvent(database.JobHistoryEvent{
+			JobID:    j.ID,
+			Event:    database.EventReclaimed,
+			WorkerID: previousWorker,
+		})
+		reclaimed++
+	}
+	return reclaimed, nil
+}
+
+// PruneJobs deletes up to batchSize finished jobs matching the rule, oldest update
+// first, along with their batch items and history. The rows are passed to archive
+// (if not nil) first, and nothing is deleted if it fails.
+func (s *Store) PruneJobs(ctx context.Context, rule database.RetentionRule, batchSize int, archive func([]database.Job) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-rule.MaxAge)
+	var matched []*job
+	for _, j := range s.jobs {
+		if retentionMatches(j, rule, cutoff) {
+			matched = append(matched, j)
+		}
+	}
+	sort.Slice(matched, func(a, b int) bool { return matched[a].UpdatedAt.Before(matched[b].UpdatedAt) })
+	if len(matched) > batchSize {
+		matched = matched[:batchSize]
+	}
+	if len(matched) == 0 {
+		return 0, nil
+	}
+
+	deleted := map[string]bool{}
+	rows := make([]database.Job, 0, len(matched))
+	for _, j := range matched {
+		deleted[j.ID] = true
+		rows = append(rows, j.Job)
+	}
+
+	var items []*job
+	for _, j := range s.jobs {
+		if j.ParentID != nil && deleted[*j.ParentID] {
+			items = append(items, j)
+		}
+	}
+	sort.Slice(items, func(a, b int) bool {
+		if *items[a].ParentID != *items[b].ParentID {
+			return *items[a].ParentID < *items[b].ParentID
+		}
+		return *items[a].BatchIndex < *items[b].BatchIndex
+	})
+	for _, j := range items {
+		deleted[j.ID] = true
+		rows = append(rows, j.Job)
+	}
+
+	if archive != nil {
+		if err := archive(rows); err != nil {
+			return 0, err
+		}
+	}
+
+	for id := range deleted {
+		delete(s.jobs, id)
+	}
+	events := s.jobEvents[:0]
+	for _, e := range s.jobEvents {
+		if !deleted[e.JobID] {
+			events = append(events, e)
+		}
+	}
+	s.jobEvents = events
+
+	return len(matched), nil
+}
+
+// retentionMatches mirrors database.retentionWhere
+func retentionMatches(j *job, rule database.RetentionRule, cutoff time.Time) bool {
+	switch {
+	case j.Status != rule.Status,
+		!database.IsTerminalStatus(j.Status),
+		j.ParentID != nil,
+		rule.Type != "" && j.Type != rule.Type,
+		contains(rule.ExcludeTypes, j.Type),
+		!j.UpdatedAt.Before(cutoff),
+		rule.Tenant != nil && *rule.Tenant == "" && j.Owner != nil,
+		rule.Tenant != nil && *rule.Tenant != "" && (j.Owner == nil || *j.Owner != *rule.Tenant):
+		return false
+	}
+	return true
+}
+
+// DeferQueuedJob moves a queued job that could not be pushed onto the queue back
+// to 'scheduled', due now
+func (s *Store) DeferQueuedJob(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[id]; ok && j.Status == "queued" {
+		now := time.Now()
+		j.Status = "scheduled"
+		j.RunAt = &now
+		j.UpdatedAt = now
+	}
+	return nil
+}
+
+// RecordJobEvent appends an event to a job's history. Zero-valued optional
+// fields are left empty.
+func (s *Store) RecordJobEvent(ctx context.Context, jobID string, event string, workerID string, attempt int, errMsg string, details interface{}) error {
+	detailsJSON, err := marshalOptional(details)
+	if err != nil {
+		return err
+	}
+
+	e := database.JobHistoryEvent{
+		JobID:    jobID,
+		Event:    event,
+		WorkerID: optional(workerID),
+		Error:    optional(errMsg),
+		Details:  detailsJSON,
+	}
+	if attempt != 0 {
+		e.Attempt = &attempt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendJobEvent(e)
+	return nil
+}
+
+func (s *Store) appendJobEvent(e database.JobHistoryEvent) {
+	s.nextEventID++
+	e.ID = s.nextEventID
+	e.CreatedAt = time.Now()
+	s.jobEvents = append(s.jobEvents, e)
+}
+
+// GetJobHistory returns a job's events, oldest first
+func (s *Store) GetJobHistory(ctx context.Context, jobID string) ([]database.JobHistoryEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []database.JobHistoryEvent{}
+	for _, e := range s.jobEvents {
+		if e.JobID == jobID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,194 @@
+// Package memory implements the store interfaces in process memory, so handlers
+// and the worker can be tested without Postgres or Redis. Its semantics follow
+// the Postgres and Redis implementations, minus row-level security and durability.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"notes-memory-core-rag/internal/database"
+	"notes-memory-core-rag/internal/store"
+)
+
+var (
+	_ store.NotesStore = (*Store)(nil)
+	_ store.JobStore   = (*Store)(nil)
+	_ store.UsageStore = (*Store)(nil)
+	_ store.AuditLog   = (*Store)(nil)
+	_ store.Queue      = (*Queue)(nil)
+
+	_ store.IdempotencyStore = (*Store)(nil)
+)
+
+// Store keeps notes, jobs, usage and the audit log. The zero value is not
+// usable; create one with New.
+type Store struct {
+	mu sync.Mutex
+
+	notes      []note
+	nextNoteID int
+
+	jobs        map[string]*job
+	jobEvents   []database.JobHistoryEvent
+	nextEventID int64
+
+	usage  map[usageKey]database.TenantUsage
+	quotas map[string]database.TenantQuota
+
+	audit       []database.AuditEntry
+	nextAuditID int64
+
+	idempotencyKeys map[idempotencyScopeKey]*idempotencyKey
+}
+
+// New returns an empty store
+func New() *Store {
+	return &Store{
+		jobs:   map[string]*job{},
+		usage:  map[usageKey]database.TenantUsage{},
+		quotas: map[string]database.TenantQuota{},
+
+		idempotencyKeys: map[idempotencyScopeKey]*idempotencyKey{},
+	}
+}
+
+type usageKey struct {
+	owner       string
+	periodStart time.Time
+}
+
+// GetTenantUsage returns a tenant's usage for the period starting at periodStart
+func (s *Store) GetTenantUsage(ctx context.Context, owner string, periodStart time.Time) (*database.TenantUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, ok := s.usage[usageKey{owner, periodStart.UTC()}]
+	if !ok {
+		usage = database.TenantUsage{PeriodStart: periodStart}
+	}
+	return &usage, nil
+}
+
+// AddTenantUsage adds tokens spent by one request to a tenant's period counters
+func (s *Store) AddTenantUsage(ctx context.Context, owner string, periodStart time.Time, llmTokens int64, embeddingTokens int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := usageKey{owner, periodStart.UTC()}
+	usage, ok := s.usage[key]
+	if !ok {
+		usage = database.TenantUsage{PeriodStart: periodStart}
+	}
+
+	usage.LLMTokens += llmTokens
+	usage.EmbeddingTokens += embeddingTokens
+	if llmTokens > 0 {
+		usage.LLMCalls++
+	}
+	if embeddingTokens > 0 {
+		usage.EmbeddingCalls++
+	}
+
+	s.usage[key] = usage
+	return nil
+}
+
+// GetTenantQuota returns a tenant's quota overrides, nil if it has none
+func (s *Store) GetTenantQuota(ctx context.Context, owner string) (*database.TenantQuota, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quota, ok := s.quotas[owner]
+	if !ok {
+		return nil, nil
+	}
+	return &quota, nil
+}
+
+// SetTenantQuota overrides a tenant's default quotas, like a tenant_quotas row
+func (s *Store) SetTenantQuota(owner string, quota database.TenantQuota) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.quotas[owner] = quota
+}
+
+// RecordAudit appends an entry to the audit log
+func (s *Store) RecordAudit(ctx context.Context, entry database.AuditEntry, details interface{}) error {
+	detailsJSON, err := marshalOptional(details)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextAuditID++
+	entry.ID = s.nextAuditID
+	entry.Details = detailsJSON
+	entry.CreatedAt = time.Now()
+	if entry.TargetIDs == nil {
+		entry.TargetIDs = []string{}
+	}
+
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+// ListAuditEntries returns matching entries, newest first
+func (s *Store) ListAuditEntries(ctx context.Context, filter database.AuditFilter) ([]database.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []database.AuditEntry{}
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		if e := s.audit[i]; auditMatches(e, filter) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func auditMatches(e database.AuditEntry, f database.AuditFilter) bool {
+	switch {
+	case f.Owner != "" && (e.Owner == nil || *e.Owner != f.Owner),
+		f.Actor != "" && e.Actor != f.Actor,
+		len(f.Actions) > 0 && !contains(f.Actions, e.Action),
+		f.Resource != "" && e.Resource != f.Resource,
+		f.TargetID != "" && !contains(e.TargetIDs, f.TargetID),
+		f.RequestID != "" && (e.RequestID == nil || *e.RequestID != f.RequestID),
+		f.After != nil && e.CreatedAt.Before(*f.After),
+		f.Before != nil && !e.CreatedAt.Before(*f.Before),
+		f.BeforeID > 0 && e.ID >= f.BeforeID:
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// optional stores empty strings as nil, like NULL columns
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// marshalOptional encodes a JSONB value, nil for a nil value
+func marshalOptional(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+type note struct {
+	database.Note
+	owner     string
+	embedding []float32
+}
+
+// ListNotes returns every note of a tenant, newest first
+func (s *Store) ListNotes(ctx context.Context, owner string) ([]database.Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var notes []database.Note
+	for i := len(s.notes) - 1; i >= 0; i-- {
+		if s.notes[i].owner == owner {
+			notes = append(notes, s.notes[i].Note)
+		}
+	}
+	return notes, nil
+}
+
+// CreateNote stores a note and its embedding, owned by the tenant
+func (s *Store) CreateNote(ctx context.Context, owner string, title string, content string, embedding []float32) (*database.Note, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextNoteID++
+	n := note{
+		Note: database.Note{
+			ID:        s.nextNoteID,
+			Title:     title,
+			Content:   content,
+			CreatedAt: time.Now(),
+		},
+		owner:     owner,
+		embedding: append([]float32(nil), embedding...),
+	}
+	s.notes = append(s.notes, n)
+
+	created := n.Note
+	return &created, nil
+}
+
+// UpdateNoteEmbedding replaces the embedding of one of the tenant's notes
+func (s *Store) UpdateNoteEmbedding(ctx context.Context, owner string, noteID int, embedding []float32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.notes {
+		if s.notes[i].ID == noteID && s.notes[i].owner == owner {
+			s.notes[i].embedding = append([]float32(nil), embedding...)
+		}
+	}
+	return nil
+}
+
+// SearchNotes returns the tenant's limit notes closest to embedding by Euclidean
+// distance, like pgvector's <-> operator
+func (s *Store) SearchNotes(ctx context.Context, owner string, embedding []float32, limit int) ([]database.NoteMatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []database.NoteMatch
+	for _, n := range s.notes {
+		if n.owner != owner {
+			continue
+		}
+
+		matches = append(matches, database.NoteMatch{
+			ID:        n.ID,
+			Title:     n.Title,
+			Content:   n.Content,
+			CreatedAt: n.CreatedAt,
+			Distance:  distance(n.embedding, embedding),
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Distance < matches[j].Distance
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// CountNotes returns the number of notes a tenant owns
+func (s *Store) CountNotes(ctx context.Context, owner string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, n := range s.notes {
+		if n.owner == owner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func distance(a []float32, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
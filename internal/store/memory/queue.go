@@ -0,0 +1,238 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// subscriptionBuffer is how many messages a slow subscriber may fall behind
+// before further ones are dropped, like a Redis pub/sub client
+const subscriptionBuffer = 100
+
+// Queue holds job payloads in named priority queues and delivers job status
+// events and cancellations to subscribers. Create one with NewQueue.
+type Queue struct {
+	mu sync.Mutex
+
+	// queues holds waiting payloads by queue name, in enqueue order
+	queues  map[string][]queued
+	nextSeq int64
+
+	// arrived is closed and replaced whenever a payload is enqueued
+	arrived chan struct{}
+
+	eventSubs  map[string]map[chan database.JobEvent]bool
+	cancelSubs map[chan database.JobCancellation]bool
+}
+
+type queued struct {
+	job      database.QueuedJob
+	priority int
+	seq      int64
+}
+
+// NewQueue returns an empty queue
+func NewQueue() *Queue {
+	return &Queue{
+		queues:     map[string][]queued{},
+		arrived:    make(chan struct{}),
+		eventSubs:  map[string]map[chan database.JobEvent]bool{},
+		cancelSubs: map[chan database.JobCancellation]bool{},
+	}
+}
+
+// EnqueueJob adds a job payload to its named queue
+func (q *Queue) EnqueueJob(ctx context.Context, jobID string, jobType string, input interface{}, queue string, priority int, owner string) error {
+	if queue == "" {
+		queue = database.DefaultQueue
+	}
+
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.nextSeq++
+	q.queues[queue] = append(q.queues[queue], queued{
+		job: database.QueuedJob{
+			ID:    jobID,
+			Type:  jobType,
+			Input: inputJSON,
+			Queue: queue,
+			Owner: owner,
+		},
+		priority: priority,
+		seq:      q.nextSeq,
+	})
+
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+	return nil
+}
+
+// DequeueJob waits up to timeout for a job, taking the highest priority payload
+// of the first non-empty queue in the given order. Returns nil if none arrived.
+func (q *Queue) DequeueJob(ctx context.Context, queues []string, timeout time.Duration) (*database.QueuedJob, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		job := q.pop(queues)
+		arrived := q.arrived
+		q.mu.Unlock()
+
+		if job != nil {
+			return job, nil
+		}
+
+		select {
+		case <-arrived:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pop removes the next payload from the first non-empty queue: highest priority
+// first, then FIFO
+func (q *Queue) pop(queues []string) *database.QueuedJob {
+	for _, name := range queues {
+		waiting := q.queues[name]
+		if len(waiting) == 0 {
+			continue
+		}
+
+		best := 0
+		for i, entry := range waiting {
+			if entry.priority > waiting[best].priority {
+				best = i
+			}
+		}
+
+		job := waiting[best].job
+		q.queues[name] = append(waiting[:best:best], waiting[best+1:]...)
+		return &job
+	}
+	return nil
+}
+
+// RemoveQueuedJob deletes any waiting payloads for the given job.
+// Returns the number of payloads removed.
+func (q *Queue) RemoveQueuedJob(ctx context.Context, jobID string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	removed := 0
+	for name, waiting := range q.queues {
+		kept := waiting[:0:0]
+		for _, entry := range waiting {
+			if entry.job.ID == jobID {
+				removed++
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		q.queues[name] = kept
+	}
+	return removed, nil
+}
+
+// QueueDepths returns the number of payloads waiting in each queue that ever received a job
+func (q *Queue) QueueDepths(ctx context.Context) (map[string]int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := make(map[string]int64, len(q.queues))
+	for name, waiting := range q.queues {
+		depths[name] = int64(len(waiting))
+	}
+	return depths, nil
+}
+
+// PublishJobEvent delivers a status transition to the job's subscribers
+func (q *Queue) PublishJobEvent(ctx context.Context, event database.JobEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ch := range q.eventSubs[event.JobID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// SubscribeJobEvents delivers a job's status transitions until unsubscribe is called
+func (q *Queue) SubscribeJobEvents(ctx context.Context, jobID string) (<-chan database.JobEvent, func(), error) {
+	ch := make(chan database.JobEvent, subscriptionBuffer)
+
+	q.mu.Lock()
+	if q.eventSubs[jobID] == nil {
+		q.eventSubs[jobID] = map[chan database.JobEvent]bool{}
+	}
+	q.eventSubs[jobID][ch] = true
+	q.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			delete(q.eventSubs[jobID], ch)
+			if len(q.eventSubs[jobID]) == 0 {
+				delete(q.eventSubs, jobID)
+			}
+			close(ch)
+		})
+	}, nil
+}
+
+// PublishJobCancellation notifies subscribed workers that a job should stop processing
+func (q *Queue) PublishJobCancellation(ctx context.Context, jobID string, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for ch := range q.cancelSubs {
+		select {
+		case ch <- database.JobCancellation{JobID: jobID, Reason: reason}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SubscribeJobCancellations delivers published cancellations until unsubscribe is called
+func (q *Queue) SubscribeJobCancellations(ctx context.Context) (<-chan database.JobCancellation, func(), error) {
+	ch := make(chan database.JobCancellation, subscriptionBuffer)
+
+	q.mu.Lock()
+	q.cancelSubs[ch] = true
+	q.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+
+			delete(q.cancelSubs, ch)
+			close(ch)
+		})
+	}, nil
+}
@@ -0,0 +1,115 @@
+// Package store declares the storage the API handlers and the worker depend on.
+// Postgres (database.DB) and Redis (database.Redis) implement it in production;
+// the memory package implements it for tests that run without either.
+//
+// Admin data (API keys, schedules, worker heartbeats, webhook deliveries)
+// and maintenance tasks still use database.DB directly.
+package store
+
+import (
+	"context"
+	"time"
+
+	"notes-memory-core-rag/internal/database"
+)
+
+// NotesStore holds notes and their embeddings. Every call is scoped to one
+// tenant (owner); an empty owner is the unauthenticated tenant.
+type NotesStore interface {
+	ListNotes(ctx context.Context, owner string) ([]database.Note, error)
+	CreateNote(ctx context.Context, owner string, title string, content string, embedding []float32) (*database.Note, error)
+	UpdateNoteEmbedding(ctx context.Context, owner string, noteID int, embedding []float32) error
+
+	// SearchNotes returns up to limit notes, closest to embedding first
+	SearchNotes(ctx context.Context, owner string, embedding []float32, limit int) ([]database.NoteMatch, error)
+	CountNotes(ctx context.Context, owner string) (int64, error)
+}
+
+// JobStore holds async jobs, their history and batches. Lookups of unknown jobs
+// fail with pgx.ErrNoRows; writes with a stale lease fail with database.ErrJobNotOwned.
+// Creating a job whose client idempotency key is held fails with database.ErrDuplicateJob.
+type JobStore interface {
+	CreateJob(ctx context.Context, jobType string, input interface{}, contentHash string, opts database.JobOptions) (string, error)
+	CreateBatchJob(ctx context.Context, input interface{}, queries []string, contentHash string, opts database.JobOptions) (string, []string, error)
+	GetJobByID(ctx context.Context, id string) (*database.Job, error)
+	GetJobStatus(ctx context.Context, id string) (string, error)
+	GetBatchResult(ctx context.Context, parentID string) (*database.BatchResult, error)
+	CheckRecentDuplicateJob(ctx context.Context, contentHash string, window time.Duration) (*database.Job, error)
+	GetLiveJobByContentHash(ctx context.Context, contentHash string) (*database.Job, error)
+	ListJobs(ctx context.Context, f database.JobFilter) ([]database.Job, error)
+	CountJobsByStatus(ctx context.Context, f database.JobFilter) (map[string]int, error)
+
+	CancelJob(ctx context.Context, id string, reason string) (string, error)
+	CancelBatchItems(ctx context.Context, parentID string, reason string) ([]database.CancelledBatchItem, error)
+
+	// DeferQueuedJob hands a queued job that couldn't be pushed onto the queue to the scheduler
+	DeferQueuedJob(ctx context.Context, id string) error
+
+	// Worker side: claim a job, keep its lease and store the outcome
+	ClaimJobForProcessing(ctx context.Context, id string, workerID string, timeoutMinutes int) (*database.JobLease, int, error)
+	ExtendVisibilityTimeout(ctx context.Context, lease database.JobLease, additionalMinutes int) error
+	UpdateJobResult(ctx context.Context, lease database.JobLease, result interface{}) error
+	UpdateJobError(ctx context.Context, lease database.JobLease, errMsg string) error
+	RescheduleJobRetry(ctx context.Context, lease database.JobLease, runAt time.Time, errMsg string) error
+	FinishBatchItem(ctx context.Context, jobID string) (string, *database.Job, error)
+	ReclaimTimedOutJobs(ctx context.Context) (int, error)
+
+	// PruneJobs deletes up to batchSize finished jobs matching rule, with their batch items,
+	// passing them to archive (if not nil) first
+	PruneJobs(ctx context.Context, rule database.RetentionRule, batchSize int, archive func([]database.Job) error) (int, error)
+
+	RecordJobEvent(ctx context.Context, jobID string, event string, workerID string, attempt int, errMsg string, details interface{}) error
+	GetJobHistory(ctx context.Context, jobID string) ([]database.JobHistoryEvent, error)
+}
+
+// Queue carries job payloads from the API to the workers, along with job status
+// events and cancellations. Optional: nil when Redis is unavailable.
+type Queue interface {
+	EnqueueJob(ctx context.Context, jobID string, jobType string, input interface{}, queue string, priority int, owner string) error
+
+	// DequeueJob waits up to timeout for a job from the first non-empty queue
+	// in order. Returns nil if none arrived.
+	DequeueJob(ctx context.Context, queues []string, timeout time.Duration) (*database.QueuedJob, error)
+	RemoveQueuedJob(ctx context.Context, jobID string) (int, error)
+	QueueDepths(ctx context.Context) (map[string]int64, error)
+
+	// Subscriptions are active once the call returns and deliver until unsubscribe is called
+	PublishJobEvent(ctx context.Context, event database.JobEvent) error
+	SubscribeJobEvents(ctx context.Context, jobID string) (events <-chan database.JobEvent, unsubscribe func(), err error)
+	PublishJobCancellation(ctx context.Context, jobID string, reason string) error
+	SubscribeJobCancellations(ctx context.Context) (cancellations <-chan database.JobCancellation, unsubscribe func(), err error)
+}
+
+// UsageStore holds token usage and quota overrides per tenant (see quota)
+type UsageStore interface {
+	GetTenantUsage(ctx context.Context, owner string, periodStart time.Time) (*database.TenantUsage, error)
+	AddTenantUsage(ctx context.Context, owner string, periodStart time.Time, llmTokens int64, embeddingTokens int64) error
+	GetTenantQuota(ctx context.Context, owner string) (*database.TenantQuota, error)
+	CountNotes(ctx context.Context, owner string) (int64, error)
+}
+
+// AuditLog records data access and mutations
+type AuditLog interface {
+	RecordAudit(ctx context.Context, entry database.AuditEntry, details interface{}) error
+	ListAuditEntries(ctx context.Context, filter database.AuditFilter) ([]database.AuditEntry, error)
+}
+
+// IdempotencyStore reserves Idempotency-Key headers and keeps responses for
+// replay (see middleware.Idempotency). A reservation is identified by the token
+// ReserveIdempotencyKey returns; saves and releases with a stale token do nothing.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, lease time.Duration) (token string, existing *database.IdempotencyRecord, err error)
+	SaveIdempotentResponse(ctx context.Context, scope string, key string, token string, resp database.StoredResponse, ttl time.Duration) error
+	ReleaseIdempotencyKey(ctx context.Context, scope string, key string, token string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+}
+
+var (
+	_ NotesStore = (*database.DB)(nil)
+	_ JobStore   = (*database.DB)(nil)
+	_ UsageStore = (*database.DB)(nil)
+	_ AuditLog   = (*database.DB)(nil)
+	_ Queue      = (*database.Redis)(nil)
+
+	_ IdempotencyStore = (*database.DB)(nil)
+)
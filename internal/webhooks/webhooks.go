@@ -0,0 +1,277 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"notes-memory-core-rag/internal/config"
+	"notes-memory-core-rag/internal/database"
+)
+
+// Headers sent with every callback
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	JobIDHeader     = "X-Job-ID"
+)
+
+const (
+	maxDeliveryAttempts = 5
+	initialBackoff      = 1 * time.Second
+	requestTimeout      = 10 * time.Second
+	dialTimeout         = 5 * time.Second
+)
+
+// ErrUnsignedCallback is returned while callbacks must be signed but WEBHOOK_SECRET is not set
+var ErrUnsignedCallback = errors.New("callbacks are disabled: WEBHOOK_SECRET is not set")
+
+// errBlockedAddress is returned when a callback host connects to a non-public address
+var errBlockedAddress = errors.New("callback address is not public")
+
+// blockedNetworks are non-public ranges the net.IP predicates don't cover
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this network"
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT, also used for cloud metadata
+}
+
+var httpClient = newHTTPClient()
+
+// newHTTPClient returns a client that re-checks every address it connects to,
+// so a callback host can't resolve to a public address when validated and an
+// internal one when delivered, or redirect to one
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Connect directly: through a proxy, the check would only see the proxy's address
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout: dialTimeout,
+		Control: checkDialAddress,
+	}).DialContext
+
+	return &http.Client{Timeout: requestTimeout, Transport: transport}
+}
+
+// Sender delivers job callbacks signed with WEBHOOK_SECRET and records every
+// attempt in webhook_deliveries
+type Sender struct {
+	secret           string
+	requireSignature bool
+	db               *database.DB
+}
+
+// NewSender returns a sender signing with cfg.Secret
+func NewSender(cfg config.Webhooks, db *database.DB) *Sender {
+	return &Sender{secret: cfg.Secret, requireSignature: cfg.RequireSignature, db: db}
+}
+
+// CheckSigning returns ErrUnsignedCallback if cfg refuses unsigned callbacks and has no secret
+func CheckSigning(cfg config.Webhooks) error {
+	if cfg.RequireSignature && cfg.Secret == "" {
+		return ErrUnsignedCallback
+	}
+	return nil
+}
+
+// ValidateCallbackURL checks that a client-supplied callback URL is an absolute http(s) URL
+// whose host only resolves to public addresses, so callbacks can't reach internal services.
+func ValidateCallbackURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback_url must use http or https")
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("callback_url must include a host")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("callback_url host %q could not be resolved", host)
+	}
+
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return fmt.Errorf("callback_url must not point to a private, loopback or link-local address")
+		}
+	}
+
+	return nil
+}
+
+// checkDialAddress rejects connections to non-public addresses. It runs after
+// DNS resolution, on the address actually being dialed.
+func checkDialAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", errBlockedAddress, host)
+	}
+
+	return nil
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// Sign computes the HMAC-SHA256 signature of "<timestamp>.<body>" using the shared secret.
+// Receivers verify callbacks by recomputing this value and comparing it to SignatureHeader.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver POSTs the payload to callbackURL, retrying with exponential backoff on
+// network errors and non-2xx responses. Every attempt is recorded in webhook_deliveries.
+// Signed with WEBHOOK_SECRET; callbacks are sent unsigned if it is not set, unless
+// signing is required, in which case they fail with ErrUnsignedCallback.
+func (s *Sender) Deliver(ctx context.Context, jobID string, callbackURL string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if s.secret == "" {
+		if s.requireSignature {
+			msg := ErrUnsignedCallback.Error()
+			if recordErr := s.db.RecordWebhookDelivery(ctx, database.WebhookDelivery{
+				JobID:   jobID,
+				URL:     callbackURL,
+				Attempt: 1,
+				Error:   &msg,
+			}); recordErr != nil {
+				log.Warn().Err(recordErr).Str("job_id", jobID).Msg("failed to record webhook delivery")
+			}
+			return ErrUnsignedCallback
+		}
+
+		log.Warn().Str("job_id", jobID).Msg("WEBHOOK_SECRET not set, sending unsigned callback")
+	}
+
+	backoff := initialBackoff
+	var lastErr error
+
+	for attempt := 1; attempt <= maxDeliveryAttempts; attempt++ {
+		statusCode, err := post(ctx, jobID, callbackURL, s.secret, body)
+
+		delivery := database.WebhookDelivery{
+			JobID:     jobID,
+			URL:       callbackURL,
+			Attempt:   attempt,
+			Delivered: err == nil,
+		}
+		if statusCode != 0 {
+			delivery.StatusCode = &statusCode
+		}
+		if err != nil {
+			msg := err.Error()
+			delivery.Error = &msg
+		}
+
+		if recordErr := s.db.RecordWebhookDelivery(ctx, delivery); recordErr != nil {
+			log.Warn().Err(recordErr).Str("job_id", jobID).Msg("failed to record webhook delivery")
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		lastErr = err
+		log.Warn().
+			Err(err).
+			Str("job_id", jobID).
+			Int("attempt", attempt).
+			Msg("webhook delivery failed")
+
+		// The host resolves to an internal address; retrying won't change that
+		if attempt == maxDeliveryAttempts || errors.Is(err, errBlockedAddress) {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fmt.Errorf("webhook delivery failed: %w", lastErr)
+}
+
+// post performs a single signed delivery attempt and returns the HTTP status code (0 if no response)
+func post(ctx context.Context, jobID string, callbackURL string, secret string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(JobIDHeader, jobID)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
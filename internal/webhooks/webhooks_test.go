@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateCallbackURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hooks/jobs", false},
+		{"http://8.8.8.8:8080/callback", false},
+
+		{"http://127.0.0.1/callback", true},
+		{"http://localhost:9000/callback", true},
+		{"http://[::1]/callback", true},
+		{"http://[::ffff:127.0.0.1]/callback", true},
+		{"http://10.0.0.5/callback", true},
+		{"http://172.16.3.4/callback", true},
+		{"http://192.168.1.10/callback", true},
+		{"http://169.254.169.254/latest/meta-data", true}, // cloud metadata
+		{"http://100.100.100.200/latest/meta-data", true}, // carrier-grade NAT metadata
+		{"http://0.0.0.0:8080/callback", true},
+		{"http://[fe80::1]/callback", true},
+		{"http://[fd00::1]/callback", true},
+
+		{"ftp://8.8.8.8/callback", true},
+		{"file:///etc/passwd", true},
+		{"http:///callback", true},
+		{"not a url\x7f", true},
+	}
+
+	for _, tt := range tests {
+		err := ValidateCallbackURL(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateCallbackURL(%q) = %v, want an error: %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"100.64.0.1", false},
+		{"0.1.2.3", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"::", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPostBlocksInternalAddresses(t *testing.T) {
+	var called atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer server.Close()
+
+	// A host may resolve to a public address when the URL is validated and to
+	// an internal one later, so the dialed address is checked again
+	status, err := post(context.Background(), "job-1", server.URL, "secret", []byte(`{}`))
+	if !errors.Is(err, errBlockedAddress) || status != 0 {
+		t.Fatalf("post = (%d, %v), want errBlockedAddress", status, err)
+	}
+	if called.Load() {
+		t.Fatal("the callback reached the loopback server")
+	}
+}
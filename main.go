@@ -1,78 +1,190 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/joho/godotenv"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"notes-memory-core-rag/internal/ai"
+	"notes-memory-core-rag/internal/auth"
+	"notes-memory-core-rag/internal/config"
 	"notes-memory-core-rag/internal/database"
 	"notes-memory-core-rag/internal/handlers"
 	"notes-memory-core-rag/internal/middleware"
+	"notes-memory-core-rag/internal/quota"
+	"notes-memory-core-rag/internal/store"
+)
+
+// Rate limit costs: what a request spends from its caller's budget
+const (
+	costRead  = 1  // CRUD and status reads
+	costEmbed = 2  // one embedding call
+	costLLM   = 10 // embedding + LLM completion, sync or async; batches spend it per query
 )
 
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Warn().Msg("No .env file found, using system environment variables")
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	flag.Parse()
+
+	// Load settings from the environment, .env and the config file
+	cfg, err := config.Load(*configPath, config.RequireAI)
+	if err != nil {
+		log.Fatal().Msg("❌ " + err.Error())
 	}
 
 	// Connect to Postgres and run migrations
-	database.Connect()
+	db := database.Connect(cfg.Database)
+
+	// Connect to Redis; without it async jobs are disabled
+	redis := database.InitRedis(cfg.Redis)
 
-	// Connect to Redis
-	database.InitRedis()
+	// Assigned only when connected, so a nil *database.Redis doesn't make queue non-nil
+	var queue store.Queue
+	if redis != nil {
+		queue = redis
+	}
+
+	quotas := quota.New(cfg.Quota, db)
+	h := handlers.New(handlers.Deps{
+		Notes:             db,
+		Jobs:              db,
+		Queue:             queue,
+		Audit:             db,
+		Quotas:            quotas,
+		Embedder:          ai.NewEmbedder(cfg.AI),
+		Responder:         ai.NewResponder(cfg.AI),
+		DB:                db,
+		IdempotencyWindow: cfg.Idempotency.Window,
+		Webhooks:          cfg.Webhooks,
+	})
 
 	// Create Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		BodyLimit:    cfg.Server.BodyLimit,
+		ErrorHandler: middleware.ErrorHandler,
+	})
+
+	// Security headers and CORS, tuned per ENV
+	app.Use(middleware.SecurityHeaders(cfg.Server))
+	app.Use(middleware.CORS(cfg.Server.CORS))
 
-	// CORS enabled
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,OPTIONS",
-	}))
+	// Request IDs (X-Request-ID, generated unless the client sent one) for logs and the audit log
+	app.Use(requestid.New())
 
 	// Middleware
 	app.Use(middleware.MetricsMiddleware)
 	app.Use(middleware.LoggerMiddleware)
-	app.Use(middleware.RateLimit())
+
+	// Per-IP limit ahead of authentication, so credential floods stay cheap
+	app.Use(middleware.IPRateLimit(cfg.RateLimit, redis))
+
+	// Authentication (everything except /health)
+	app.Use(middleware.Auth(cfg.Auth, db))
+
+	// Replays responses of mutating requests retried with the same Idempotency-Key.
+	// Job enqueue endpoints deduplicate by key themselves. Routes returning secrets
+	// (API key creation and rotation) never use it, so plaintext keys aren't stored.
+	idempotent := middleware.Idempotency(cfg.Idempotency, db)
+
+	// Each route declares the permission its caller's role must grant (403 otherwise)
+	require := middleware.Require
+
+	// Each route spends its cost from the caller's rate limit budget (429 once it runs out)
+	limiter := middleware.RateLimit(cfg.RateLimit, redis)
+	limit := limiter.Cost
 
 	// Base routes (health + notes CRUD)
 	app.Get("/health", handlers.HealthCheck)
-	app.Get("/notes", handlers.GetNotes)
-	app.Post("/notes", handlers.CreateNote)
+	app.Get("/notes", require(auth.PermReadNotes), limit(costRead), h.GetNotes)
+	app.Post("/notes", require(auth.PermWriteNotes), limit(costEmbed), idempotent, h.CreateNote)
 
 	// RAG routes
-	app.Post("/query", handlers.Query)
-	app.Post("/search", handlers.SemanticSearch)
+	app.Post("/query", require(auth.PermQuery), limit(costLLM), h.Query)
+	app.Post("/search", require(auth.PermQuery), limit(costEmbed), h.SemanticSearch)
 
 	// Asynchronous - Using Worker
-	app.Post("/jobs/query", handlers.EnqueueQueryJob)
+	app.Post("/jobs/query", require(auth.PermRunJobs), limit(costLLM), h.EnqueueQueryJob)
+
+	// Enqueue a batch of query jobs tracked by one parent job
+	app.Post("/jobs/batch", require(auth.PermRunJobs), limiter.CostFunc(handlers.BatchCost(costLLM)), h.EnqueueBatchJob)
+
+	// List jobs with filters, pagination and status counts
+	app.Get("/jobs", require(auth.PermReadJobs), limit(costRead), h.ListJobs)
 
 	// Retrieve Job Status by ID
-	app.Get("/jobs/:id", handlers.GetJob)
+	app.Get("/jobs/:id", require(auth.PermReadJobs), limit(costRead), h.GetJob)
+
+	// Live job status updates (Server-Sent Events)
+	app.Get("/jobs/:id/events", require(auth.PermReadJobs), limit(costRead), h.StreamJobEvents)
+
+	// Full transition history of a job
+	app.Get("/jobs/:id/events/history", require(auth.PermReadJobs), limit(costRead), h.GetJobHistory)
+
+	// Webhook delivery attempts for a job
+	app.Get("/jobs/:id/deliveries", require(auth.PermReadJobs), limit(costRead), h.GetJobDeliveries)
+
+	// Cancel a queued or running job
+	app.Delete("/jobs/:id", require(auth.PermCancelJobs), limit(costRead), idempotent, h.CancelJob)
+	app.Post("/jobs/:id/cancel", require(auth.PermCancelJobs), limit(costRead), idempotent, h.CancelJob)
+
+	// Tenant usage against its quotas
+	app.Get("/usage", require(auth.PermReadUsage), limit(costRead), h.GetUsage)
+
+	// Worker fleet status (heartbeats)
+	app.Get("/workers", require(auth.PermAdminJobs), limit(costRead), h.ListWorkers)
+
+	// Recurring job schedules (cron)
+	app.Get("/schedules", require(auth.PermReadJobs), limit(costRead), h.ListSchedules)
+	app.Post("/schedules", require(auth.PermWriteSchedules), limit(costRead), idempotent, h.CreateSchedule)
+	app.Delete("/schedules/:id", require(auth.PermWriteSchedules), limit(costRead), idempotent, h.DeleteSchedule)
+
+	// API key management
+	app.Get("/api-keys", require(auth.PermManageAPIKeys), limit(costRead), h.ListAPIKeys)
+	app.Post("/api-keys", require(auth.PermManageAPIKeys), limit(costRead), h.CreateAPIKey)
+	app.Post("/api-keys/:id/rotate", require(auth.PermManageAPIKeys), limit(costRead), h.RotateAPIKey)
+	app.Delete("/api-keys/:id", require(auth.PermManageAPIKeys), limit(costRead), h.RevokeAPIKey)
+
+	// Audit log of data access and mutations
+	app.Get("/audit", require(auth.PermReadAudit), limit(costRead), h.ListAuditLog)
 
 	// Metrics endpoint
-	app.Get("/metrics", func(c *fiber.Ctx) error {
-		return c.JSON(middleware.GetMetrics())
+	app.Get("/metrics", require(auth.PermReadMetrics), limit(costRead), func(c *fiber.Ctx) error {
+		metrics := middleware.GetMetrics()
+
+		// Per-queue depth of waiting async jobs
+		if queue != nil {
+			depths, err := queue.QueueDepths(context.Background())
+			if err != nil {
+				log.Warn().Err(err).Msg("failed to read queue depths")
+			} else {
+				metrics["queue_depths"] = depths
+			}
+		}
+
+		// Stale worker writes rejected by job lease fencing
+		conflicts, err := db.CountLeaseConflicts(context.Background())
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to count lease conflicts")
+		} else {
+			metrics["job_lease_conflicts"] = conflicts
+		}
+
+		return c.JSON(metrics)
 	})
 
-	// Port
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
 	log.Info().
-		Str("port", port).
+		Str("port", cfg.Server.Port).
+		Str("env", cfg.Env).
 		Msg("🚀 Starting RAG Notes API")
 
-	if err := app.Listen(":" + port); err != nil {
+	if err := app.Listen(":" + cfg.Server.Port); err != nil {
 		log.Fatal().Err(err).Msg("❌ Server failed to start")
 	}
 }